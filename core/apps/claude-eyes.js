@@ -85,8 +85,8 @@ ALGO.app.category = 'development';
           ">
             Waiting for MCP commands...<br><br>
             <span style="font-size: 11px; color: #475569;">
-              Run Claude locally and send commands via:<br>
-              curl -X POST https://functionserver.com/api/mcp ...
+              Run Claude locally and connect it with:<br>
+              claude mcp add --transport http fs https://functionserver.com/api/mcp
             </span>
           </div>
         </div>
@@ -201,7 +201,7 @@ ALGO.app.category = 'development';
 
     // WebSocket connection
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/api/pty?token=${encodeURIComponent(sessionToken)}`;
+    const wsUrl = `${protocol}//${window.location.host}/api/pty?token=${encodeURIComponent(sessionToken)}&page=${window.algoPageId || ''}`;
 
     let ws;
     let reconnectAttempts = 0;
@@ -181,19 +181,71 @@ ALGO.app.category = 'development';
       if ((e.metaKey || (e.ctrlKey && e.shiftKey)) && e.key === 'v') {
         navigator.clipboard.readText().then(text => {
           if (text && ws && ws.readyState === WebSocket.OPEN) {
-            ws.send(text);
+            sendInput(text);
           }
         }).catch(() => {});
         e.preventDefault();
       }
     });
 
+    // PTY framing: binary frames are terminal data, text frames are JSON
+    // control messages (fs-pty.v1). Falls back to the legacy string format
+    // if the server doesn't speak v1.
+    const PTY_PROTOCOL = 'fs-pty.v1';
+    const encoder = new TextEncoder();
+    function isV1() {
+      return ws && ws.protocol === PTY_PROTOCOL;
+    }
+    function sendInput(data) {
+      ws.send(isV1() && typeof data === 'string' ? encoder.encode(data) : data);
+    }
+    function sendResize(cols, rows) {
+      ws.send(isV1() ? JSON.stringify({ type: 'resize', cols, rows }) : `RESIZE:${cols}:${rows}`);
+    }
+    function sendEyeResult(id, data, error) {
+      if (isV1()) {
+        ws.send(JSON.stringify(error ? { type: 'eye', id, error } : { type: 'eye', id, data }));
+      } else {
+        ws.send('EYE:' + id + (error ? '!:' + error : ':' + data));
+      }
+    }
+    function sendMCPResult(id, result) {
+      if (result === undefined) result = null;
+      if (isV1()) {
+        ws.send(JSON.stringify({ type: 'mcp_resp', id, result }));
+      } else {
+        ws.send('MCP_RESP:' + id + ':' + JSON.stringify(result));
+      }
+    }
+
+    // MCP bridge commands: the server routes MCP tool calls to this socket
+    // like to any other PTY connection
+    async function runMCPCommand(id, cmd) {
+      const tool = cmd._bridge;
+      const args = cmd._args || [];
+      const activity = (entry) => ALGO.pubsub && ALGO.pubsub.publish('mcp-activity',
+        Object.assign({ tool, args, timestamp: Date.now() }, entry));
+      if (!tool || !ALGO.bridge || !ALGO.bridge[tool]) {
+        sendMCPResult(id, { success: false, error: 'Unknown bridge command' });
+        activity({ error: 'Unknown bridge command', success: false });
+        return;
+      }
+      try {
+        const result = await ALGO.bridge[tool](...args);
+        sendMCPResult(id, result);
+        activity({ result, success: true });
+      } catch (e) {
+        sendMCPResult(id, { success: false, error: e.message });
+        activity({ error: e.message, success: false });
+      }
+    }
+
     // Fit to container (multiple passes for reliable sizing)
     function doFit() {
       fitAddon.fit();
       const dims = fitAddon.proposeDimensions();
       if (dims && ws && ws.readyState === WebSocket.OPEN) {
-        ws.send(`RESIZE:${dims.cols}:${dims.rows}`);
+        sendResize(dims.cols, dims.rows);
       }
     }
     setTimeout(doFit, 50);
@@ -202,14 +254,14 @@ ALGO.app.category = 'development';
 
     // Connect to WebSocket PTY
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/api/pty?token=${encodeURIComponent(sessionToken)}`;
+    const wsUrl = `${protocol}//${window.location.host}/api/pty?token=${encodeURIComponent(sessionToken)}&page=${window.algoPageId || ''}`;
 
     let ws;
     let reconnectAttempts = 0;
     const maxReconnectAttempts = 3;
 
     function connect() {
-      ws = new WebSocket(wsUrl);
+      ws = new WebSocket(wsUrl, [PTY_PROTOCOL]);
       ws.binaryType = 'arraybuffer';
 
       ws.onopen = () => {
@@ -220,19 +272,49 @@ ALGO.app.category = 'development';
         // Send initial size
         const dims = fitAddon.proposeDimensions();
         if (dims) {
-          ws.send(`RESIZE:${dims.cols}:${dims.rows}`);
+          sendResize(dims.cols, dims.rows);
         }
       };
 
       ws.onmessage = (event) => {
+        // v1 control frames
+        let eyeCmd = null;
+        if (typeof event.data === 'string' && isV1()) {
+          let ctl;
+          try { ctl = JSON.parse(event.data); } catch (e) { return; }
+          if (ctl.type === 'eye_cmd') {
+            eyeCmd = { id: ctl.id || '', expression: ctl.expr || '' };
+          } else if (ctl.type === 'mcp_cmd') {
+            runMCPCommand(ctl.id, ctl.command || {});
+            return;
+          } else if (ctl.type === 'error') {
+            term.write(`\r\n\x1b[31m${ctl.error}\x1b[0m\r\n`);
+            return;
+          } else {
+            return;
+          }
+        } else if (typeof event.data === 'string' && event.data.startsWith('MCP_CMD:')) {
+          try {
+            const cmd = JSON.parse(event.data.slice(8)); // Skip "MCP_CMD:"
+            runMCPCommand(cmd._mcpReqId, cmd);
+          } catch (e) {
+            console.error('MCP command error:', e);
+          }
+          return;
+        } else if (typeof event.data === 'string' && event.data.startsWith('EYE_CMD:')) {
+          const payload = event.data.slice(8); // Skip "EYE_CMD:"
+          const colonIdx = payload.indexOf(':');
+          eyeCmd = {
+            id: colonIdx > 0 ? payload.substring(0, colonIdx) : '',
+            expression: colonIdx >= 0 ? payload.substring(colonIdx + 1) : payload
+          };
+        }
+
         // Handle Eye bridge commands (direct AI-to-browser JS evaluation)
-        if (typeof event.data === 'string' && event.data.startsWith('EYE_CMD:')) {
+        if (eyeCmd) {
           (async () => {
             try {
-              const payload = event.data.slice(8); // Skip "EYE_CMD:"
-              const colonIdx = payload.indexOf(':');
-              const id = colonIdx > 0 ? payload.substring(0, colonIdx) : '';
-              const expression = colonIdx >= 0 ? payload.substring(colonIdx + 1) : payload;
+              const { id, expression } = eyeCmd;
 
               // Evaluate the expression in the browser context
               let result, error = null;
@@ -250,7 +332,7 @@ ALGO.app.category = 'development';
               if (id) {
                 let resultStr;
                 if (error) {
-                  ws.send('EYE:' + id + '!:' + error);
+                  sendEyeResult(id, null, error);
                 } else {
                   if (result === undefined) resultStr = 'undefined';
                   else if (result === null) resultStr = 'null';
@@ -258,7 +340,7 @@ ALGO.app.category = 'development';
                     try { resultStr = JSON.stringify(result); }
                     catch (e) { resultStr = String(result); }
                   } else resultStr = String(result);
-                  ws.send('EYE:' + id + ':' + resultStr);
+                  sendEyeResult(id, resultStr, null);
                 }
               }
             } catch (e) {
@@ -307,7 +389,7 @@ ALGO.app.category = 'development';
     // Handle terminal input
     term.onData(data => {
       if (ws && ws.readyState === WebSocket.OPEN) {
-        ws.send(data);
+        sendInput(data);
       }
     });
 
@@ -327,7 +409,7 @@ ALGO.app.category = 'development';
       fitAddon.fit();
       const dims = fitAddon.proposeDimensions();
       if (dims && ws && ws.readyState === WebSocket.OPEN) {
-        ws.send(`RESIZE:${dims.cols}:${dims.rows}`);
+        sendResize(dims.cols, dims.rows);
       }
     });
     resizeObserver.observe(container);
@@ -345,7 +427,7 @@ ALGO.app.category = 'development';
       if (ws && ws.readyState === WebSocket.OPEN) {
         if (killSession) {
           // User explicitly closed window - kill the tmux session
-          ws.send('CLOSE_SESSION');
+          ws.send(isV1() ? JSON.stringify({ type: 'close_session' }) : 'CLOSE_SESSION');
         }
         ws.close();
       }
@@ -75,7 +75,7 @@ function _tm_renderList() {
   const esc = typeof escapeHtml === 'function' ? escapeHtml : (s => s);
   list.innerHTML = _tm_tickets.map(t =>
     '<div class="ticket-item' + (_tm_selected === t.id ? ' selected' : '') + '" onclick="_tm_select(\'' + t.id + '\')">' +
-      '<span class="ticket-status ' + t.status + '">' + t.status + '</span>' +
+      '<span class="ticket-status ' + t.status + '">' + _tm_statusLabel(t.status) + '</span>' +
       '<div class="ticket-info">' +
         '<div class="ticket-title">' + esc(t.title) + '</div>' +
         '<div class="ticket-desc">' + esc(t.description || 'No description') + '</div>' +
@@ -97,7 +97,7 @@ function _tm_select(ticketId) {
 
   const esc = typeof escapeHtml === 'function' ? escapeHtml : (s => s);
   let html = '<h3>🎫 ' + esc(ticket.title) + '</h3>' +
-    '<p><strong>Status:</strong> <span class="ticket-status ' + ticket.status + '">' + ticket.status + '</span></p>' +
+    '<p><strong>Status:</strong> <span class="ticket-status ' + ticket.status + '">' + _tm_statusLabel(ticket.status) + '</span></p>' +
     '<p><strong>Created:</strong> ' + ticket.created + '</p>';
 
   if (ticket.description) {
@@ -105,8 +105,14 @@ function _tm_select(ticketId) {
   }
 
   html += '<div style="margin:10px 0;display:flex;gap:4px;flex-wrap:wrap;">';
-  if (ticket.status !== 'working' && ticket.status !== 'complete') {
-    html += '<button onclick="_tm_claim(\'' + ticketId + '\')">🔧 Claim (Working)</button>';
+  if (ticket.status === 'open') {
+    html += '<button onclick="_tm_claim(\'' + ticketId + '\')">🔧 Claim (In Progress)</button>';
+    html += '<button onclick="_tm_setStatus(\'' + ticketId + '\', \'closed\')">✖ Close</button>';
+  } else if (ticket.status === 'in_progress') {
+    html += '<button onclick="_tm_setStatus(\'' + ticketId + '\', \'done\')">✔ Mark Done</button>';
+    html += '<button onclick="_tm_setStatus(\'' + ticketId + '\', \'open\')">↩ Unclaim</button>';
+  } else {
+    html += '<button onclick="_tm_setStatus(\'' + ticketId + '\', \'open\')">↩ Reopen</button>';
   }
   html += '<button onclick="_tm_copyInstructions(\'' + ticketId + '\')">📋 Copy Agent Instructions</button>';
   html += '</div>';
@@ -114,17 +120,64 @@ function _tm_select(ticketId) {
   if (ticket.replies && ticket.replies.length > 0) {
     html += '<p><strong>Replies:</strong></p>';
     ticket.replies.forEach(r => {
-      html += '<div class="ticket-reply"><div class="reply-date">' + r.date + '</div>' + esc(r.text) + '</div>';
+      html += '<div class="ticket-reply"><div class="reply-date">' + r.date + (r.author ? ' • ' + esc(r.author) : '') + '</div>' + esc(r.text) + '</div>';
     });
   }
 
+  html += '<div style="display:flex;gap:4px;margin-top:8px;">' +
+    '<input type="text" id="tm-reply" placeholder="Add a reply" style="flex:1;" onkeydown="if(event.key===\'Enter\')_tm_reply(\'' + ticketId + '\')">' +
+    '<button onclick="_tm_reply(\'' + ticketId + '\')">Reply</button>' +
+  '</div>';
+
   detail.innerHTML = html;
   detail.style.display = 'block';
 }
 
+function _tm_statusLabel(status) {
+  return status === 'in_progress' ? 'in progress' : status;
+}
+
+// Changing tickets needs a signed-in user; the server checks transitions
+function _tm_update(ticketId, method, path, body) {
+  if (!sessionToken) {
+    ALGO.notify('Log in to change tickets');
+    return Promise.resolve();
+  }
+  return fetch('/api/tickets/' + encodeURIComponent(ticketId) + path, {
+    method: method,
+    headers: {
+      'Content-Type': 'application/json',
+      'Authorization': 'Bearer ' + sessionToken
+    },
+    body: JSON.stringify(body)
+  })
+  .then(r => r.json())
+  .then(data => {
+    if (!data.ticket) {
+      ALGO.notify(data.error || 'Error updating ticket');
+      return;
+    }
+    _tm_tickets = _tm_tickets.map(t => t.id === ticketId ? data.ticket : t);
+    _tm_select(ticketId);
+  })
+  .catch(() => {
+    ALGO.notify('Error updating ticket');
+  });
+}
+
+function _tm_setStatus(ticketId, status) {
+  _tm_update(ticketId, 'PATCH', '', { status: status });
+}
+
 function _tm_claim(ticketId) {
-  // For now, just show a notification - full claim API can be added later
-  ALGO.notify('Ticket claim feature coming soon!');
+  _tm_setStatus(ticketId, 'in_progress');
+}
+
+function _tm_reply(ticketId) {
+  const input = document.getElementById('tm-reply');
+  const text = input ? input.value.trim() : '';
+  if (!text) return;
+  _tm_update(ticketId, 'POST', '/replies', { text: text });
 }
 
 // Lazy-load the worker instructions
@@ -1,38 +1,40 @@
 // FunctionServer Bridge - Background Service Worker
 // Manages WebSocket connection to FunctionServer (local or remote)
+// Speaks content bridge protocol v1, see PROTOCOL.md
+
+const PROTOCOL_VERSION = 1;
 
 let ws = null;
 let serverUrl = 'ws://localhost:8080';
+let token = ''; // desktop session token; the server only routes within its user
 let connected = false;
 let shadowTabs = new Map(); // tabId -> { url, title, shadowId }
 let tabGroupId = null; // Chrome tab group for shadow tabs
 let pingInterval = null;
 
-// Load saved server URL
-chrome.storage.local.get(['serverUrl'], (result) => {
+// Load saved server URL and token
+chrome.storage.local.get(['serverUrl', 'token'], (result) => {
   if (result.serverUrl) serverUrl = result.serverUrl;
+  if (result.token) token = result.token;
   connect();
 });
 
 function connect() {
   if (ws && ws.readyState === WebSocket.OPEN) return;
+  if (!token) return; // set from the popup
 
   try {
-    ws = new WebSocket(`${serverUrl}/api/content-bridge?ext=1`);
+    ws = new WebSocket(`${serverUrl}/api/content-bridge?ext=1&token=${encodeURIComponent(token)}`);
 
     ws.onopen = () => {
       connected = true;
       console.log('[FSBridge] Connected to FunctionServer');
       updateBadge();
-      // Send list of currently bridged tabs
-      sendTabList();
+      // Introduce ourselves with the current tabs
+      sendTabs('hello');
       // Start keepalive ping
       if (pingInterval) clearInterval(pingInterval);
-      pingInterval = setInterval(() => {
-        if (ws && ws.readyState === WebSocket.OPEN) {
-          ws.send(JSON.stringify({ action: 'ping' }));
-        }
-      }, 30000);
+      pingInterval = setInterval(() => send({ event: 'ping' }), 30000);
     };
 
     ws.onmessage = async (event) => {
@@ -70,191 +72,138 @@ function updateBadge() {
   chrome.action.setBadgeBackgroundColor({ color: connected ? '#4a4' : '#888' });
 }
 
-async function handleMessage(msg) {
-  const { id, action, tabId, data } = msg;
-
-  switch (action) {
-    case 'ping':
-      send({ id, result: 'pong' });
-      break;
-
-    case 'listTabs':
-      sendTabList(id);
-      break;
-
-    case 'getContent':
-      // Get DOM content from a tab
-      try {
-        const results = await chrome.scripting.executeScript({
-          target: { tabId },
-          func: () => ({
-            html: document.documentElement.outerHTML,
-            url: location.href,
-            title: document.title
-          })
-        });
-        send({ id, result: results[0]?.result });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
-
-    case 'executeScript':
-      // Execute JS in a tab and return result
-      try {
-        const results = await chrome.scripting.executeScript({
-          target: { tabId },
-          func: new Function('return (' + data.script + ')'),
-          args: data.args || []
-        });
-        send({ id, result: results[0]?.result });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
-
-    case 'eval':
-      // Evaluate expression in isolated world (can read DOM but not page JS)
-      // For sites with strict CSP, this is the only option
-      try {
-        const results = await chrome.scripting.executeScript({
-          target: { tabId },
-          // Use isolated world - can access DOM but not page's JS context
-          // This avoids CSP issues with eval
-          func: (expr) => {
-            try {
-              // Add helper functions
-              const $ = (s) => document.querySelector(s);
-              const $$ = (s) => [...document.querySelectorAll(s)];
-              // Run expression with helpers in scope
-              const fn = new Function('$', '$$', expr);
-              return { success: true, result: fn($, $$) };
-            } catch (e) {
-              return { success: false, error: e.message };
-            }
-          },
-          args: [data.expression]
-        });
-        const res = results[0]?.result;
-        if (res?.success) {
-          send({ id, result: res.result });
-        } else {
-          send({ id, error: res?.error || 'Unknown error' });
-        }
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
-
-    case 'query':
-      // Query selector in tab
-      try {
-        const results = await chrome.scripting.executeScript({
-          target: { tabId },
-          func: (selector, all) => {
-            if (all) {
-              return [...document.querySelectorAll(selector)].map(el => ({
-                tag: el.tagName,
-                text: el.textContent?.slice(0, 200),
-                html: el.outerHTML?.slice(0, 500)
-              }));
-            } else {
-              const el = document.querySelector(selector);
-              return el ? {
-                tag: el.tagName,
-                text: el.textContent?.slice(0, 200),
-                html: el.outerHTML?.slice(0, 500)
-              } : null;
-            }
-          },
-          args: [data.selector, data.all || false]
-        });
-        send({ id, result: results[0]?.result });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
-
-    case 'click':
-      // Click element in tab
-      try {
-        const results = await chrome.scripting.executeScript({
-          target: { tabId },
-          func: (selector) => {
-            const el = document.querySelector(selector);
-            if (el) {
-              el.click();
-              return true;
-            }
-            return false;
-          },
-          args: [data.selector]
-        });
-        send({ id, result: results[0]?.result });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
-
-    case 'injectCSS':
-      // Inject CSS into tab
-      try {
-        await chrome.scripting.insertCSS({
-          target: { tabId },
-          css: data.css
-        });
-        send({ id, result: true });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
-
-    case 'screenshot':
-      // Capture visible tab
-      try {
-        const dataUrl = await chrome.tabs.captureVisibleTab(null, { format: 'png' });
-        send({ id, result: dataUrl });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
+// Actions from the server, already checked against the v1 schema
+const actions = {
+  async getContent({ tabId }) {
+    return runInTab(tabId, () => ({
+      html: document.documentElement.outerHTML,
+      url: location.href,
+      title: document.title
+    }));
+  },
+
+  async extract({ tabId }) {
+    return runInTab(tabId, extractArticle);
+  },
+
+  async screenshot({ tabId }) {
+    return screenshotTab(tabId);
+  },
+
+  async query({ tabId, selector, all }) {
+    return runInTab(tabId, (selector, all) => {
+      const describe = el => ({
+        tag: el.tagName,
+        text: el.textContent?.slice(0, 200),
+        html: el.outerHTML?.slice(0, 500)
+      });
+      if (all) return [...document.querySelectorAll(selector)].map(describe);
+      const el = document.querySelector(selector);
+      return el ? describe(el) : null;
+    }, [selector, !!all]);
+  },
+
+  async click({ tabId, selector }) {
+    return runInTab(tabId, (selector) => {
+      const el = document.querySelector(selector);
+      if (!el) return false;
+      el.click();
+      return true;
+    }, [selector]);
+  },
+
+  async openTab({ url, shadowId }) {
+    const tab = await openShadowTab(url, shadowId);
+    return { tabId: tab.id, shadowId: shadowTabs.get(tab.id).shadowId };
+  },
+
+  async closeTab({ tabId }) {
+    await closeShadowTab(tabId);
+    return true;
+  },
+
+  async navigate({ tabId, url }) {
+    await chrome.tabs.update(tabId, { url });
+    return true;
+  }
+};
 
-    case 'openShadow':
-      // Open a shadow tab (background, in collapsed group)
-      try {
-        const tab = await openShadowTab(data.url, data.shadowId);
-        send({ id, result: { tabId: tab.id, shadowId: data.shadowId } });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
+async function handleMessage(msg) {
+  const { id, action, params } = msg;
+  const fn = actions[action];
+  if (!fn) {
+    if (id) send({ id, error: `Unknown action: ${action}` });
+    return;
+  }
+  try {
+    send({ id, result: await fn(params || {}) });
+  } catch (e) {
+    send({ id, error: e.message });
+  }
+}
 
-    case 'closeShadow':
-      // Close a shadow tab
-      try {
-        await closeShadowTab(data.tabId || data.shadowId);
-        send({ id, result: true });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
+// Run func in a tab's isolated world (DOM access, no page JS, no CSP issues)
+async function runInTab(tabId, func, args = []) {
+  const results = await chrome.scripting.executeScript({ target: { tabId }, func, args });
+  return results[0]?.result;
+}
 
-    case 'listShadows':
-      // List all shadow tabs
-      send({ id, result: Array.from(shadowTabs.entries()).map(([tabId, info]) => ({
-        tabId, ...info
-      }))});
-      break;
+// Readability-style extract, run in the tab: pick the element holding most
+// of the page's paragraph text and return it without chrome around it
+function extractArticle() {
+  const meta = name => document.querySelector(`meta[name="${name}"], meta[property="${name}"]`)?.content || '';
+  const textLength = el => [...el.querySelectorAll('p')].reduce((n, p) => n + p.textContent.trim().length, 0);
+
+  let best = document.querySelector('article, main, [role="main"]');
+  if (!best || textLength(best) < 200) {
+    // Score each paragraph's parent and keep the best one
+    const scores = new Map();
+    for (const p of document.querySelectorAll('p')) {
+      const len = p.textContent.trim().length;
+      if (len < 40 || !p.parentElement) continue;
+      scores.set(p.parentElement, (scores.get(p.parentElement) || 0) + len);
+    }
+    let top = 0;
+    for (const [el, score] of scores) {
+      if (score > top) { top = score; best = el; }
+    }
+  }
+  best = best || document.body;
+
+  const clone = best.cloneNode(true);
+  clone.querySelectorAll('script, style, noscript, iframe, nav, aside, footer, form, button, [aria-hidden="true"]')
+    .forEach(el => el.remove());
+  const text = clone.innerText || clone.textContent || '';
+
+  return {
+    url: location.href,
+    title: meta('og:title') || document.title,
+    byline: meta('author'),
+    excerpt: meta('description') || meta('og:description'),
+    text: text.replace(/\n{3,}/g, '\n\n').trim(),
+    html: clone.innerHTML
+  };
+}
 
-    case 'navigateShadow':
-      // Navigate a shadow tab to new URL
-      try {
-        await chrome.tabs.update(tabId, { url: data.url });
-        send({ id, result: true });
-      } catch (e) {
-        send({ id, error: e.message });
-      }
-      break;
+// Capture a tab. Chrome only captures the visible tab of a window, so a
+// background tab is brought to the front and the previous one restored.
+async function screenshotTab(tabId) {
+  const tab = tabId
+    ? await chrome.tabs.get(tabId)
+    : (await chrome.tabs.query({ active: true, lastFocusedWindow: true }))[0];
+  if (!tab) throw new Error('No active tab');
+
+  const [previous] = await chrome.tabs.query({ active: true, windowId: tab.windowId });
+  if (!tab.active) {
+    await chrome.tabs.update(tab.id, { active: true });
+    await new Promise(r => setTimeout(r, 300)); // let it paint
+  }
+  try {
+    return await chrome.tabs.captureVisibleTab(tab.windowId, { format: 'png' });
+  } finally {
+    if (previous && previous.id !== tab.id) {
+      chrome.tabs.update(previous.id, { active: true }).catch(() => {});
+    }
   }
 }
 
@@ -287,6 +236,9 @@ async function openShadowTab(url, shadowId) {
     shadowId: shadowId || `puppet_${tab.id}`,
     originalTitle: null
   });
+  // onCreated reported it as an ordinary tab; the server only lets the
+  // bridge close and navigate its own tabs, so say so before answering
+  if (connected) send({ event: 'tabUpdated', tab: tabInfo(tab) });
 
   // Add to tab group
   try {
@@ -362,51 +314,57 @@ function send(msg) {
   }
 }
 
-async function sendTabList(requestId) {
+function tabInfo(t) {
+  return {
+    id: t.id,
+    windowId: t.windowId,
+    url: t.url || t.pendingUrl || '',
+    title: t.title || '',
+    active: t.active,
+    shadow: shadowTabs.has(t.id)
+  };
+}
+
+// Send every tab: event is 'hello' on connect, 'tabs' for a resync
+async function sendTabs(event) {
+  const msg = { event, tabs: [] };
+  if (event === 'hello') msg.version = PROTOCOL_VERSION;
   try {
-    const tabs = await chrome.tabs.query({});
-    const tabList = tabs.map(t => ({
-      id: t.id,
-      url: t.url,
-      title: t.title,
-      active: t.active,
-      favIconUrl: t.favIconUrl
-    }));
-    // Include request ID if provided (for request/response routing)
-    const msg = { action: 'tabList', tabs: tabList };
-    if (requestId) {
-      msg.id = requestId;
-      msg.result = tabList;  // Also include as result for promise resolution
-    }
-    send(msg);
+    msg.tabs = (await chrome.tabs.query({})).map(tabInfo);
   } catch (e) {
     console.error('[FSBridge] Error getting tabs:', e);
-    if (requestId) {
-      send({ id: requestId, error: e.message });
-    }
   }
+  send(msg);
 }
 
-// Listen for tab updates
+// Keep the server's tab registry current
+chrome.tabs.onCreated.addListener((tab) => {
+  if (connected) send({ event: 'tabUpdated', tab: tabInfo(tab) });
+});
+
 chrome.tabs.onUpdated.addListener((tabId, changeInfo, tab) => {
-  if (changeInfo.status === 'complete' && connected) {
-    sendTabList();
+  if (connected && (changeInfo.url || changeInfo.title || changeInfo.status === 'complete')) {
+    send({ event: 'tabUpdated', tab: tabInfo(tab) });
   }
 });
 
+chrome.tabs.onActivated.addListener(({ tabId, windowId }) => {
+  if (connected) send({ event: 'tabActivated', tabId, windowId });
+});
+
 chrome.tabs.onRemoved.addListener((tabId) => {
-  if (connected) {
-    sendTabList();
-  }
+  shadowTabs.delete(tabId);
+  if (connected) send({ event: 'tabRemoved', tabId });
 });
 
 // Listen for messages from popup
 chrome.runtime.onMessage.addListener((msg, sender, sendResponse) => {
   if (msg.action === 'getStatus') {
-    sendResponse({ connected, serverUrl });
+    sendResponse({ connected, serverUrl, hasToken: !!token });
   } else if (msg.action === 'setServer') {
     serverUrl = msg.serverUrl;
-    chrome.storage.local.set({ serverUrl });
+    if (msg.token !== undefined) token = msg.token;
+    chrome.storage.local.set({ serverUrl, token });
     if (ws) ws.close();
     connect();
     sendResponse({ ok: true });
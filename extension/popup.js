@@ -4,6 +4,7 @@ const statusDot = document.getElementById('status-dot');
 const statusText = document.getElementById('status-text');
 const serverUrlInput = document.getElementById('server-url');
 const serverPreset = document.getElementById('server-preset');
+const tokenInput = document.getElementById('token');
 const saveBtn = document.getElementById('save-btn');
 const reconnectBtn = document.getElementById('reconnect-btn');
 
@@ -14,7 +15,7 @@ function updateStatus() {
       const serverDisplay = response.serverUrl?.replace('wss://', '').replace('ws://', '') || 'not set';
       statusText.textContent = response.connected
         ? `Connected to ${serverDisplay}`
-        : `Disconnected (${serverDisplay})`;
+        : response.hasToken ? `Disconnected (${serverDisplay})` : 'Token required';
       serverUrlInput.value = response.serverUrl || 'ws://localhost:8080';
     }
   });
@@ -42,7 +43,12 @@ saveBtn.addEventListener('click', () => {
     serverUrlInput.value = url;
   }
 
-  chrome.runtime.sendMessage({ action: 'setServer', serverUrl: url }, () => {
+  // An empty field keeps the saved token
+  const msg = { action: 'setServer', serverUrl: url };
+  if (tokenInput.value.trim()) msg.token = tokenInput.value.trim();
+  tokenInput.value = '';
+
+  chrome.runtime.sendMessage(msg, () => {
     statusText.textContent = 'Reconnecting...';
     setTimeout(updateStatus, 1000);
   });
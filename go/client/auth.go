@@ -0,0 +1,35 @@
+package client
+
+import "context"
+
+// LoginResponse is the reply to /api/auth/login
+type LoginResponse struct {
+	Success  bool   `json:"success"`
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// Login exchanges a username and password for a token and stores both in
+// the client's profile (save it with SaveProfile to keep it)
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	var resp LoginResponse
+	body := map[string]string{"username": username, "password": password}
+	if err := c.do(ctx, "POST", "/api/auth/login", nil, body, &resp); err != nil {
+		return err
+	}
+	c.Profile.Token = resp.Token
+	c.Profile.Username = username
+	return nil
+}
+
+// Verify reports whether the profile's token is still valid for its username
+func (c *Client) Verify(ctx context.Context) (bool, error) {
+	var resp struct {
+		Valid bool `json:"valid"`
+	}
+	body := map[string]string{"token": c.Profile.Token, "username": c.Profile.Username}
+	if err := c.do(ctx, "POST", "/api/auth/verify", nil, body, &resp); err != nil {
+		return false, err
+	}
+	return resp.Valid, nil
+}
@@ -0,0 +1,167 @@
+// Package client is a Go client for the cecilia HTTP and WebSocket APIs:
+// auth, files, exec, tickets, the PTY stream and the eye bridge. The eye and
+// eye-mcp commands are built on it.
+//
+//	p, _ := client.LoadProfile("")        // ~/.algo/config.json, see profile.go
+//	c, _ := client.New(p)
+//	files, _ := c.ListFiles(ctx, "~/projects")
+//
+//	eye, _ := c.DialEye(ctx, client.EyeOptions{})
+//	title, _ := eye.Eval(ctx, "document.title")
+//
+// Calls take a context; HTTP errors come back as *APIError (a 401 also
+// matches ErrUnauthorized) and failed browser evaluations as *EvalError.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Client talks to one server with one profile's token and TLS settings
+type Client struct {
+	Profile *Profile
+	HTTP    *http.Client
+}
+
+// ErrUnauthorized means the server rejected the profile's token
+var ErrUnauthorized = errors.New("token rejected")
+
+// APIError is an error response from the server ({"error": "..."})
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+// Unwrap makes a 401 match ErrUnauthorized with errors.Is
+func (e *APIError) Unwrap() error {
+	if e.Status == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// New returns a client for a profile
+func New(p *Profile) (*Client, error) {
+	tlsConfig, err := p.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Client{Profile: p, HTTP: &http.Client{Transport: transport, Timeout: 60 * time.Second}}, nil
+}
+
+// do makes an API call. body (if not nil) is sent as JSON and the JSON
+// response is decoded into out (if not nil); a *string out gets the raw body.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u, err := c.Profile.URL(path)
+	if err != nil {
+		return err
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Profile.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Profile.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = http.StatusText(resp.StatusCode)
+		}
+		return &APIError{Status: resp.StatusCode, Message: e.Error}
+	}
+	if out == nil {
+		return nil
+	}
+	if text, ok := out.(*string); ok {
+		*text = string(data)
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unexpected response from %s: %v", path, err)
+	}
+	return nil
+}
+
+// dial opens an authenticated WebSocket to an API path such as "/api/eye".
+// The token is sent as ?token=, which is how the server authenticates sockets.
+func (c *Client) dial(ctx context.Context, path string, query url.Values, subprotocols ...string) (*websocket.Conn, error) {
+	if c.Profile.Token == "" {
+		return nil, fmt.Errorf("not logged in (run: eye login)")
+	}
+	wsURL, err := c.Profile.WebSocketURL(path)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := c.Profile.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("token", c.Profile.Token)
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 15 * time.Second,
+		TLSClientConfig:  tlsConfig,
+		Subprotocols:     subprotocols,
+	}
+	conn, resp, err := dialer.DialContext(ctx, wsURL+"?"+q.Encode(), nil)
+	if err != nil && resp != nil {
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			return nil, fmt.Errorf("%w by %s (run: eye login)", ErrUnauthorized, c.Profile.Server)
+		case http.StatusForbidden:
+			return nil, fmt.Errorf("%s refused %s (HTTP 403)", c.Profile.Server, path)
+		}
+	}
+	return conn, err
+}
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testToken = "test-token"
+
+// newTestServer serves a small fake of the cecilia API: login, files,
+// tickets and /api/mcp. Everything but login wants testToken.
+func newTestServer(t *testing.T) (*httptest.Server, *Client) {
+	t.Helper()
+	mux := http.NewServeMux()
+	reply := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	authed := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+testToken {
+				reply(w, 401, map[string]string{"error": "Authorization required"})
+				return
+			}
+			h(w, r)
+		}
+	}
+
+	mux.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Username, Password string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Password != "secret" {
+			reply(w, 401, map[string]string{"error": "Invalid credentials"})
+			return
+		}
+		reply(w, 200, LoginResponse{Success: true, Username: req.Username, Token: testToken})
+	})
+	mux.HandleFunc("/api/files/list", authed(func(w http.ResponseWriter, r *http.Request) {
+		reply(w, 200, FileList{Path: "~/" + r.URL.Query().Get("path"), Files: []FileInfo{
+			{Name: "notes.txt", Type: "file", Size: 5},
+			{Name: "src", Type: "directory"},
+		}})
+	}))
+	mux.HandleFunc("/api/files/get", authed(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("path") != "notes.txt" {
+			reply(w, 404, map[string]string{"error": "File not found"})
+			return
+		}
+		if r.URL.Query().Get("encoding") == "base64" {
+			reply(w, 200, File{Path: "~/notes.txt", Content: base64.StdEncoding.EncodeToString([]byte("hello")), Encoding: "base64", Size: 5})
+			return
+		}
+		reply(w, 200, File{Path: "~/notes.txt", Content: "hello", Size: 5})
+	}))
+	mux.HandleFunc("/api/tickets", authed(func(w http.ResponseWriter, r *http.Request) {
+		reply(w, 200, map[string]interface{}{"tickets": []Ticket{{ID: "T1", Title: "First", Status: TicketOpen}}})
+	}))
+	mux.HandleFunc("/api/tickets/", authed(func(w http.ResponseWriter, r *http.Request) {
+		reply(w, 404, map[string]string{"error": "Ticket not found"})
+	}))
+	mux.HandleFunc("/api/mcp", authed(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Params struct {
+				Name      string                 `json:"name"`
+				Arguments map[string]interface{} `json:"arguments"`
+			} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		text := fmt.Sprintf("%s %v", req.Params.Name, req.Params.Arguments["selector"])
+		resp, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"result": map[string]interface{}{
+				"content": []ToolContent{{Type: "text", Text: text}},
+				"isError": req.Params.Name == "fail_tool",
+			},
+		})
+		if req.Params.Name != "sse_tool" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(resp)
+			return
+		}
+		// A progress notification first, then the response
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", `{"jsonrpc":"2.0","method":"notifications/progress","params":{}}`)
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", resp)
+	}))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	c, err := New(&Profile{Server: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, c
+}
+
+func TestLogin(t *testing.T) {
+	_, c := newTestServer(t)
+	ctx := context.Background()
+
+	err := c.Login(ctx, "alice", "wrong")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("bad password: got %v, want ErrUnauthorized", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != 401 || apiErr.Message != "Invalid credentials" {
+		t.Errorf("bad password: got %#v, want a 401 APIError with the server's message", err)
+	}
+
+	if err := c.Login(ctx, "alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Profile.Token != testToken || c.Profile.Username != "alice" {
+		t.Errorf("profile after login = %+v", c.Profile)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	_, c := newTestServer(t)
+	c.Profile.Token = "stale"
+	if _, err := c.ListFiles(context.Background(), ""); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("stale token: got %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	_, c := newTestServer(t)
+	c.Profile.Token = testToken
+	ctx := context.Background()
+
+	list, err := c.ListFiles(ctx, "projects")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Path != "~/projects" || len(list.Files) != 2 || list.Files[0].IsDir() || !list.Files[1].IsDir() {
+		t.Errorf("ListFiles = %+v", list)
+	}
+
+	file, err := c.ReadFile(ctx, "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Content != "hello" {
+		t.Errorf("ReadFile content = %q", file.Content)
+	}
+
+	data, err := c.ReadFileBytes(ctx, "notes.txt")
+	if err != nil || string(data) != "hello" {
+		t.Errorf("ReadFileBytes = %q, %v", data, err)
+	}
+
+	_, err = c.ReadFile(ctx, "missing.txt")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != 404 || apiErr.Message != "File not found" {
+		t.Errorf("missing file: got %v, want a 404 APIError", err)
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		t.Errorf("404 matched ErrUnauthorized")
+	}
+}
+
+func TestTickets(t *testing.T) {
+	_, c := newTestServer(t)
+	c.Profile.Token = testToken
+	ctx := context.Background()
+
+	tickets, err := c.Tickets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tickets) != 1 || tickets[0].ID != "T1" || tickets[0].Status != TicketOpen {
+		t.Errorf("Tickets = %+v", tickets)
+	}
+
+	_, err = c.Ticket(ctx, "T9")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != 404 {
+		t.Errorf("missing ticket: got %v, want a 404 APIError", err)
+	}
+}
+
+func TestCallTool(t *testing.T) {
+	_, c := newTestServer(t)
+	c.Profile.Token = testToken
+	ctx := context.Background()
+
+	for _, name := range []string{"json_tool", "sse_tool"} {
+		content, err := c.CallTool(ctx, name, map[string]interface{}{"selector": "#go"}, "")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(content) != 1 || content[0].Text != name+" #go" {
+			t.Errorf("%s: content = %+v", name, content)
+		}
+	}
+
+	_, err := c.CallTool(ctx, "fail_tool", nil, "")
+	if err == nil || !strings.Contains(err.Error(), "fail_tool") {
+		t.Errorf("isError result: got %v, want the tool's text as an error", err)
+	}
+
+	c.Profile.Token = "stale"
+	if _, err := c.CallTool(ctx, "json_tool", nil, ""); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("stale token: got %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,26 @@
+package client
+
+import "context"
+
+// ExecRequest runs an allowed command in the user's home (see /api/terminal/exec)
+type ExecRequest struct {
+	Command string `json:"command"`
+	Cwd     string `json:"cwd,omitempty"`
+}
+
+// ExecResult is a finished command. Cwd is only set after "cd".
+type ExecResult struct {
+	Output string `json:"output"`
+	Cwd    string `json:"cwd,omitempty"`
+	Error  string `json:"error,omitempty"` // e.g. "exit status 1"; Output is still valid
+}
+
+// Exec runs a command and returns its combined output. A command that ran
+// but failed is not an error: check ExecResult.Error.
+func (c *Client) Exec(ctx context.Context, req ExecRequest) (*ExecResult, error) {
+	var res ExecResult
+	if err := c.do(ctx, "POST", "/api/terminal/exec", nil, req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
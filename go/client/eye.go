@@ -0,0 +1,479 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Eye bridge client
+//
+// /api/eye speaks a line protocol: "id:expr" evaluates expr in the browser
+// and is answered with "id:result" or "id!:error"; a line without an ID is
+// fire-and-forget; lines starting with "@" are control messages and events
+// (see eye_events.go on the server). Eye hands out request IDs, matches
+// replies to callers, and redials when the socket drops.
+
+// ErrDisconnected is returned for requests in flight when the socket drops.
+// They are not retried: the expression may already have run.
+var ErrDisconnected = errors.New("eye: connection lost")
+
+// ErrClosed is returned after Close
+var ErrClosed = errors.New("eye: closed")
+
+// ErrDuplicateID is returned by EvalID when a request with the same ID is
+// still waiting for its reply
+var ErrDuplicateID = errors.New("eye: request ID already in flight")
+
+// EvalError is an error thrown by the expression in the browser, or a
+// server-side failure such as "timeout" or "browser disconnected"
+type EvalError struct {
+	Message string
+}
+
+func (e *EvalError) Error() string {
+	return e.Message
+}
+
+// EyeOptions configures an eye connection
+type EyeOptions struct {
+	Session  string        // browser connection ID, or "all"; "" targets the focused tab
+	Timeout  time.Duration // per-request limit asked of the server; 0 keeps the server's
+	Record   bool          // record the session to ~/.algo/eye-recordings
+	IDPrefix string        // prefix for generated request IDs (default "c")
+
+	// Reconnect keeps the socket up in the background, redialing with
+	// jittered backoff whenever it drops. Calls made while disconnected try
+	// one immediate dial and otherwise fail fast instead of waiting.
+	Reconnect bool
+
+	// OnState is told when the socket connects (err == nil) or drops or
+	// fails to dial (err != nil)
+	OnState func(connected bool, err error)
+
+	// OnMessage receives every line that is not a reply to Eval: events,
+	// control replies and replies to IDs sent with Raw. It runs on the
+	// read goroutine and must not block.
+	OnMessage func(msg string)
+}
+
+// EyeSession is a browser connection that eye requests can target
+type EyeSession struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"` // "pty" or "bridge"
+	Page      string `json:"page"`
+	UserAgent string `json:"userAgent"`
+	Opened    int64  `json:"opened"`
+	Focused   bool   `json:"focused"`
+	FocusedAt int64  `json:"focusedAt,omitempty"`
+}
+
+// EyeSessions lists the user's browser connections and the default target
+func (c *Client) EyeSessions(ctx context.Context) ([]EyeSession, string, error) {
+	var resp struct {
+		Sessions []EyeSession `json:"sessions"`
+		Default  string       `json:"default"`
+	}
+	if err := c.do(ctx, "GET", "/api/eye/sessions", nil, nil, &resp); err != nil {
+		return nil, "", err
+	}
+	return resp.Sessions, resp.Default, nil
+}
+
+// EyeInstructions returns the server's eye guide (eye-instructions.txt),
+// with its {{USER}}, {{SERVER}} and {{CONFIG}} placeholders unfilled
+func (c *Client) EyeInstructions(ctx context.Context) (string, error) {
+	var text string
+	if err := c.do(ctx, "GET", "/core/apps/eye-instructions.txt", nil, nil, &text); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+type eyeReply struct {
+	result string
+	err    error
+}
+
+type eyePending struct {
+	conn *websocket.Conn
+	ch   chan eyeReply
+}
+
+// Eye is a connection to the eye bridge. It is safe for concurrent use.
+type Eye struct {
+	c    *Client
+	opts EyeOptions
+
+	dialMu  sync.Mutex // one redial at a time
+	mu      sync.Mutex // guards conn, pending, closed, lastErr
+	writeMu sync.Mutex
+	conn    *websocket.Conn
+	pending map[string]*eyePending
+	closed  bool
+	lastErr error
+	counter uint64
+
+	dropped chan struct{} // wakes the reconnect loop
+	done    chan struct{} // closed by Close
+}
+
+// DialEye connects to the eye bridge and waits for the server to be ready
+func (c *Client) DialEye(ctx context.Context, opts EyeOptions) (*Eye, error) {
+	e := c.OpenEye(opts)
+	if _, err := e.ensureConn(ctx); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// OpenEye returns an eye connection without waiting for it. With
+// opts.Reconnect it starts dialing in the background, so a client can start
+// while the server is down; otherwise the first call dials.
+func (c *Client) OpenEye(opts EyeOptions) *Eye {
+	if opts.IDPrefix == "" {
+		opts.IDPrefix = "c"
+	}
+	e := &Eye{
+		c:       c,
+		opts:    opts,
+		pending: make(map[string]*eyePending),
+		dropped: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if opts.Reconnect {
+		go e.reconnectLoop()
+	}
+	return e
+}
+
+// Connected reports whether the socket is up, and otherwise the last dial
+// or read error
+func (e *Eye) Connected() (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.conn != nil, e.lastErr
+}
+
+// NextID returns a fresh request ID
+func (e *Eye) NextID() string {
+	return e.opts.IDPrefix + strconv.FormatUint(atomic.AddUint64(&e.counter, 1), 10)
+}
+
+// Eval evaluates an expression in the browser and returns its result
+func (e *Eye) Eval(ctx context.Context, expr string) (string, error) {
+	return e.EvalID(ctx, e.NextID(), expr)
+}
+
+// EvalID is Eval with a caller-chosen request ID, which must not be in
+// flight already
+func (e *Eye) EvalID(ctx context.Context, id, expr string) (string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		// The server fails requests after its timeout; this is a backstop
+		timeout := e.opts.Timeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout+5*time.Second)
+		defer cancel()
+	}
+
+	ch := make(chan eyeReply, 1)
+	for attempt := 0; ; attempt++ {
+		conn, err := e.ensureConn(ctx)
+		if err != nil {
+			return "", err
+		}
+		e.mu.Lock()
+		if _, busy := e.pending[id]; busy {
+			e.mu.Unlock()
+			return "", fmt.Errorf("%w: %s", ErrDuplicateID, id)
+		}
+		e.pending[id] = &eyePending{conn: conn, ch: ch}
+		e.mu.Unlock()
+
+		err = e.write(conn, id+":"+expr)
+		if err == nil {
+			break
+		}
+		// Nothing was sent, so it is safe to retry once on a fresh socket
+		e.forget(id)
+		e.drop(conn)
+		if attempt > 0 {
+			return "", err
+		}
+	}
+
+	select {
+	case r := <-ch:
+		return r.result, r.err
+	case <-ctx.Done():
+		e.forget(id)
+		return "", ctx.Err()
+	}
+}
+
+// Send evaluates an expression without waiting for a result
+func (e *Eye) Send(ctx context.Context, expr string) error {
+	return e.Raw(ctx, expr)
+}
+
+// Raw writes a protocol line as-is ("id:expr", "@sub ...", ...). Replies
+// arrive through OnMessage.
+func (e *Eye) Raw(ctx context.Context, line string) error {
+	conn, err := e.ensureConn(ctx)
+	if err != nil {
+		return err
+	}
+	if err := e.write(conn, line); err != nil {
+		e.drop(conn)
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection and fails requests in flight
+func (e *Eye) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	conn := e.conn
+	e.mu.Unlock()
+	close(e.done)
+	if conn != nil {
+		e.drop(conn)
+	}
+	return nil
+}
+
+func (e *Eye) write(conn *websocket.Conn, msg string) error {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	return conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+func (e *Eye) forget(id string) {
+	e.mu.Lock()
+	delete(e.pending, id)
+	e.mu.Unlock()
+}
+
+// ensureConn returns the live socket, redialing if it dropped. Without a
+// background reconnect loop it retries with backoff; with one it tries once
+// so callers fail fast while the server is down.
+func (e *Eye) ensureConn(ctx context.Context) (*websocket.Conn, error) {
+	e.mu.Lock()
+	conn, closed := e.conn, e.closed
+	e.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if conn != nil {
+		return conn, nil
+	}
+
+	if e.opts.Reconnect {
+		conn, err := e.dialOnce(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("eye: not connected to %s: %v (reconnecting in background)", e.c.Profile.Server, err)
+		}
+		return conn, nil
+	}
+
+	var err error
+	backoff := 250 * time.Millisecond
+	for attempt := 0; attempt < 4; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if conn, err = e.dialOnce(ctx); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrClosed) {
+			break
+		}
+	}
+	return nil, err
+}
+
+// dialOnce connects unless another caller already has
+func (e *Eye) dialOnce(ctx context.Context) (*websocket.Conn, error) {
+	e.dialMu.Lock()
+	defer e.dialMu.Unlock()
+
+	e.mu.Lock()
+	conn, closed := e.conn, e.closed
+	e.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if conn != nil {
+		return conn, nil
+	}
+
+	conn, err := e.connect(ctx)
+	e.mu.Lock()
+	if err == nil && e.closed {
+		conn.Close()
+		conn, err = nil, ErrClosed
+	}
+	if err == nil {
+		e.conn = conn
+	}
+	e.lastErr = err
+	e.mu.Unlock()
+
+	if err != ErrClosed && e.opts.OnState != nil {
+		e.opts.OnState(err == nil, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	go e.readLoop(conn)
+	return conn, nil
+}
+
+// reconnectLoop keeps the socket up until Close, backing off from 500ms to
+// 30s with jitter so many clients don't redial a restarted server at once
+func (e *Eye) reconnectLoop() {
+	const minBackoff, maxBackoff = 500 * time.Millisecond, 30 * time.Second
+	backoff := minBackoff
+	for {
+		if _, err := e.dialOnce(context.Background()); err == nil {
+			backoff = minBackoff
+			select {
+			case <-e.dropped:
+				continue
+			case <-e.done:
+				return
+			}
+		} else if err == ErrClosed {
+			return
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-e.done:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// connect dials and waits for ":ready"
+func (e *Eye) connect(ctx context.Context) (*websocket.Conn, error) {
+	query := url.Values{}
+	if e.opts.Session != "" {
+		query.Set("session", e.opts.Session)
+	}
+	if e.opts.Timeout > 0 {
+		query.Set("timeout", e.opts.Timeout.String())
+	}
+	if e.opts.Record {
+		query.Set("record", "1")
+	}
+	conn, err := e.c.dial(ctx, "/api/eye", query)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(15 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	_, msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != ":ready" {
+		conn.Close()
+		if err == nil {
+			err = errors.New("eye: handshake failed: " + string(msg))
+		}
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn, nil
+}
+
+// readLoop routes replies to waiting callers until the socket drops
+func (e *Eye) readLoop(conn *websocket.Conn) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if e.drop(conn) && e.opts.OnState != nil {
+				e.opts.OnState(false, err)
+			}
+			return
+		}
+		msg := string(data)
+
+		colon := strings.Index(msg, ":")
+		if colon > 0 && !strings.HasPrefix(msg, "@") {
+			id := msg[:colon]
+			isErr := strings.HasSuffix(id, "!")
+			id = strings.TrimSuffix(id, "!")
+
+			e.mu.Lock()
+			p, ok := e.pending[id]
+			if ok {
+				delete(e.pending, id)
+			}
+			e.mu.Unlock()
+			if ok {
+				if isErr {
+					p.ch <- eyeReply{err: &EvalError{Message: msg[colon+1:]}}
+				} else {
+					p.ch <- eyeReply{result: msg[colon+1:]}
+				}
+				continue
+			}
+		}
+		if e.opts.OnMessage != nil {
+			e.opts.OnMessage(msg)
+		}
+	}
+}
+
+// drop closes a socket and fails the requests that were sent on it. It
+// reports whether conn was the live socket (and not closed on purpose).
+func (e *Eye) drop(conn *websocket.Conn) bool {
+	conn.Close()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	live := e.conn == conn
+	if live {
+		e.conn = nil
+		select {
+		case e.dropped <- struct{}{}:
+		default:
+		}
+	}
+	for id, p := range e.pending {
+		if p.conn == conn {
+			p.ch <- eyeReply{err: ErrDisconnected}
+			delete(e.pending, id)
+		}
+	}
+	return live && !e.closed
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newEyeServer fakes /api/eye: "id:fail" is answered with an error line,
+// "id:hang" never, anything else with "id:=<expr>"
+func newEyeServer(t *testing.T) *Client {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/eye" || r.URL.Query().Get("token") != testToken {
+			http.Error(w, "Invalid token", 401)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte(":ready"))
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			id, expr, _ := strings.Cut(string(msg), ":")
+			switch expr {
+			case "hang":
+			case "fail":
+				conn.WriteMessage(websocket.TextMessage, []byte(id+"!:ReferenceError: x is not defined"))
+			default:
+				conn.WriteMessage(websocket.TextMessage, []byte(id+":="+expr))
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(&Profile{Server: srv.URL, Token: testToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEvalID(t *testing.T) {
+	c := newEyeServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	eye, err := c.DialEye(ctx, EyeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eye.Close()
+
+	result, err := eye.EvalID(ctx, "r1", "document.title")
+	if err != nil || result != "=document.title" {
+		t.Errorf("reply: got %q, %v", result, err)
+	}
+
+	_, err = eye.EvalID(ctx, "r2", "fail")
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Message != "ReferenceError: x is not defined" {
+		t.Errorf("error line: got %v, want an EvalError", err)
+	}
+
+	// The ID is free again once its reply has arrived
+	if result, err := eye.EvalID(ctx, "r1", "1+1"); err != nil || result != "=1+1" {
+		t.Errorf("reused ID: got %q, %v", result, err)
+	}
+}
+
+func TestEvalIDDuplicate(t *testing.T) {
+	c := newEyeServer(t)
+	ctx := context.Background()
+
+	eye, err := c.DialEye(ctx, EyeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eye.Close()
+
+	hangCtx, cancel := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() {
+		_, err := eye.EvalID(hangCtx, "dup", "hang")
+		first <- err
+	}()
+
+	// Wait until the first request is registered
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		eye.mu.Lock()
+		_, waiting := eye.pending["dup"]
+		eye.mu.Unlock()
+		if waiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first request never went out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := eye.EvalID(ctx, "dup", "1"); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("second request: got %v, want ErrDuplicateID", err)
+	}
+
+	// The first caller still owns the ID
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first request: got %v, want context.Canceled", err)
+	}
+}
+
+func TestDialEyeUnauthorized(t *testing.T) {
+	c := newEyeServer(t)
+	c.Profile.Token = "stale"
+	if _, err := c.DialEye(context.Background(), EyeOptions{}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("got %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+)
+
+// Paths are relative to the user's home, or start with "~/"; the server
+// returns them in "~/" form.
+
+// FileInfo is one entry of a directory listing
+type FileInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // "file" or "directory"
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"` // unix seconds
+}
+
+// IsDir reports whether the entry is a directory
+func (f FileInfo) IsDir() bool {
+	return f.Type == "directory"
+}
+
+// FileList is a directory listing
+type FileList struct {
+	Path  string     `json:"path"`
+	Files []FileInfo `json:"files"`
+}
+
+// File is a file's content
+type File struct {
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"` // "base64" from ReadFileBytes
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"`
+}
+
+// ListFiles lists a directory ("" for home)
+func (c *Client) ListFiles(ctx context.Context, path string) (*FileList, error) {
+	var list FileList
+	if err := c.do(ctx, "GET", "/api/files/list", url.Values{"path": {path}}, nil, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// ReadFile returns a file's content
+func (c *Client) ReadFile(ctx context.Context, path string) (*File, error) {
+	var file File
+	if err := c.do(ctx, "GET", "/api/files/get", url.Values{"path": {path}}, nil, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// ReadFileBytes returns a file's exact bytes; unlike ReadFile it is safe
+// for binary files
+func (c *Client) ReadFileBytes(ctx context.Context, path string) ([]byte, error) {
+	var file File
+	query := url.Values{"path": {path}, "encoding": {"base64"}}
+	if err := c.do(ctx, "GET", "/api/files/get", query, nil, &file); err != nil {
+		return nil, err
+	}
+	if file.Encoding != "base64" {
+		return []byte(file.Content), nil // older server
+	}
+	return base64.StdEncoding.DecodeString(file.Content)
+}
+
+// WriteFile creates or replaces a file, creating parent directories, and
+// returns its path
+func (c *Client) WriteFile(ctx context.Context, path, content string) (string, error) {
+	var resp struct {
+		Path string `json:"path"`
+	}
+	body := map[string]string{"path": path, "content": content}
+	if err := c.do(ctx, "POST", "/api/files/save", nil, body, &resp); err != nil {
+		return "", err
+	}
+	return resp.Path, nil
+}
+
+// DeleteFile removes a file or a directory tree
+func (c *Client) DeleteFile(ctx context.Context, path string) error {
+	return c.do(ctx, "POST", "/api/files/delete", nil, map[string]string{"path": path}, nil)
+}
+
+// Mkdir creates a directory and its parents
+func (c *Client) Mkdir(ctx context.Context, path string) error {
+	return c.do(ctx, "POST", "/api/files/mkdir", url.Values{"path": {path}}, nil, nil)
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ToolContent is one block of a tool result from /api/mcp
+type ToolContent struct {
+	Type     string `json:"type"` // "text" or "image"
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"` // base64, for images
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// RPCError is a JSON-RPC error from /api/mcp
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+var rpcCounter atomic.Int64
+
+// CallTool runs one of the server's browser tools (algo_click,
+// algo_screenshot, ...) through /api/mcp. session picks the browser
+// connection like EyeOptions.Session. A result flagged isError comes back
+// as an error with its text.
+func (c *Client) CallTool(ctx context.Context, name string, args map[string]interface{}, session string) ([]ToolContent, error) {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	if session != "" {
+		args["session"] = session
+	}
+	var result struct {
+		Content []ToolContent `json:"content"`
+		IsError bool          `json:"isError"`
+	}
+	if err := c.rpc(ctx, "tools/call", map[string]interface{}{"name": name, "arguments": args}, &result); err != nil {
+		return nil, err
+	}
+	if result.IsError {
+		var texts []string
+		for _, block := range result.Content {
+			if block.Type == "text" {
+				texts = append(texts, block.Text)
+			}
+		}
+		return nil, errors.New(strings.Join(texts, "\n"))
+	}
+	return result.Content, nil
+}
+
+// rpc makes a sessionless JSON-RPC request to /api/mcp. The server may
+// answer with JSON or an SSE stream carrying the response.
+func (c *Client) rpc(ctx context.Context, method string, params, out interface{}) error {
+	id := rpcCounter.Add(1)
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	u, err := c.Profile.URL("/api/mcp")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+c.Profile.Token)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var msg struct {
+		ID     json.RawMessage `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		// Take the first message event answering our ID
+		want := fmt.Sprint(id)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 64<<20)
+		found := false
+		for !found && scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok || json.Unmarshal([]byte(strings.TrimSpace(data)), &msg) != nil {
+				continue
+			}
+			found = string(msg.ID) == want
+		}
+		if !found {
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("%s: stream ended without a response", method)
+		}
+	} else {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if json.Unmarshal(data, &msg) != nil {
+			return &APIError{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+	}
+
+	if msg.Error != nil {
+		if resp.StatusCode == http.StatusUnauthorized {
+			return fmt.Errorf("%w by %s (run: eye login)", ErrUnauthorized, c.Profile.Server)
+		}
+		return msg.Error
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(msg.Result, out); err != nil {
+		return fmt.Errorf("unexpected %s result: %v", method, err)
+	}
+	return nil
+}
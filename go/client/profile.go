@@ -0,0 +1,301 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Server profiles
+//
+// The config file keeps the old single-server shape working:
+//
+//	{"token": "...", "server": "wss://example.com/api/eye"}
+//
+// and adds named profiles, one of which is current:
+//
+//	{
+//	  "current": "work",
+//	  "profiles": {
+//	    "work": {"server": "https://example.com", "token": "...", "ca": "/etc/ssl/work-ca.pem"},
+//	    "dev":  {"server": "localhost:8443", "token": "...", "insecure": true}
+//	  }
+//	}
+//
+// EYE_PROFILE picks a profile; EYE_SERVER, EYE_TOKEN, EYE_CA and
+// EYE_INSECURE=1 override the profile's fields.
+
+// DefaultServer is used when no server is configured anywhere
+const DefaultServer = "https://localhost"
+
+// Profile is one server and the credentials for it
+type Profile struct {
+	Name     string `json:"-"`
+	Server   string `json:"server,omitempty"`
+	Token    string `json:"token,omitempty"`
+	Username string `json:"username,omitempty"`
+	CA       string `json:"ca,omitempty"`       // PEM bundle trusted in addition to the system roots
+	Insecure bool   `json:"insecure,omitempty"` // skip TLS verification (self-signed dev servers)
+}
+
+// configFile is ~/.algo/config.json. Unknown keys are kept when saving.
+type configFile struct {
+	Profile                     // legacy top-level token/server
+	Current  string             `json:"current,omitempty"`
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+
+	extra map[string]json.RawMessage
+}
+
+// ConfigPath is where profiles are stored
+func ConfigPath() string {
+	if path := os.Getenv("ALGO_CONFIG"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".algo", "config.json")
+}
+
+func readConfig() (*configFile, error) {
+	cfg := &configFile{}
+	data, err := os.ReadFile(ConfigPath())
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", ConfigPath(), err)
+	}
+	json.Unmarshal(data, &cfg.extra)
+	return cfg, nil
+}
+
+// writeConfig saves the config with owner-only permissions; it holds tokens
+func writeConfig(cfg *configFile) error {
+	out := make(map[string]interface{})
+	for k, v := range cfg.extra {
+		out[k] = v
+	}
+	for _, k := range []string{"server", "token", "username", "ca", "insecure", "current", "profiles"} {
+		delete(out, k)
+	}
+	if cfg.Server != "" {
+		out["server"] = cfg.Server
+	}
+	if cfg.Token != "" {
+		out["token"] = cfg.Token
+	}
+	if cfg.Username != "" {
+		out["username"] = cfg.Username
+	}
+	if cfg.CA != "" {
+		out["ca"] = cfg.CA
+	}
+	if cfg.Insecure {
+		out["insecure"] = true
+	}
+	if cfg.Current != "" {
+		out["current"] = cfg.Current
+	}
+	if len(cfg.Profiles) > 0 {
+		out["profiles"] = cfg.Profiles
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := ConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	os.Chmod(tmp, 0600) // WriteFile keeps the mode of an existing file
+	return os.Rename(tmp, path)
+}
+
+// LoadProfile resolves a profile by name. An empty name means EYE_PROFILE,
+// then the config's current profile, then the legacy top-level settings.
+// Environment overrides are applied on top.
+func LoadProfile(name string) (*Profile, error) {
+	p, err := LoadStoredProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("EYE_SERVER"); v != "" {
+		p.Server = v
+	}
+	if v := os.Getenv("EYE_TOKEN"); v != "" {
+		p.Token = v
+	}
+	if v := os.Getenv("EYE_CA"); v != "" {
+		p.CA = v
+	}
+	if os.Getenv("EYE_INSECURE") == "1" {
+		p.Insecure = true
+	}
+	if p.Server == "" {
+		p.Server = DefaultServer
+	}
+	return p, nil
+}
+
+// LoadStoredProfile resolves a profile like LoadProfile but without the
+// environment overrides, for callers that save the profile back
+func LoadStoredProfile(name string) (*Profile, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = os.Getenv("EYE_PROFILE")
+	}
+	if name == "" {
+		name = cfg.Current
+	}
+
+	p := cfg.Profile
+	if found, ok := cfg.Profiles[name]; ok {
+		p = found
+	} else if name != "" && name != "default" {
+		return nil, fmt.Errorf("no profile %q in %s", name, ConfigPath())
+	}
+	p.Name = name
+	return &p, nil
+}
+
+// SaveProfile stores a profile and makes it current. The name "default" with
+// no other profiles configured writes the legacy top-level fields instead, so
+// older tools reading config.json keep working.
+func SaveProfile(name string, p *Profile) error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = "default"
+	}
+	saved := *p
+	saved.Name = ""
+	if name == "default" && len(cfg.Profiles) == 0 && cfg.Current == "" {
+		cfg.Profile = saved
+		return writeConfig(cfg)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]Profile)
+	}
+	cfg.Profiles[name] = saved
+	cfg.Current = name
+	return writeConfig(cfg)
+}
+
+// ListProfiles returns the configured profile names and the current one
+func ListProfiles() (names []string, current string, err error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, cfg.Current, nil
+}
+
+// BaseURL normalizes a server as written by a user or an older config -
+// "example.com", "example.com:8443", "http://host", "wss://host/api/eye" -
+// to an http(s) origin plus any path prefix, without a trailing slash.
+func BaseURL(server string) (string, error) {
+	server = strings.TrimSpace(server)
+	if server == "" {
+		server = DefaultServer
+	}
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("invalid server %q: %v", server, err)
+	}
+	switch u.Scheme {
+	case "https", "wss":
+		u.Scheme = "https"
+	case "http", "ws":
+		u.Scheme = "http"
+	default:
+		return "", fmt.Errorf("invalid server %q: unsupported scheme %s", server, u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid server %q: no host", server)
+	}
+	// Drop an endpoint path ("/api/eye"), keeping a reverse-proxy prefix
+	if i := strings.Index(u.Path, "/api/"); i >= 0 {
+		u.Path = u.Path[:i]
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawQuery, u.Fragment, u.User = "", "", nil
+	return u.String(), nil
+}
+
+// URL returns the http(s) URL of an API path on the profile's server
+func (p *Profile) URL(path string) (string, error) {
+	base, err := BaseURL(p.Server)
+	if err != nil {
+		return "", err
+	}
+	return base + path, nil
+}
+
+// WebSocketURL returns the ws(s) URL of an API path on the profile's server
+func (p *Profile) WebSocketURL(path string) (string, error) {
+	u, err := p.URL(path)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(u, "https://") {
+		return "wss://" + strings.TrimPrefix(u, "https://"), nil
+	}
+	return "ws://" + strings.TrimPrefix(u, "http://"), nil
+}
+
+// TLSConfig verifies the server against the system roots plus the profile's
+// CA bundle, unless the profile is marked insecure
+func (p *Profile) TLSConfig() (*tls.Config, error) {
+	if p.Insecure {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	if p.CA == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(expandHome(p.CA))
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", p.CA)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// PTY stream client
+//
+// /api/pty attaches to the user's persistent tmux session. With the
+// "fs-pty.v1" subprotocol, binary frames carry terminal data both ways and
+// text frames carry JSON control messages (see pty_protocol.go on the
+// server). PTY is an io.ReadWriter over the terminal data.
+
+// PTYProtocol is the framing negotiated with the server
+const PTYProtocol = "fs-pty.v1"
+
+// PTYControl is a JSON control message on the PTY socket
+type PTYControl struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Cols    uint16          `json:"cols,omitempty"`
+	Rows    uint16          `json:"rows,omitempty"`
+	Data    string          `json:"data,omitempty"`
+	Expr    string          `json:"expr,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Command json.RawMessage `json:"command,omitempty"`
+}
+
+// PTYOptions configures a PTY connection
+type PTYOptions struct {
+	Page string // tab identifier reported to /api/eye/sessions
+
+	// OnControl receives control messages from the server other than
+	// errors (ipc_response, mcp_cmd, eye_cmd). It runs on the read path.
+	OnControl func(PTYControl)
+}
+
+// PTY is a terminal session. Reads and writes may happen concurrently.
+type PTY struct {
+	conn    *websocket.Conn
+	opts    PTYOptions
+	writeMu sync.Mutex
+	pending []byte // unread rest of the last data frame
+}
+
+// DialPTY attaches to the user's terminal session. The account must be a
+// system user (PAM login).
+func (c *Client) DialPTY(ctx context.Context, opts PTYOptions) (*PTY, error) {
+	query := url.Values{}
+	if opts.Page != "" {
+		query.Set("page", opts.Page)
+	}
+	conn, err := c.dial(ctx, "/api/pty", query, PTYProtocol)
+	if err != nil {
+		return nil, err
+	}
+	if conn.Subprotocol() != PTYProtocol {
+		conn.Close()
+		return nil, errors.New("pty: server does not support " + PTYProtocol)
+	}
+	return &PTY{conn: conn, opts: opts}, nil
+}
+
+// Read reads terminal output
+func (p *PTY) Read(b []byte) (int, error) {
+	for len(p.pending) == 0 {
+		msgType, data, err := p.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		if msgType == websocket.BinaryMessage {
+			p.pending = data
+			continue
+		}
+		var ctl PTYControl
+		if json.Unmarshal(data, &ctl) != nil {
+			continue
+		}
+		if ctl.Type == "error" {
+			return 0, errors.New("pty: " + ctl.Error)
+		}
+		if p.opts.OnControl != nil {
+			p.opts.OnControl(ctl)
+		}
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// Write sends terminal input
+func (p *PTY) Write(b []byte) (int, error) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if err := p.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Control sends a control message
+func (p *PTY) Control(ctl PTYControl) error {
+	data, err := json.Marshal(ctl)
+	if err != nil {
+		return err
+	}
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return p.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Resize sets the terminal size
+func (p *PTY) Resize(cols, rows uint16) error {
+	return p.Control(PTYControl{Type: "resize", Cols: cols, Rows: rows})
+}
+
+// CloseSession ends the tmux session for good instead of detaching
+func (p *PTY) CloseSession() error {
+	return p.Control(PTYControl{Type: "close_session"})
+}
+
+// Close detaches; the session keeps running on the server
+func (p *PTY) Close() error {
+	return p.conn.Close()
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Ticket statuses. A ticket moves open -> in_progress -> done; closed drops
+// it, and open reopens a done or closed ticket.
+const (
+	TicketOpen       = "open"
+	TicketInProgress = "in_progress"
+	TicketDone       = "done"
+	TicketClosed     = "closed"
+)
+
+// Ticket is a support ticket
+type Ticket struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Status      string        `json:"status"`
+	Created     string        `json:"created"`
+	Updated     string        `json:"updated,omitempty"`
+	Replies     []TicketReply `json:"replies,omitempty"`
+}
+
+// TicketReply is a reply on a ticket
+type TicketReply struct {
+	Date   string `json:"date"`
+	Author string `json:"author,omitempty"`
+	Text   string `json:"text"`
+}
+
+// Tickets lists all tickets
+func (c *Client) Tickets(ctx context.Context) ([]Ticket, error) {
+	var resp struct {
+		Tickets []Ticket `json:"tickets"`
+	}
+	if err := c.do(ctx, "GET", "/api/tickets", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Tickets, nil
+}
+
+// CreateTicket opens a ticket
+func (c *Client) CreateTicket(ctx context.Context, title, description string) (*Ticket, error) {
+	body := map[string]string{"title": title, "description": description}
+	return c.ticketCall(ctx, "POST", "/api/tickets", body)
+}
+
+// Ticket fetches one ticket; a missing ticket is an *APIError with Status 404
+func (c *Client) Ticket(ctx context.Context, id string) (*Ticket, error) {
+	return c.ticketCall(ctx, "GET", "/api/tickets/"+url.PathEscape(id), nil)
+}
+
+// TicketUpdate changes a ticket; nil fields are left as they are
+type TicketUpdate struct {
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Status      *string `json:"status,omitempty"`
+}
+
+// UpdateTicket edits a ticket or moves it to another status. A transition
+// the server doesn't allow is an *APIError with Status 409.
+func (c *Client) UpdateTicket(ctx context.Context, id string, update TicketUpdate) (*Ticket, error) {
+	return c.ticketCall(ctx, "PATCH", "/api/tickets/"+url.PathEscape(id), update)
+}
+
+// SetTicketStatus moves a ticket to status
+func (c *Client) SetTicketStatus(ctx context.Context, id, status string) (*Ticket, error) {
+	return c.UpdateTicket(ctx, id, TicketUpdate{Status: &status})
+}
+
+// ReplyTicket adds a reply to a ticket
+func (c *Client) ReplyTicket(ctx context.Context, id, text string) (*Ticket, error) {
+	return c.ticketCall(ctx, "POST", "/api/tickets/"+url.PathEscape(id)+"/replies", map[string]string{"text": text})
+}
+
+// DeleteTicket removes a ticket (admins only)
+func (c *Client) DeleteTicket(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/api/tickets/"+url.PathEscape(id), nil, nil, nil)
+}
+
+func (c *Client) ticketCall(ctx context.Context, method, path string, body interface{}) (*Ticket, error) {
+	var resp struct {
+		Ticket *Ticket `json:"ticket"`
+	}
+	if err := c.do(ctx, method, path, nil, body, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Ticket == nil {
+		return nil, fmt.Errorf("no ticket in response")
+	}
+	return resp.Ticket, nil
+}
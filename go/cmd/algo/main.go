@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const usage = `algo - Drive the FunctionServer desktop from the shell
+
+USAGE:
+  algo open <app|file>       Open an app by name, or a file with its default app
+  algo edit <file>           Open a file in Notepad
+  algo ide <file>            Open a file in javascript.ide
+  algo notify <message>      Show a desktop notification
+  algo copy [text]           Copy text (or stdin) to the browser clipboard
+  algo eval [expression]     Evaluate JS in the browser (or stdin), print the result
+  algo pub <channel> [data]  Publish to an IPC channel (data from stdin if omitted)
+  algo sub <channel>         Print messages from an IPC channel until interrupted
+
+Talks to the server over ~/.algo/ipc.sock (override with ALGO_SOCKET).
+
+EXAMPLES:
+  algo open ~/notes.md
+  algo open studio
+  git log -1 | algo copy
+  algo eval 'document.title'`
+
+// frame mirrors the server's IPC frame
+type frame struct {
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	Data    string `json:"data,omitempty"`
+	From    string `json:"from,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Println(usage)
+		return
+	}
+
+	cmd, args := os.Args[1], os.Args[2:]
+	var err error
+	switch cmd {
+	case "open":
+		err = needArgs(args, 1, "algo open <app|file>")
+		if err == nil {
+			if _, statErr := os.Stat(args[0]); statErr == nil {
+				path, name := browserPath(args[0])
+				err = action(fmt.Sprintf(`openFileWithDefaultHandler(%s, %s)`, jsString(path), jsString(name)))
+			} else {
+				err = action(fmt.Sprintf(`ALGO.bridge.openApp(%s)`, jsString(strings.Join(args, " "))))
+			}
+		}
+	case "edit", "ide":
+		err = needArgs(args, 1, "algo "+cmd+" <file>")
+		if err == nil {
+			opener := "openNotepad"
+			if cmd == "ide" {
+				opener = "openJSIDE"
+			}
+			path, name := browserPath(args[0])
+			err = action(fmt.Sprintf(`getFileFromDisk(%s).then(c => c === null ? {success: false, error: 'File not found'} : (%s(c, %s), {success: true}))`,
+				jsString(path), opener, jsString(name)))
+		}
+	case "notify":
+		err = needArgs(args, 1, "algo notify <message>")
+		if err == nil {
+			err = action(fmt.Sprintf(`(algoSpeak(%s), {success: true})`, jsString(strings.Join(args, " "))))
+		}
+	case "copy":
+		text := strings.Join(args, " ")
+		if len(args) == 0 {
+			text, err = readStdin()
+		}
+		if err == nil {
+			err = action(fmt.Sprintf(`navigator.clipboard.writeText(%s).then(() => ({success: true}))`, jsString(text)))
+		}
+	case "eval":
+		expr := strings.Join(args, " ")
+		if len(args) == 0 {
+			expr, err = readStdin()
+		}
+		if err == nil {
+			var result string
+			result, err = eval(expr)
+			if err == nil {
+				fmt.Println(result)
+			}
+		}
+	case "pub":
+		err = needArgs(args, 1, "algo pub <channel> [data]")
+		if err == nil {
+			data := strings.Join(args[1:], " ")
+			if len(args) == 1 {
+				data, err = readStdin()
+			}
+			if err == nil {
+				_, err = request(frame{Op: "pub", Channel: args[0], Data: data})
+			}
+		}
+	case "sub":
+		err = needArgs(args, 1, "algo sub <channel>")
+		if err == nil {
+			err = subscribe(args[0])
+		}
+	default:
+		err = fmt.Errorf("unknown command: %s (see algo --help)", cmd)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "algo: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func needArgs(args []string, n int, usage string) error {
+	if len(args) < n {
+		return fmt.Errorf("usage: %s", usage)
+	}
+	return nil
+}
+
+func socketPath() string {
+	if p := os.Getenv("ALGO_SOCKET"); p != "" {
+		return p
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".algo", "ipc.sock")
+}
+
+func dial() (net.Conn, error) {
+	conn, err := net.Dial("unix", socketPath())
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach FunctionServer at %s (is a Shell window open?): %v", socketPath(), err)
+	}
+	return conn, nil
+}
+
+// request sends one frame and waits for the reply carrying the same ID
+func request(req frame) (string, error) {
+	conn, err := dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	req.ID = "1"
+	data, _ := json.Marshal(req)
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return "", err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var resp frame
+		if json.Unmarshal(scanner.Bytes(), &resp) != nil || resp.ID != req.ID {
+			continue
+		}
+		if resp.Op == "error" {
+			return "", fmt.Errorf("%s", resp.Error)
+		}
+		return resp.Data, nil
+	}
+	return "", fmt.Errorf("connection closed")
+}
+
+// eval runs JS in the browser through the eye bridge
+func eval(expr string) (string, error) {
+	return request(frame{Op: "eval", Data: expr})
+}
+
+// action evals an expression that resolves to {success, error}
+func action(expr string) error {
+	result, err := eval(expr)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal([]byte(result), &status) == nil && !status.Success && status.Error != "" {
+		return fmt.Errorf("%s", status.Error)
+	}
+	return nil
+}
+
+// subscribe prints every message published on channel
+func subscribe(channel string) error {
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	data, _ := json.Marshal(frame{Op: "sub", ID: "sub", Channel: channel})
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg frame
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil {
+			continue
+		}
+		switch msg.Op {
+		case "error":
+			return fmt.Errorf("%s", msg.Error)
+		case "msg":
+			if channel == "*" {
+				fmt.Printf("%s: %s\n", msg.Channel, msg.Data)
+			} else {
+				fmt.Println(msg.Data)
+			}
+		}
+	}
+	return nil
+}
+
+// browserPath converts a local path to the ~/ form the browser file API expects
+func browserPath(p string) (path, name string) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p, filepath.Base(p)
+	}
+	name = filepath.Base(abs)
+	home, _ := os.UserHomeDir()
+	if rel, err := filepath.Rel(home, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return "~/" + filepath.ToSlash(rel), name
+	}
+	return abs, name
+}
+
+func readStdin() (string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// jsString quotes s as a JavaScript string literal
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
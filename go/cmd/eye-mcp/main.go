@@ -2,16 +2,17 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
-	"strings"
+	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"cecilia/client"
 )
 
 // MCP Protocol types
@@ -34,6 +35,13 @@ type MCPError struct {
 	Message string `json:"message"`
 }
 
+// MCPNotification is a server-to-client message with no ID
+type MCPNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
 type ToolCallParams struct {
 	Name      string          `json:"name"`
 	Arguments json.RawMessage `json:"arguments"`
@@ -43,178 +51,70 @@ type EyeArgs struct {
 	Expr string `json:"expr"`
 }
 
-// Config
-type Config struct {
-	Token  string `json:"token"`
-	Server string `json:"server"`
+// CancelledParams is the payload of notifications/cancelled
+type CancelledParams struct {
+	RequestID any    `json:"requestId"`
+	Reason    string `json:"reason,omitempty"`
 }
 
+const maxCallTimeout = 5 * time.Minute
+
 // Global state
 var (
-	conn       *websocket.Conn
-	connMu     sync.Mutex
-	pending    = make(map[string]chan string)
-	pendingMu  sync.Mutex
-	msgCounter uint64
-	config     Config
-)
-
-func loadConfig() error {
-	home, _ := os.UserHomeDir()
-	data, err := os.ReadFile(filepath.Join(home, ".algo", "config.json"))
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(data, &config)
-}
-
-func connect() error {
-	connMu.Lock()
-	defer connMu.Unlock()
+	eyeClient  *client.Client
+	eye        *client.Eye
+	eyeProfile client.Profile // what eye was opened with
+	eyeMu      sync.Mutex
 
-	if conn != nil {
-		conn.Close()
-		conn = nil
-	}
+	stdout   io.Writer = os.Stdout // responses and notifications, one per line
+	stdoutMu sync.Mutex
 
-	// Reload config in case token was refreshed
-	loadConfig()
+	inflight   = make(map[string]context.CancelFunc) // JSON request ID -> cancel
+	inflightMu sync.Mutex
+	running    sync.WaitGroup
 
-	url := config.Server
-	if !strings.Contains(url, "?") {
-		url += "?token=" + config.Token
-	}
+	workers     = envInt("EYE_MCP_WORKERS", 8)
+	callTimeout = envDuration("EYE_TIMEOUT", 30*time.Second)
+)
 
-	var err error
-	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+// connection returns the API client and eye connection for the current
+// profile (EYE_PROFILE, or the current one in ~/.algo/config.json). The eye
+// socket redials in the background when it drops; both are replaced when
+// the login changes, e.g. after `eye login`.
+func connection() (*client.Client, *client.Eye, error) {
+	p, err := client.LoadProfile("")
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-
-	// Start reader goroutine
-	go readLoop()
-	return nil
-}
-
-func readLoop() {
-	for {
-		connMu.Lock()
-		c := conn
-		connMu.Unlock()
-
-		if c == nil {
-			return
-		}
-
-		_, msg, err := c.ReadMessage()
-		if err != nil {
-			// Connection died - clear it so next evalExpr reconnects
-			connMu.Lock()
-			if conn == c {
-				conn.Close()
-				conn = nil
-			}
-			connMu.Unlock()
-			return
-		}
-
-		// Parse response: "id:result" or "id!:error"
-		s := string(msg)
-		if idx := strings.Index(s, ":"); idx > 0 {
-			id := s[:idx]
-			isError := strings.HasSuffix(id, "!")
-			if isError {
-				id = id[:len(id)-1]
-			}
-			result := s[idx+1:]
-
-			pendingMu.Lock()
-			if ch, ok := pending[id]; ok {
-				if isError {
-					ch <- "ERROR:" + result
-				} else {
-					ch <- result
-				}
-				delete(pending, id)
-			}
-			pendingMu.Unlock()
-		}
+	if p.Token == "" {
+		return nil, nil, fmt.Errorf("no token (run: eye login)")
 	}
-}
-
-func evalExpr(expr string) (string, error) {
-	connMu.Lock()
-	c := conn
-	connMu.Unlock()
 
-	if c == nil {
-		if err := connect(); err != nil {
-			return "", fmt.Errorf("connection failed: %v", err)
-		}
-		connMu.Lock()
-		c = conn
-		connMu.Unlock()
+	eyeMu.Lock()
+	defer eyeMu.Unlock()
+	if eye != nil && *p == eyeProfile {
+		return eyeClient, eye, nil
 	}
-
-	// Generate unique ID
-	id := fmt.Sprintf("m%d", atomic.AddUint64(&msgCounter, 1))
-
-	// Create response channel
-	ch := make(chan string, 1)
-	pendingMu.Lock()
-	pending[id] = ch
-	pendingMu.Unlock()
-
-	// Send message
-	msg := id + ":" + expr
-	if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
-		pendingMu.Lock()
-		delete(pending, id)
-		pendingMu.Unlock()
-
-		// Connection died - clear it and retry once
-		connMu.Lock()
-		if conn == c {
-			conn.Close()
-			conn = nil
-		}
-		connMu.Unlock()
-
-		// Retry with fresh connection
-		if err := connect(); err != nil {
-			return "", fmt.Errorf("reconnect failed: %v", err)
-		}
-		connMu.Lock()
-		c = conn
-		connMu.Unlock()
-
-		// Re-register pending channel and resend
-		ch = make(chan string, 1)
-		pendingMu.Lock()
-		pending[id] = ch
-		pendingMu.Unlock()
-
-		if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
-			pendingMu.Lock()
-			delete(pending, id)
-			pendingMu.Unlock()
-			return "", fmt.Errorf("write failed after reconnect: %v", err)
-		}
+	c, err := client.New(p)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	// Wait for response with timeout
-	select {
-	case result := <-ch:
-		if strings.HasPrefix(result, "ERROR:") {
-			return "", fmt.Errorf("%s", result[6:])
-		}
-		return result, nil
-	case <-time.After(30 * time.Second):
-		pendingMu.Lock()
-		delete(pending, id)
-		pendingMu.Unlock()
-		return "", fmt.Errorf("timeout")
+	if eye != nil {
+		eye.Close()
 	}
+	eye = c.OpenEye(client.EyeOptions{
+		IDPrefix:  "m",
+		Reconnect: true,
+		OnState: func(connected bool, err error) {
+			if connected {
+				fmt.Fprintf(os.Stderr, "eye-mcp: connected to %s\n", p.Server)
+			} else {
+				fmt.Fprintf(os.Stderr, "eye-mcp: disconnected from %s: %v\n", p.Server, err)
+			}
+		},
+	})
+	eyeClient, eyeProfile = c, *p
+	return eyeClient, eye, nil
 }
 
 func sendResponse(id any, result any, err *MCPError) {
@@ -225,16 +125,27 @@ func sendResponse(id any, result any, err *MCPError) {
 		Error:   err,
 	}
 	data, _ := json.Marshal(resp)
-	fmt.Println(string(data))
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	fmt.Fprintln(stdout, string(data))
+}
+
+func sendNotification(method string, params any) {
+	data, _ := json.Marshal(MCPNotification{JSONRPC: "2.0", Method: method, Params: params})
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	fmt.Fprintln(stdout, string(data))
 }
 
-func handleRequest(req MCPRequest) {
+func handleRequest(ctx context.Context, req MCPRequest) {
 	switch req.Method {
 	case "initialize":
 		sendResponse(req.ID, map[string]any{
 			"protocolVersion": "2024-11-05",
 			"capabilities": map[string]any{
-				"tools": map[string]any{},
+				"tools":     map[string]any{},
+				"resources": map[string]any{"subscribe": true},
+				"prompts":   map[string]any{},
 			},
 			"serverInfo": map[string]any{
 				"name":    "eye-mcp",
@@ -246,24 +157,15 @@ func handleRequest(req MCPRequest) {
 		// No response needed for notifications
 
 	case "tools/list":
-		sendResponse(req.ID, map[string]any{
-			"tools": []map[string]any{
-				{
-					"name":        "eye",
-					"description": "Execute JavaScript in the browser via persistent WebSocket. Returns the result of the expression.",
-					"inputSchema": map[string]any{
-						"type": "object",
-						"properties": map[string]any{
-							"expr": map[string]any{
-								"type":        "string",
-								"description": "JavaScript expression to evaluate in the browser",
-							},
-						},
-						"required": []string{"expr"},
-					},
-				},
-			},
-		}, nil)
+		list := make([]map[string]any, 0, len(tools))
+		for _, t := range tools {
+			list = append(list, map[string]any{
+				"name":        t.Name,
+				"description": t.Description,
+				"inputSchema": t.Schema,
+			})
+		}
+		sendResponse(req.ID, map[string]any{"tools": list}, nil)
 
 	case "tools/call":
 		var params ToolCallParams
@@ -272,50 +174,199 @@ func handleRequest(req MCPRequest) {
 			return
 		}
 
-		if params.Name != "eye" {
+		tool := findTool(params.Name)
+		if tool == nil {
 			sendResponse(req.ID, nil, &MCPError{Code: -32601, Message: "Unknown tool"})
 			return
 		}
+		if len(params.Arguments) == 0 {
+			params.Arguments = json.RawMessage("{}")
+		}
+		if missing := tool.missingArgs(params.Arguments); missing != "" {
+			sendResponse(req.ID, nil, &MCPError{Code: -32602, Message: "Missing argument: " + missing})
+			return
+		}
+
+		// Any tool may take "timeout" (seconds); eye documents it
+		var opts struct {
+			Timeout float64 `json:"timeout"`
+		}
+		json.Unmarshal(params.Arguments, &opts)
+		timeout := callTimeout
+		if opts.Timeout > 0 {
+			timeout = time.Duration(opts.Timeout * float64(time.Second))
+		}
+		if timeout > maxCallTimeout {
+			timeout = maxCallTimeout
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
 
-		var args EyeArgs
-		if err := json.Unmarshal(params.Arguments, &args); err != nil {
+		result, err := tool.Run(ctx, params.Arguments)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return // cancelled by the client, which expects no response
+		}
+		if err != nil {
 			sendResponse(req.ID, nil, &MCPError{Code: -32602, Message: "Invalid arguments"})
 			return
 		}
+		sendResponse(req.ID, result, nil)
 
-		result, err := evalExpr(args.Expr)
+	case "resources/list":
+		ctx, cancel := context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+		list, err := listResources(ctx)
 		if err != nil {
-			sendResponse(req.ID, map[string]any{
-				"content": []map[string]any{
-					{"type": "text", "text": "Error: " + err.Error()},
-				},
-				"isError": true,
-			}, nil)
+			sendResponse(req.ID, nil, &MCPError{Code: -32603, Message: err.Error()})
 			return
 		}
+		sendResponse(req.ID, map[string]any{"resources": list}, nil)
 
-		sendResponse(req.ID, map[string]any{
-			"content": []map[string]any{
-				{"type": "text", "text": result},
-			},
-		}, nil)
+	case "resources/templates/list":
+		sendResponse(req.ID, map[string]any{"resourceTemplates": resourceTemplates}, nil)
+
+	case "resources/read", "resources/subscribe", "resources/unsubscribe":
+		var params ResourceParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.URI == "" {
+			sendResponse(req.ID, nil, &MCPError{Code: -32602, Message: "Invalid params"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+
+		var result any = map[string]any{}
+		var err error
+		switch req.Method {
+		case "resources/read":
+			var contents *ResourceContents
+			if contents, err = readResource(ctx, params.URI); err == nil {
+				result = map[string]any{"contents": []*ResourceContents{contents}}
+			}
+		case "resources/subscribe":
+			err = subscribe(ctx, params.URI)
+		default:
+			unsubscribe(params.URI)
+		}
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return
+		}
+		if errors.Is(err, ErrResourceNotFound) {
+			sendResponse(req.ID, nil, &MCPError{Code: -32002, Message: "Resource not found: " + params.URI})
+			return
+		}
+		if err != nil {
+			sendResponse(req.ID, nil, &MCPError{Code: -32603, Message: err.Error()})
+			return
+		}
+		sendResponse(req.ID, result, nil)
+
+	case "prompts/list":
+		sendResponse(req.ID, map[string]any{"prompts": prompts}, nil)
+
+	case "prompts/get":
+		var params PromptGetParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			sendResponse(req.ID, nil, &MCPError{Code: -32602, Message: "Invalid params"})
+			return
+		}
+		prompt := findPrompt(params.Name)
+		if prompt == nil {
+			sendResponse(req.ID, nil, &MCPError{Code: -32602, Message: "Unknown prompt: " + params.Name})
+			return
+		}
+		for _, arg := range prompt.Arguments {
+			if arg.Required && params.Arguments[arg.Name] == "" {
+				sendResponse(req.ID, nil, &MCPError{Code: -32602, Message: "Missing argument: " + arg.Name})
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+		messages, err := prompt.Get(ctx, params.Arguments)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return
+		}
+		if err != nil {
+			sendResponse(req.ID, nil, &MCPError{Code: -32603, Message: err.Error()})
+			return
+		}
+		sendResponse(req.ID, map[string]any{"description": prompt.Description, "messages": messages}, nil)
 
 	default:
 		sendResponse(req.ID, nil, &MCPError{Code: -32601, Message: "Method not found"})
 	}
 }
 
-func main() {
-	// Load config
-	if err := loadConfig(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
-		os.Exit(1)
+// dispatch runs a request on the worker pool. Requests are read (and can
+// be cancelled) while earlier ones are still running.
+func dispatch(req MCPRequest, slots chan struct{}) {
+	if req.Method == "notifications/cancelled" {
+		var params CancelledParams
+		if json.Unmarshal(req.Params, &params) == nil {
+			inflightMu.Lock()
+			if cancel, ok := inflight[requestKey(params.RequestID)]; ok {
+				cancel()
+			}
+			inflightMu.Unlock()
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	key := ""
+	if req.ID != nil {
+		key = requestKey(req.ID)
+		inflightMu.Lock()
+		inflight[key] = cancel
+		inflightMu.Unlock()
+	}
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer cancel()
+		defer func() {
+			if key != "" {
+				inflightMu.Lock()
+				delete(inflight, key)
+				inflightMu.Unlock()
+			}
+		}()
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-ctx.Done():
+			return // cancelled while queued
+		}
+		handleRequest(ctx, req)
+	}()
+}
+
+// requestKey identifies a JSON-RPC ID, which may be a number or a string
+func requestKey(id any) string {
+	data, _ := json.Marshal(id)
+	return string(data)
+}
+
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
 	}
+	return def
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
 
-	// Connect to WebSocket
-	if err := connect(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect: %v\n", err)
-		os.Exit(1)
+func main() {
+	// Start connecting; tool calls report a clear error until the server is up
+	if _, _, err := connection(); err != nil {
+		fmt.Fprintf(os.Stderr, "eye-mcp: %v\n", err)
 	}
 
 	// Read MCP requests from stdin
@@ -323,6 +374,7 @@ func main() {
 	// Increase buffer size for large messages
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
 
+	slots := make(chan struct{}, workers)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" {
@@ -334,6 +386,9 @@ func main() {
 			continue
 		}
 
-		handleRequest(req)
+		dispatch(req, slots)
 	}
+
+	// Let calls in flight answer before exiting
+	running.Wait()
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cecilia/client"
+
+	"github.com/gorilla/websocket"
+)
+
+const testToken = "test-token"
+
+// newEyeServer fakes /api/eye and points the eye tools at it. "hang" is
+// never answered, "drop" closes the socket, "together" is answered once two
+// of them are waiting, anything else with "id:=<expr>". It returns the
+// number of sockets accepted so far.
+func newEyeServer(t *testing.T) *atomic.Int32 {
+	t.Helper()
+	var dials atomic.Int32
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/eye" || r.URL.Query().Get("token") != testToken {
+			http.Error(w, "Invalid token", 401)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dials.Add(1)
+		conn.WriteMessage(websocket.TextMessage, []byte(":ready"))
+
+		var waiting []string
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			id, expr, _ := strings.Cut(string(msg), ":")
+			switch expr {
+			case "hang":
+			case "drop":
+				return
+			case "together":
+				if waiting = append(waiting, id); len(waiting) == 2 {
+					for _, id := range waiting {
+						conn.WriteMessage(websocket.TextMessage, []byte(id+":=together"))
+					}
+					waiting = nil
+				}
+			default:
+				conn.WriteMessage(websocket.TextMessage, []byte(id+":="+expr))
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("ALGO_CONFIG", t.TempDir()+"/config.json")
+	t.Setenv("EYE_PROFILE", "")
+	t.Setenv("EYE_SERVER", srv.URL)
+	t.Setenv("EYE_TOKEN", testToken)
+	t.Cleanup(func() {
+		eyeMu.Lock()
+		if eye != nil {
+			eye.Close()
+		}
+		eye, eyeClient, eyeProfile = nil, nil, client.Profile{}
+		eyeMu.Unlock()
+	})
+	return &dials
+}
+
+// responseWriter collects what handleRequest prints, one response per line
+type responseWriter chan MCPResponse
+
+func (rw responseWriter) Write(p []byte) (int, error) {
+	var resp MCPResponse
+	if err := json.Unmarshal(p, &resp); err != nil {
+		return 0, err
+	}
+	rw <- resp
+	return len(p), nil
+}
+
+func captureResponses(t *testing.T) responseWriter {
+	t.Helper()
+	rw := make(responseWriter, 16)
+	old := stdout
+	stdout = rw
+	t.Cleanup(func() { stdout = old })
+	return rw
+}
+
+// callEye runs an eye tools/call in the background; timeout is in seconds
+func callEye(id int, expr string, timeout float64) {
+	args, _ := json.Marshal(map[string]any{"expr": expr, "timeout": timeout})
+	params, _ := json.Marshal(ToolCallParams{Name: "eye", Arguments: args})
+	go handleRequest(context.Background(), MCPRequest{JSONRPC: "2.0", ID: id, Method: "tools/call", Params: params})
+}
+
+// waitResponses collects n responses, keyed by request ID
+func waitResponses(t *testing.T, rw responseWriter, n int) map[string]string {
+	t.Helper()
+	got := map[string]string{}
+	for len(got) < n {
+		select {
+		case resp := <-rw:
+			if resp.Error != nil {
+				t.Fatalf("response %v: %+v", resp.ID, resp.Error)
+			}
+			data, _ := json.Marshal(resp.Result)
+			var result ToolResult
+			json.Unmarshal(data, &result)
+			text := ""
+			if len(result.Content) > 0 {
+				text = result.Content[0].Text
+			}
+			got[fmt.Sprint(resp.ID)] = text
+		case <-time.After(10 * time.Second):
+			t.Fatalf("got %d of %d responses: %v", len(got), n, got)
+		}
+	}
+	return got
+}
+
+func TestCallsRunInParallel(t *testing.T) {
+	newEyeServer(t)
+	rw := captureResponses(t)
+
+	// Neither is answered until both have reached the browser
+	callEye(1, "together", 5)
+	callEye(2, "together", 5)
+	got := waitResponses(t, rw, 2)
+	if got["1"] != "=together" || got["2"] != "=together" {
+		t.Errorf("responses = %v", got)
+	}
+}
+
+func TestTimeoutFailsOnlyItsCall(t *testing.T) {
+	newEyeServer(t)
+	rw := captureResponses(t)
+
+	callEye(1, "hang", 0.2)
+	callEye(2, "1+1", 5)
+	got := waitResponses(t, rw, 2)
+	if got["1"] != "Error: timeout" {
+		t.Errorf("hung call: got %q, want a timeout", got["1"])
+	}
+	if got["2"] != "=1+1" {
+		t.Errorf("other call: got %q", got["2"])
+	}
+}
+
+func TestDroppedSocket(t *testing.T) {
+	dials := newEyeServer(t)
+	rw := captureResponses(t)
+
+	callEye(1, "drop", 5)
+	got := waitResponses(t, rw, 1)
+	if got["1"] != "Error: "+client.ErrDisconnected.Error() {
+		t.Errorf("dropped call: got %q, want ErrDisconnected", got["1"])
+	}
+
+	// The next call goes out on a fresh socket
+	callEye(2, "1+1", 5)
+	got = waitResponses(t, rw, 1)
+	if got["2"] != "=1+1" {
+		t.Errorf("call after reconnect: got %q", got["2"])
+	}
+	if n := dials.Load(); n < 2 {
+		t.Errorf("dialed %d times, want a reconnect", n)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Prompts - canned tasks for this project. build_algo_app is built from the
+// server's eye-instructions.txt so it stays in step with the eye guide;
+// the others embed live resources.
+
+// PromptArgument is an argument of a prompt
+type PromptArgument struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+}
+
+// Prompt is one MCP prompt
+type Prompt struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Arguments   []PromptArgument `json:"arguments,omitempty"`
+
+	Get func(ctx context.Context, args map[string]string) ([]PromptMessage, error) `json:"-"`
+}
+
+// PromptMessage is one message of prompts/get; Content is a text or
+// embedded resource block
+type PromptMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type PromptGetParams struct {
+	Name      string            `json:"name"`
+	Arguments map[string]string `json:"arguments"`
+}
+
+func userText(text string) PromptMessage {
+	return PromptMessage{Role: "user", Content: map[string]any{"type": "text", "text": text}}
+}
+
+// userResource embeds a resource, or a note saying why it couldn't be read
+func userResource(ctx context.Context, uri string) PromptMessage {
+	contents, err := readResource(ctx, uri)
+	if err != nil {
+		return userText(fmt.Sprintf("(%s unavailable: %v)", uri, err))
+	}
+	return PromptMessage{Role: "user", Content: map[string]any{"type": "resource", "resource": contents}}
+}
+
+var prompts = []Prompt{
+	{
+		Name:        "build_algo_app",
+		Description: "Build a desktop app for ALGO and try it in the live browser",
+		Arguments: []PromptArgument{
+			{Name: "name", Description: "App name, e.g. \"Pomodoro\"", Required: true},
+			{Name: "description", Description: "What the app should do"},
+		},
+		Get: func(ctx context.Context, args map[string]string) ([]PromptMessage, error) {
+			guide, err := instructions(ctx)
+			if err != nil {
+				return nil, err
+			}
+			name := args["name"]
+			prefix := "_" + appSlug(name) + "_"
+			task := fmt.Sprintf(`Build an ALGO desktop app called %q.`, name)
+			if d := strings.TrimSpace(args["description"]); d != "" {
+				task += "\n\nIt should: " + d
+			}
+			task += fmt.Sprintf(`
+
+Apps are plain JavaScript run in the desktop page, like the system apps in
+core/apps/. Set ALGO.app.name and ALGO.app.icon (an emoji) at the top, open
+the UI with ALGO.createWindow({title, icon, width, height, content}) where
+content is an HTML string, and keep state in localStorage. Every app shares
+one global scope, so prefix top-level functions and variables with %s and
+call them from inline handlers (onclick="%sadd()").
+
+Save it with write_file as ~/apps/%s.js; files under ~/apps run as apps when
+opened. Try it with the eye tool: runApp(<code>, %q) opens it, and
+list_windows, screenshot and the Key APIs below let you check the result.
+Fix anything that throws before you finish.
+
+The eye guide for this desktop follows.
+
+`, prefix, prefix, appSlug(name), name)
+			return []PromptMessage{userText(task + guide)}, nil
+		},
+	},
+	{
+		Name:        "debug_desktop",
+		Description: "Investigate a problem on the desktop, starting from its current state",
+		Arguments: []PromptArgument{
+			{Name: "problem", Description: "What is going wrong", Required: true},
+		},
+		Get: func(ctx context.Context, args map[string]string) ([]PromptMessage, error) {
+			return []PromptMessage{
+				userText("Something is wrong on my ALGO desktop: " + args["problem"] + "\n\n" +
+					"The current desktop state is attached. Use the eye tool to inspect the page " +
+					"(console errors, DOM, localStorage) and screenshot to see it; explain the cause " +
+					"before changing anything."),
+				userResource(ctx, desktopURI),
+			}, nil
+		},
+	},
+	{
+		Name:        "triage_tickets",
+		Description: "Review the open tickets and propose what to do next",
+		Get: func(ctx context.Context, args map[string]string) ([]PromptMessage, error) {
+			return []PromptMessage{
+				userText("These are the open tickets. Group duplicates, flag anything unclear, " +
+					"and order the rest by impact with a one-line plan for each."),
+				userResource(ctx, ticketsURI),
+			}, nil
+		},
+	},
+}
+
+func findPrompt(name string) *Prompt {
+	for i := range prompts {
+		if prompts[i].Name == name {
+			return &prompts[i]
+		}
+	}
+	return nil
+}
+
+// instructions returns eye-instructions.txt filled in for the current
+// profile. The token is left out: the agent uses eye-mcp's own login.
+func instructions(ctx context.Context) (string, error) {
+	c, _, err := connection()
+	if err != nil {
+		return "", err
+	}
+	text, err := c.EyeInstructions(ctx)
+	if err != nil {
+		return "", err
+	}
+	server, err := c.Profile.WebSocketURL("/api/eye")
+	if err != nil {
+		return "", err
+	}
+	config, _ := json.MarshalIndent(map[string]string{"server": server, "token": "(run: eye login)"}, "", "  ")
+	return strings.NewReplacer(
+		"{{CONFIG}}", string(config),
+		"{{USER}}", c.Profile.Username,
+		"{{SERVER}}", server,
+	).Replace(text), nil
+}
+
+var nonSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// appSlug turns an app name into a file and identifier stem ("My App" -> "my_app")
+func appSlug(name string) string {
+	slug := strings.Trim(nonSlug.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if slug == "" {
+		return "app"
+	}
+	return slug
+}
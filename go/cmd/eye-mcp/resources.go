@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"cecilia/client"
+)
+
+// Resources - the user's home files, the desktop state and tickets, read
+// through the same API client and eye connection as the tools:
+//
+//	algo://files/<path>     a file under home
+//	algo://desktop/state    ALGO.bridge.getState()
+//	algo://tickets          open tickets
+//	algo://tickets/<id>     one ticket with its replies
+//
+// Subscribed resources are polled and notifications/resources/updated is
+// sent when their content changes.
+
+const (
+	filesURI   = "algo://files/"
+	desktopURI = "algo://desktop/state"
+	ticketsURI = "algo://tickets"
+
+	maxListedFiles = 200
+)
+
+var pollInterval = envDuration("EYE_MCP_POLL", 5*time.Second)
+
+// ErrResourceNotFound is returned for URIs that name nothing
+var ErrResourceNotFound = errors.New("resource not found")
+
+// Resource is an entry of resources/list
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
+
+// ResourceContents is the content of a resource: Text, or Blob (base64)
+// for binary files
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+// MarshalJSON sends exactly one of text and blob
+func (rc *ResourceContents) MarshalJSON() ([]byte, error) {
+	if rc.Blob == "" {
+		type plain ResourceContents
+		return json.Marshal((*plain)(rc))
+	}
+	return json.Marshal(struct {
+		URI      string `json:"uri"`
+		MimeType string `json:"mimeType,omitempty"`
+		Blob     string `json:"blob"`
+	}{rc.URI, rc.MimeType, rc.Blob})
+}
+
+type ResourceParams struct {
+	URI string `json:"uri"`
+}
+
+var resourceTemplates = []map[string]any{
+	{
+		"uriTemplate": filesURI + "{path}",
+		"name":        "Home file",
+		"description": "A file in the user's home directory; path is relative to home",
+	},
+	{
+		"uriTemplate": ticketsURI + "/{id}",
+		"name":        "Ticket",
+		"description": "A ticket with its replies",
+		"mimeType":    "application/json",
+	},
+}
+
+// listResources returns the fixed resources, open tickets and home files
+// (two levels deep, dot files skipped, at most maxListedFiles)
+func listResources(ctx context.Context) ([]Resource, error) {
+	list := []Resource{
+		{URI: desktopURI, Name: "Desktop state", Description: "Open windows, active window, user and apps", MimeType: "application/json"},
+		{URI: ticketsURI, Name: "Open tickets", MimeType: "application/json"},
+	}
+	c, _, err := connection()
+	if err != nil {
+		return nil, err
+	}
+
+	// Tickets are optional; files and the desktop are listed without them
+	if tickets, err := c.Tickets(ctx); err == nil {
+		for _, t := range tickets {
+			if t.Status != client.TicketOpen {
+				continue
+			}
+			list = append(list, Resource{URI: ticketsURI + "/" + t.ID, Name: t.ID + ": " + t.Title, MimeType: "application/json"})
+		}
+	}
+
+	files := 0
+	var walk func(dir string, depth int) error
+	walk = func(dir string, depth int) error {
+		listing, err := c.ListFiles(ctx, "~/"+dir)
+		if err != nil {
+			return err
+		}
+		for _, f := range listing.Files {
+			if strings.HasPrefix(f.Name, ".") || files >= maxListedFiles {
+				continue
+			}
+			rel := path.Join(dir, f.Name)
+			if f.IsDir() {
+				if depth > 1 {
+					walk(rel, depth-1) // unreadable subdirectories are left out
+				}
+				continue
+			}
+			files++
+			list = append(list, Resource{URI: fileURI(rel), Name: "~/" + rel, MimeType: fileMimeType(rel)})
+		}
+		return nil
+	}
+	if err := walk("", 2); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// readResource returns a resource's content; ErrResourceNotFound if the URI
+// names nothing
+func readResource(ctx context.Context, uri string) (*ResourceContents, error) {
+	c, eye, err := connection()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case uri == desktopURI:
+		result, err := eye.Eval(ctx, `ALGO.bridge.getState().result`)
+		if err != nil {
+			return nil, err
+		}
+		return &ResourceContents{URI: uri, MimeType: "application/json", Text: result}, nil
+
+	case uri == ticketsURI:
+		tickets, err := c.Tickets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		open := []client.Ticket{}
+		for _, t := range tickets {
+			if t.Status == client.TicketOpen {
+				open = append(open, t)
+			}
+		}
+		return jsonContents(uri, open)
+
+	case strings.HasPrefix(uri, ticketsURI+"/"):
+		t, err := c.Ticket(ctx, strings.TrimPrefix(uri, ticketsURI+"/"))
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == 404 {
+			return nil, ErrResourceNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		return jsonContents(uri, t)
+
+	case strings.HasPrefix(uri, filesURI):
+		rel, err := url.PathUnescape(strings.TrimPrefix(uri, filesURI))
+		if err != nil || rel == "" {
+			return nil, ErrResourceNotFound
+		}
+		data, err := c.ReadFileBytes(ctx, "~/"+rel)
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) && apiErr.Status == 404 {
+			return nil, ErrResourceNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		mimeType := fileMimeType(rel)
+		if !isTextMimeType(mimeType) || !utf8.Valid(data) {
+			if isTextMimeType(mimeType) {
+				mimeType = "application/octet-stream" // no extension, binary content
+			}
+			return &ResourceContents{URI: uri, MimeType: mimeType, Blob: base64.StdEncoding.EncodeToString(data)}, nil
+		}
+		return &ResourceContents{URI: uri, MimeType: mimeType, Text: string(data)}, nil
+	}
+	return nil, ErrResourceNotFound
+}
+
+func jsonContents(uri string, v any) (*ResourceContents, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceContents{URI: uri, MimeType: "application/json", Text: string(data)}, nil
+}
+
+func fileURI(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return filesURI + strings.Join(parts, "/")
+}
+
+func fileMimeType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "text/plain"
+}
+
+// isTextMimeType reports whether files of this type are returned as text
+func isTextMimeType(mimeType string) bool {
+	t, _, _ := mime.ParseMediaType(mimeType)
+	switch {
+	case strings.HasPrefix(t, "text/"),
+		strings.HasSuffix(t, "+json"), strings.HasSuffix(t, "+xml"):
+		return true
+	}
+	switch t {
+	case "application/json", "application/javascript", "application/xml",
+		"application/x-sh", "application/toml", "application/yaml", "application/x-yaml":
+		return true
+	}
+	return false
+}
+
+// Subscriptions
+
+var (
+	subscriptions = make(map[string]string) // URI -> hash of last content
+	subsMu        sync.Mutex
+	pollOnce      sync.Once
+)
+
+func subscribe(ctx context.Context, uri string) error {
+	contents, err := readResource(ctx, uri)
+	if errors.Is(err, ErrResourceNotFound) {
+		return err
+	}
+	hash := "" // unreadable for now; the first successful poll counts as a change
+	if err == nil {
+		hash = contentHash(contents)
+	}
+	subsMu.Lock()
+	subscriptions[uri] = hash
+	subsMu.Unlock()
+	pollOnce.Do(func() { go pollSubscriptions() })
+	return nil
+}
+
+func unsubscribe(uri string) {
+	subsMu.Lock()
+	delete(subscriptions, uri)
+	subsMu.Unlock()
+}
+
+// pollSubscriptions re-reads subscribed resources every pollInterval.
+// Resources that fail to read (server or browser away) keep their last hash.
+func pollSubscriptions() {
+	for range time.Tick(pollInterval) {
+		subsMu.Lock()
+		uris := make([]string, 0, len(subscriptions))
+		for uri := range subscriptions {
+			uris = append(uris, uri)
+		}
+		subsMu.Unlock()
+
+		for _, uri := range uris {
+			ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+			contents, err := readResource(ctx, uri)
+			cancel()
+			if err != nil {
+				continue
+			}
+			hash := contentHash(contents)
+
+			subsMu.Lock()
+			last, ok := subscriptions[uri]
+			changed := ok && last != hash
+			if changed {
+				subscriptions[uri] = hash
+			}
+			subsMu.Unlock()
+			if changed {
+				sendNotification("notifications/resources/updated", ResourceParams{URI: uri})
+			}
+		}
+	}
+}
+
+func contentHash(c *ResourceContents) string {
+	sum := sha256.Sum256([]byte(c.Text + c.Blob))
+	return fmt.Sprintf("%x", sum)
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cecilia/client"
+)
+
+// Tools - `eye` evaluates raw JS; the rest are typed wrappers over the
+// server's file and exec APIs and the desktop's ALGO.bridge, so agents don't
+// have to write JS for common actions.
+
+// Tool is one MCP tool
+type Tool struct {
+	Name        string
+	Description string
+	Schema      map[string]any
+	Run         func(ctx context.Context, args json.RawMessage) (*ToolResult, error)
+}
+
+// ToolResult is the result of tools/call
+type ToolResult struct {
+	Content []client.ToolContent `json:"content"`
+	IsError bool                 `json:"isError,omitempty"`
+}
+
+func textResult(text string) *ToolResult {
+	return &ToolResult{Content: []client.ToolContent{{Type: "text", Text: text}}}
+}
+
+func errorResult(err error) *ToolResult {
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = errors.New("timeout")
+	}
+	return &ToolResult{Content: []client.ToolContent{{Type: "text", Text: "Error: " + err.Error()}}, IsError: true}
+}
+
+// schema builds an object schema; props maps name -> [type, description]
+func schema(props map[string][2]string, required ...string) map[string]any {
+	properties := map[string]any{}
+	for name, p := range props {
+		properties[name] = map[string]any{"type": p[0], "description": p[1]}
+	}
+	s := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+var tools = []Tool{
+	{
+		Name:        "eye",
+		Description: "Execute JavaScript in the browser via persistent WebSocket. Returns the result of the expression.",
+		Schema: schema(map[string][2]string{
+			"expr":    {"string", "JavaScript expression to evaluate in the browser"},
+			"timeout": {"number", "Seconds to wait for the result (default 30, max 300)"},
+		}, "expr"),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args EyeArgs
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			_, eye, err := connection()
+			if err != nil {
+				return errorResult(err), nil
+			}
+			result, err := eye.Eval(ctx, args.Expr)
+			if err != nil {
+				return errorResult(err), nil
+			}
+			return textResult(result), nil
+		},
+	},
+	{
+		Name:        "read_file",
+		Description: "Read a text file from the user's home directory.",
+		Schema: schema(map[string][2]string{
+			"path": {"string", "File path, relative to home or starting with ~/"},
+		}, "path"),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args struct {
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			return withClient(func(c *client.Client) (*ToolResult, error) {
+				file, err := c.ReadFile(ctx, args.Path)
+				if err != nil {
+					return nil, err
+				}
+				return textResult(file.Content), nil
+			})
+		},
+	},
+	{
+		Name:        "write_file",
+		Description: "Create or overwrite a text file in the user's home directory. Parent directories are created.",
+		Schema: schema(map[string][2]string{
+			"path":    {"string", "File path, relative to home or starting with ~/"},
+			"content": {"string", "New file content"},
+		}, "path", "content"),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args struct {
+				Path    string `json:"path"`
+				Content string `json:"content"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			return withClient(func(c *client.Client) (*ToolResult, error) {
+				path, err := c.WriteFile(ctx, args.Path, args.Content)
+				if err != nil {
+					return nil, err
+				}
+				return textResult(fmt.Sprintf("Saved %s (%d bytes)", path, len(args.Content))), nil
+			})
+		},
+	},
+	{
+		Name:        "list_files",
+		Description: "List a directory in the user's home. Directories end with /.",
+		Schema: schema(map[string][2]string{
+			"path": {"string", "Directory path (default: home)"},
+		}),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args struct {
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			return withClient(func(c *client.Client) (*ToolResult, error) {
+				list, err := c.ListFiles(ctx, args.Path)
+				if err != nil {
+					return nil, err
+				}
+				var b strings.Builder
+				b.WriteString(list.Path + "\n")
+				for _, f := range list.Files {
+					modified := time.Unix(f.Modified, 0).Format("2006-01-02 15:04")
+					if f.IsDir() {
+						fmt.Fprintf(&b, "%10s  %s  %s/\n", "-", modified, f.Name)
+					} else {
+						fmt.Fprintf(&b, "%10d  %s  %s\n", f.Size, modified, f.Name)
+					}
+				}
+				return textResult(b.String()), nil
+			})
+		},
+	},
+	{
+		Name:        "run_command",
+		Description: "Run a shell command in the user's home (server allow-list applies) and return its combined output.",
+		Schema: schema(map[string][2]string{
+			"command": {"string", "Command line to run"},
+			"cwd":     {"string", "Working directory (default: home)"},
+		}, "command"),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args client.ExecRequest
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			return withClient(func(c *client.Client) (*ToolResult, error) {
+				res, err := c.Exec(ctx, args)
+				if err != nil {
+					return nil, err
+				}
+				if res.Error != "" {
+					result := textResult(res.Output + "\n[" + res.Error + "]")
+					result.IsError = true
+					return result, nil
+				}
+				if res.Cwd != "" {
+					return textResult("cwd: " + res.Cwd), nil
+				}
+				return textResult(res.Output), nil
+			})
+		},
+	},
+	{
+		Name:        "open_app",
+		Description: "Open a desktop app by id or name (e.g. \"shell\", \"Notepad\").",
+		Schema: schema(map[string][2]string{
+			"app": {"string", "App id or name"},
+		}, "app"),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args struct {
+				App string `json:"app"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			return bridgeCall(ctx, "openApp", args.App)
+		},
+	},
+	{
+		Name:        "list_windows",
+		Description: "List open desktop windows (id, title, minimized) and the active window id.",
+		Schema:      schema(nil),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			_, eye, err := connection()
+			if err != nil {
+				return errorResult(err), nil
+			}
+			result, err := eye.Eval(ctx, `(s => ({windows: s.windows, activeWindow: s.activeWindow}))(ALGO.bridge.getState().result)`)
+			if err != nil {
+				return errorResult(err), nil
+			}
+			return textResult(result), nil
+		},
+	},
+	{
+		Name:        "click",
+		Description: "Click the first element matching a CSS selector.",
+		Schema: schema(map[string][2]string{
+			"selector": {"string", "CSS selector"},
+		}, "selector"),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args struct {
+				Selector string `json:"selector"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			return bridgeCall(ctx, "click", args.Selector)
+		},
+	},
+	{
+		Name:        "type_text",
+		Description: "Set the value of an input or textarea matching a CSS selector, firing input and change events.",
+		Schema: schema(map[string][2]string{
+			"selector": {"string", "CSS selector"},
+			"text":     {"string", "Text to enter"},
+		}, "selector", "text"),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args struct {
+				Selector string `json:"selector"`
+				Text     string `json:"text"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			return bridgeCall(ctx, "setValue", args.Selector, args.Text)
+		},
+	},
+	{
+		Name:        "screenshot",
+		Description: "Take a PNG screenshot of the desktop or one window. Also saved under ~/.algo/captures.",
+		Schema: schema(map[string][2]string{
+			"window": {"number", "Window id from list_windows (default: whole desktop)"},
+		}),
+		Run: func(ctx context.Context, raw json.RawMessage) (*ToolResult, error) {
+			var args struct {
+				Window *int `json:"window"`
+			}
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return nil, err
+			}
+			return withClient(func(c *client.Client) (*ToolResult, error) {
+				toolArgs := map[string]interface{}{}
+				if args.Window != nil {
+					toolArgs["windowId"] = *args.Window
+				}
+				content, err := c.CallTool(ctx, "algo_screenshot", toolArgs, "")
+				if err != nil {
+					return nil, err
+				}
+				return &ToolResult{Content: content}, nil
+			})
+		},
+	},
+}
+
+// missingArgs returns the first required argument that is absent, if any
+func (t *Tool) missingArgs(args json.RawMessage) string {
+	required, _ := t.Schema["required"].([]string)
+	var present map[string]json.RawMessage
+	json.Unmarshal(args, &present)
+	for _, name := range required {
+		if _, ok := present[name]; !ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func findTool(name string) *Tool {
+	for i := range tools {
+		if tools[i].Name == name {
+			return &tools[i]
+		}
+	}
+	return nil
+}
+
+// withClient runs an HTTP-backed tool; API errors become tool errors
+func withClient(run func(c *client.Client) (*ToolResult, error)) (*ToolResult, error) {
+	c, _, err := connection()
+	if err != nil {
+		return errorResult(err), nil
+	}
+	result, err := run(c)
+	if err != nil {
+		return errorResult(err), nil
+	}
+	return result, nil
+}
+
+// bridgeCall runs ALGO.bridge.<fn>(args...) in the browser. Bridge functions
+// return {success, error, ...}; a false success becomes a tool error.
+func bridgeCall(ctx context.Context, fn string, args ...interface{}) (*ToolResult, error) {
+	_, eye, err := connection()
+	if err != nil {
+		return errorResult(err), nil
+	}
+	encoded := make([]string, len(args))
+	for i, arg := range args {
+		data, _ := json.Marshal(arg)
+		encoded[i] = string(data)
+	}
+	result, err := eye.Eval(ctx, "ALGO.bridge."+fn+"("+strings.Join(encoded, ", ")+")")
+	if err != nil {
+		return errorResult(err), nil
+	}
+
+	var status struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal([]byte(result), &status) == nil && !status.Success {
+		if status.Error == "" {
+			status.Error = fn + " failed"
+		}
+		return errorResult(fmt.Errorf("%s", status.Error)), nil
+	}
+	return textResult(result), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cecilia/client"
+)
+
+// batchOptions controls script mode
+type batchOptions struct {
+	JSON     bool // one JSON object per result instead of plain text
+	Continue bool // keep going after an error
+	Window   int  // expressions in flight at once
+}
+
+// batchItem is one expression from a script
+type batchItem struct {
+	Line int
+	Expr string
+}
+
+// batchResult is what --json prints for each expression
+type batchResult struct {
+	Index  int             `json:"index"`
+	Line   int             `json:"line,omitempty"`
+	ID     string          `json:"id"`
+	Expr   string          `json:"expr"`
+	OK     bool            `json:"ok"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	MS     float64         `json:"ms"`
+}
+
+// parseScript splits a script into expressions: one per line, a trailing
+// backslash continues onto the next line, and // or # comment lines are skipped
+func parseScript(r io.Reader) ([]batchItem, error) {
+	var items []batchItem
+	var cur strings.Builder
+	start := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if cur.Len() == 0 && (trimmed == "" || strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "#")) {
+			continue
+		}
+		if cur.Len() == 0 {
+			start = n
+		}
+		if strings.HasSuffix(line, "\\") {
+			cur.WriteString(strings.TrimSuffix(line, "\\"))
+			cur.WriteByte('\n')
+			continue
+		}
+		cur.WriteString(line)
+		items = append(items, batchItem{Line: start, Expr: cur.String()})
+		cur.Reset()
+	}
+	if cur.Len() > 0 {
+		items = append(items, batchItem{Line: start, Expr: cur.String()})
+	}
+	return items, scanner.Err()
+}
+
+// runBatch pipelines a script's expressions with generated IDs, prints the
+// results in script order and returns the process exit code
+func runBatch(eye *client.Eye, r io.Reader, opts batchOptions) int {
+	items, err := parseScript(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if opts.Window < 1 {
+		opts.Window = 1
+	}
+
+	type outcome struct {
+		result  string
+		err     error
+		elapsed time.Duration
+		skipped bool
+	}
+	id := func(i int) string { return fmt.Sprintf("b%d", i+1) }
+	results := make([]chan outcome, len(items))
+	for i := range results {
+		results[i] = make(chan outcome, 1)
+	}
+
+	// Keep up to Window expressions in flight; after an error, skip the rest
+	// unless --continue
+	var failed atomic.Bool
+	go func() {
+		slots := make(chan struct{}, opts.Window)
+		for i := range items {
+			slots <- struct{}{}
+			if failed.Load() && !opts.Continue {
+				results[i] <- outcome{skipped: true}
+				<-slots
+				continue
+			}
+			go func(i int) {
+				defer func() { <-slots }()
+				start := time.Now()
+				result, err := eye.EvalID(context.Background(), id(i), items[i].Expr)
+				if err != nil {
+					failed.Store(true)
+				}
+				results[i] <- outcome{result: result, err: err, elapsed: time.Since(start)}
+			}(i)
+		}
+	}()
+
+	exit := 0
+	for i := range items {
+		out := <-results[i]
+		if out.skipped {
+			break
+		}
+		isErr, result := evalOutcome(out.result, out.err)
+		if out.err != nil && !isErr {
+			fmt.Fprintf(os.Stderr, "Read failed: %v\n", out.err)
+			return 1
+		}
+
+		res := batchResult{Index: i + 1, Line: items[i].Line, ID: id(i), Expr: items[i].Expr}
+		if opts.JSON {
+			printJSONResult(res, result, isErr, out.elapsed)
+		} else if isErr {
+			fmt.Fprintf(os.Stderr, "Error (line %d): %s\n", items[i].Line, result)
+		} else {
+			fmt.Println(result)
+		}
+		if isErr {
+			exit = 1
+			if !opts.Continue {
+				break
+			}
+		}
+	}
+	return exit
+}
+
+// printJSONResult prints one --json line; results that are valid JSON are embedded as-is
+func printJSONResult(res batchResult, result string, isErr bool, elapsed time.Duration) {
+	res.MS = float64(elapsed.Microseconds()) / 1000
+	if isErr {
+		res.Error = result
+	} else {
+		res.OK = true
+		if json.Valid([]byte(result)) {
+			res.Result = json.RawMessage(result)
+		} else {
+			res.Result, _ = json.Marshal(result)
+		}
+	}
+	data, _ := json.Marshal(res)
+	fmt.Println(string(data))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"cecilia/client"
+)
+
+const loginUsage = `usage: eye login [--profile name] [--server url] [--user name] [--ca file] [--insecure]
+
+Logs in with your desktop username and password and saves the token to
+~/.algo/config.json (mode 0600). With --profile the login is stored as a
+named profile and made current; switch later with EYE_PROFILE or --profile.
+
+  --server url   Server to log in to (https://host, host:port, ...)
+  --user name    Username (prompted for if omitted)
+  --ca file      PEM bundle to trust for this server's certificate
+  --insecure     Skip TLS verification (self-signed dev servers only)
+
+The password is read from the terminal, or from EYE_PASSWORD.
+
+  eye login --profiles   List saved profiles`
+
+// login runs `eye login` and returns the process exit code
+func login(args []string) int {
+	flags := flag.NewFlagSet("eye login", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, loginUsage) }
+	profileName := flags.String("profile", os.Getenv("EYE_PROFILE"), "")
+	server := flags.String("server", "", "")
+	username := flags.String("user", "", "")
+	ca := flags.String("ca", "", "")
+	insecure := flags.Bool("insecure", false, "")
+	list := flags.Bool("profiles", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *list {
+		names, current, err := client.ListProfiles()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		for _, name := range names {
+			mark := " "
+			if name == current {
+				mark = "*"
+			}
+			p, _ := client.LoadStoredProfile(name)
+			fmt.Printf("%s %-12s %s %s\n", mark, name, p.Server, p.Username)
+		}
+		return 0
+	}
+
+	// Start from the saved profile so a re-login keeps its server and CA;
+	// EYE_SERVER and friends are one-off overrides and are not saved
+	p, err := client.LoadStoredProfile(*profileName)
+	if err != nil {
+		p = &client.Profile{}
+	}
+	if p.Server == "" {
+		p.Server = client.DefaultServer
+	}
+	if *server != "" {
+		p.Server = *server
+	}
+	if *ca != "" {
+		p.CA = *ca
+	}
+	if *insecure {
+		p.Insecure = true
+	}
+	base, err := client.BaseURL(p.Server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	p.Server = base
+
+	in := bufio.NewReader(os.Stdin)
+	if *username == "" {
+		*username = p.Username
+	}
+	if *username == "" {
+		fmt.Fprintf(os.Stderr, "Username for %s: ", base)
+		line, _ := in.ReadString('\n')
+		*username = strings.TrimSpace(line)
+	}
+	password := os.Getenv("EYE_PASSWORD")
+	if password == "" {
+		password, err = readPassword(in, fmt.Sprintf("Password for %s@%s: ", *username, base))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	c, err := client.New(p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := c.Login(context.Background(), *username, password); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := client.SaveProfile(*profileName, p); err != nil {
+		fmt.Fprintf(os.Stderr, "Saving token: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Logged in to %s as %s (saved to %s)\n", base, *username, client.ConfigPath())
+	return 0
+}
+
+// readPassword reads a line from the terminal with echo turned off
+func readPassword(in *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if isTerminal(os.Stdin) {
+		stty := func(arg string) {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = os.Stdin
+			cmd.Run()
+		}
+		stty("-echo")
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("no password given")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
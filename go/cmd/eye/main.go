@@ -2,140 +2,231 @@ package main
 
 import (
 	"bufio"
-	"crypto/tls"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"cecilia/client"
 )
 
-func main() {
-	if len(os.Args) > 1 && (os.Args[1] == "-h" || os.Args[1] == "--help") {
-		fmt.Println(`eye - Fast browser JS VM bridge
+const usage = `eye - Fast browser JS VM bridge
 
 USAGE:
-  eye [expression]    Execute JS (use id: prefix for response)
-  eye                 Interactive REPL mode
+  eye [flags] [expression]   Execute JS (use id: prefix for response)
+  eye                        Interactive REPL mode
+  eye -f script.js           Run a script, one expression per line
+  cat script.js | eye        Same, reading the script from stdin
+  eye replay <file>          Re-run a recording and check the replies (see eye replay -h)
+  eye login                  Log in and save a token (see eye login -h)
+
+FLAGS:
+  -f file        Script to run (one expression per line; end a line with \
+                 to continue it on the next; // and # lines are skipped)
+  --json         Print one JSON object per result: ok, result, error, ms
+  --timeout d    Give up on each response after d (e.g. 10s)
+  --continue     Keep going after an error (default: stop at the first)
+  --window n     Expressions in flight at once in scripts (default 1, or 8
+                 with --continue); above 1 later expressions may already
+                 have run when one fails
+  --profile name Server profile from ~/.algo/config.json (default: current)
+  --insecure     Skip TLS certificate verification
+
+ENVIRONMENT:
+  EYE_PROFILE         Default for --profile
+  EYE_SERVER, EYE_TOKEN, EYE_CA
+                      Override the profile's server, token and CA bundle
+  EYE_SESSION         Browser connection to target (ID from /api/eye/sessions,
+                      or "all"); defaults to the focused tab. With "all" each
+                      command runs in every tab and prints the first reply
+  EYE_TIMEOUT         Default for --timeout; the server's limit applies when
+                      unset or higher
+  EYE_RECORD=1        Record this session to ~/.algo/eye-recordings
 
 EXAMPLES:
   eye 'a:document.title'
-  eye 'a:ALGO.bridge.getState()'`)
+  eye 'a:ALGO.bridge.getState()'
+  eye --json -f smoke.js`
+
+// timeout is how long to wait for each response (--timeout / EYE_TIMEOUT);
+// timeoutSet means the user chose it, so the server is asked to honor it
+var (
+	timeout    time.Duration
+	timeoutSet bool
+)
+
+func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "-h" || os.Args[1] == "--help") {
+		fmt.Println(usage)
 		return
 	}
 
-	token, server := loadConfig()
-	if token == "" {
-		fmt.Fprintln(os.Stderr, "No token. Set EYE_TOKEN or create ~/.algo/config.json")
+	flags := flag.NewFlagSet("eye", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	script := flags.String("f", "", "")
+	jsonOut := flags.Bool("json", false, "")
+	keepGoing := flags.Bool("continue", false, "")
+	window := flags.Int("window", 0, "")
+	profileName := flags.String("profile", "", "")
+	insecure := flags.Bool("insecure", false, "")
+	flags.DurationVar(&timeout, "timeout", envTimeout(), "")
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if flags.Arg(0) == "login" {
+		os.Exit(login(flags.Args()[1:]))
+	}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "timeout" {
+			timeoutSet = true
+		}
+	})
+	if os.Getenv("EYE_TIMEOUT") != "" {
+		timeoutSet = true
+	}
+
+	profile, err := client.LoadProfile(*profileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if *insecure {
+		profile.Insecure = true
+	}
+	if profile.Token == "" {
+		fmt.Fprintln(os.Stderr, "No token. Run eye login (or set EYE_TOKEN)")
+		os.Exit(1)
+	}
+
+	c, err := client.New(profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+	opts := client.EyeOptions{
+		Session: os.Getenv("EYE_SESSION"),
+		Record:  os.Getenv("EYE_RECORD") == "1",
+	}
+	if timeoutSet {
+		opts.Timeout = timeout
+	}
+	replMode := flags.NArg() == 0 && *script == "" && isTerminal(os.Stdin)
+	if replMode {
+		// The REPL prints every line from the server as it arrives
+		opts.OnMessage = func(msg string) { fmt.Printf("< %s\n", msg) }
+	}
 
-	conn, err := connectWebSocket(token, server)
+	ctx := context.Background()
+	eye, err := c.DialEye(ctx, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Connect failed: %v\n", err)
 		os.Exit(1)
 	}
-	defer conn.Close()
+	defer eye.Close()
 
-	// Wait for ready
-	_, msg, err := conn.ReadMessage()
-	if err != nil || string(msg) != ":ready" {
-		fmt.Fprintf(os.Stderr, "Handshake failed\n")
-		os.Exit(1)
+	// Replay mode
+	if flags.Arg(0) == "replay" {
+		os.Exit(replay(eye, flags.Args()[1:]))
+	}
+
+	// Without --continue, run one at a time so nothing after a failing
+	// expression reaches the browser
+	if *window < 1 {
+		*window = 1
+		if *keepGoing {
+			*window = 8
+		}
+	}
+
+	// Script mode: -f file, or a script piped to stdin
+	batch := batchOptions{JSON: *jsonOut, Continue: *keepGoing, Window: *window}
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		os.Exit(runBatch(eye, f, batch))
+	}
+	if !replMode && flags.NArg() == 0 {
+		os.Exit(runBatch(eye, os.Stdin, batch))
 	}
 
 	// Single command mode
-	if len(os.Args) > 1 {
-		expr := strings.Join(os.Args[1:], " ")
-		conn.WriteMessage(websocket.TextMessage, []byte(expr))
-
-		if hasIDPrefix(expr) {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Read failed: %v\n", err)
+	if flags.NArg() > 0 {
+		expr := strings.Join(flags.Args(), " ")
+		if *jsonOut && !hasIDPrefix(expr) {
+			expr = "a:" + expr // --json implies waiting for the result
+		}
+		if !hasIDPrefix(expr) {
+			if err := eye.Send(ctx, expr); err != nil {
+				fmt.Fprintf(os.Stderr, "Write failed: %v\n", err)
 				os.Exit(1)
 			}
-			result := string(msg)
-			if colonIdx := strings.Index(result, ":"); colonIdx > 0 {
-				prefix := result[:colonIdx]
-				result = result[colonIdx+1:]
-				if strings.HasSuffix(prefix, "!") {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", result)
-					os.Exit(1)
-				}
-			}
+			return
+		}
+
+		id := expr[:strings.Index(expr, ":")]
+		start := time.Now()
+		result, err := eye.EvalID(ctx, id, expr[len(id)+1:])
+		isErr, result := evalOutcome(result, err)
+		if err != nil && !isErr {
+			fmt.Fprintf(os.Stderr, "Read failed: %v\n", err)
+			os.Exit(1)
+		}
+		if *jsonOut {
+			printJSONResult(batchResult{Index: 1, Expr: expr[len(id)+1:], ID: id}, result, isErr, time.Since(start))
+		} else if isErr {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", result)
+		} else {
 			fmt.Println(result)
 		}
+		if isErr {
+			os.Exit(1)
+		}
 		return
 	}
 
 	// REPL mode
 	fmt.Println("eye connected (Ctrl+D to exit)")
-	go func() {
-		for {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				return
-			}
-			fmt.Printf("< %s\n", string(msg))
-		}
-	}()
-
 	scanner := bufio.NewScanner(os.Stdin)
 	fmt.Print("> ")
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line != "" {
-			conn.WriteMessage(websocket.TextMessage, []byte(line))
+			if err := eye.Raw(ctx, line); err != nil {
+				fmt.Printf("! %v\n", err)
+			}
 		}
 		fmt.Print("> ")
 	}
 }
 
-func loadConfig() (token, server string) {
-	token = os.Getenv("EYE_TOKEN")
-	server = os.Getenv("EYE_SERVER")
-
-	if token == "" || server == "" {
-		if data, err := os.ReadFile(os.ExpandEnv("$HOME/.algo/config.json")); err == nil {
-			var config struct {
-				Token  string `json:"token"`
-				Server string `json:"server"`
-			}
-			if json.Unmarshal(data, &config) == nil {
-				if token == "" {
-					token = config.Token
-				}
-				if server == "" {
-					server = config.Server
-				}
-			}
-		}
+// evalOutcome splits an Eval error into a browser-side error (reported
+// like a result) and a transport failure (err stays non-nil)
+func evalOutcome(result string, err error) (bool, string) {
+	var evalErr *client.EvalError
+	if errors.As(err, &evalErr) {
+		return true, evalErr.Message
 	}
+	return false, result
+}
 
-	if server == "" {
-		server = "wss://localhost/api/eye"
-	}
-	if !strings.HasPrefix(server, "ws://") && !strings.HasPrefix(server, "wss://") {
-		server = strings.Replace(server, "https://", "wss://", 1)
-		server = strings.Replace(server, "http://", "ws://", 1)
-		if !strings.HasPrefix(server, "ws") {
-			server = "wss://" + server
-		}
-		if !strings.Contains(server, "/api/eye") {
-			server = strings.TrimSuffix(server, "/") + "/api/eye"
-		}
+// envTimeout is EYE_TIMEOUT, or the server's default of 30s
+func envTimeout() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("EYE_TIMEOUT")); err == nil && d > 0 {
+		return d
 	}
-	return
+	return 30 * time.Second
 }
 
-func connectWebSocket(token, server string) (*websocket.Conn, error) {
-	url := server + "?token=" + token
-	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	conn, _, err := dialer.Dial(url, http.Header{})
-	return conn, err
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 func hasIDPrefix(expr string) bool {
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"cecilia/client"
+)
+
+// recordEvent is one line of an eye recording (see ~/.algo/eye-recordings).
+// A "recv" line may be edited to carry "pattern" (a regexp) instead of the
+// exact "result", or "ignore": true to skip the comparison.
+type recordEvent struct {
+	Type    string  `json:"type"`
+	T       float64 `json:"t"`
+	ID      string  `json:"id"`
+	Expr    string  `json:"expr"`
+	Result  *string `json:"result"`
+	Error   string  `json:"error"`
+	Pattern string  `json:"pattern"`
+	Ignore  bool    `json:"ignore"`
+}
+
+const replayUsage = `usage: eye replay [--timing] [--quiet] <file.jsonl>
+
+Re-runs an eye recording against the browser and checks every reply.
+A reply matches if it equals the recorded result (or error), or, when the
+recorded "recv" line has a "pattern", if it matches that regexp.
+
+  --timing   keep the recorded delays between expressions
+  --quiet    only report failures`
+
+// replay runs a recording and returns the process exit code
+func replay(eye *client.Eye, args []string) int {
+	var path string
+	timing, quiet := false, false
+	for _, arg := range args {
+		switch arg {
+		case "--timing":
+			timing = true
+		case "--quiet", "-q":
+			quiet = true
+		case "-h", "--help":
+			fmt.Println(replayUsage)
+			return 0
+		default:
+			path = arg
+		}
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, replayUsage)
+		return 2
+	}
+
+	events, err := loadRecording(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 2
+	}
+
+	ctx := context.Background()
+	passed, failed := 0, 0
+	consumed := make([]bool, len(events))
+	var lastT float64
+	for i, ev := range events {
+		if ev.Type != "send" {
+			continue
+		}
+		if timing && ev.T > lastT {
+			time.Sleep(time.Duration((ev.T - lastT) * float64(time.Second)))
+		}
+		lastT = ev.T
+
+		if ev.ID == "" {
+			eye.Send(ctx, ev.Expr)
+			continue
+		}
+
+		// The recorded reply is the next unconsumed recv with the same ID
+		var want *recordEvent
+		for j := i + 1; j < len(events); j++ {
+			if !consumed[j] && events[j].Type == "recv" && events[j].ID == ev.ID {
+				consumed[j] = true
+				want = &events[j]
+				break
+			}
+		}
+
+		result, err := eye.EvalID(ctx, ev.ID, ev.Expr)
+		isErr, result := evalOutcome(result, err)
+		if err != nil && !isErr {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 1
+		}
+
+		if ok, why := replyMatches(want, result, isErr); ok {
+			passed++
+			if !quiet {
+				fmt.Printf("ok    %s  %s\n", ev.ID, summarize(ev.Expr))
+			}
+		} else {
+			failed++
+			fmt.Printf("FAIL  %s  %s\n      %s\n", ev.ID, summarize(ev.Expr), why)
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func loadRecording(path string) ([]recordEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []recordEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var ev recordEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		events = append(events, ev)
+	}
+	return events, scanner.Err()
+}
+
+// replyMatches compares a live reply with the recorded one
+func replyMatches(want *recordEvent, got string, isErr bool) (bool, string) {
+	if want == nil || want.Ignore {
+		return true, ""
+	}
+	if want.Pattern != "" {
+		re, err := regexp.Compile(want.Pattern)
+		if err != nil {
+			return false, "bad pattern: " + err.Error()
+		}
+		if !re.MatchString(got) {
+			return false, fmt.Sprintf("%q does not match /%s/", summarize(got), want.Pattern)
+		}
+		return true, ""
+	}
+	if want.Error != "" {
+		if !isErr {
+			return false, fmt.Sprintf("expected error %q, got %s", summarize(want.Error), summarize(got))
+		}
+		if got != want.Error {
+			return false, fmt.Sprintf("expected error %q, got error %q", summarize(want.Error), summarize(got))
+		}
+		return true, ""
+	}
+	if isErr {
+		return false, fmt.Sprintf("unexpected error: %s", summarize(got))
+	}
+	if want.Result != nil && got != *want.Result {
+		return false, fmt.Sprintf("expected %s, got %s", summarize(*want.Result), summarize(got))
+	}
+	return true, ""
+}
+
+func summarize(s string) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if r := []rune(s); len(r) > 80 {
+		return string(r[:77]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,665 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Content bridge - connects the browser extension and Clean View instances
+//
+// Both sides authenticate with ?token=, and everything stays within one
+// user: a desktop's requests only reach that user's extensions, and
+// responses only go back to the desktop that asked. A user may run the
+// extension on several machines at once; requests go to the most recently
+// connected one unless they name an "extension".
+//
+// The messages are described in extension/PROTOCOL.md. In short, each
+// extension reports its tabs with hello/tabs/tabUpdated/tabRemoved/
+// tabActivated events and the server keeps a registry of them, which
+// desktops get as "state" events and REST clients from
+// /api/content-bridge/tabs. Requests are typed actions checked against
+// contentActions before they reach an extension; the same path serves
+// desktops, POST /api/content-bridge/call and the algo_tab* MCP tools.
+//
+// The extension sees a server-unique request ID and the response is mapped
+// back, as with eye requests. A request waits at most contentRequestTimeout,
+// and ends early if either side disconnects.
+
+const (
+	contentBridgeVersion  = 1
+	contentRequestTimeout = 60 * time.Second
+)
+
+var (
+	errNoExtension      = errors.New("Extension not connected")
+	errExtensionGone    = errors.New("Extension disconnected")
+	errContentTimeout   = errors.New("Request timeout")
+	errExtensionVersion = errors.New("Extension is out of date, reload it from chrome://extensions")
+)
+
+// contentTab is one tab in an extension's registry
+type contentTab struct {
+	ID       int    `json:"id"`
+	WindowID int    `json:"windowId"`
+	URL      string `json:"url"`
+	Title    string `json:"title"`
+	Active   bool   `json:"active"`
+	Shadow   bool   `json:"shadow,omitempty"` // opened by the bridge with openTab
+}
+
+// contentExtension is one connected browser extension
+type contentExtension struct {
+	ID       string
+	Username string
+	Opened   time.Time
+	out      *wsWriter
+	gone     chan struct{} // closed on disconnect
+
+	mu        sync.Mutex
+	version   int // from hello; 0 until then
+	tabs      []contentTab
+	responses map[string]chan contentResponse // server request ID -> caller
+}
+
+// contentPeer is one connected desktop (Clean View, Shadow Bridge)
+type contentPeer struct {
+	Username string
+	out      *wsWriter
+}
+
+// contentResponse is an extension's answer to a request
+type contentResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+var (
+	contentExtensions   = make(map[string][]*contentExtension) // username -> extensions, oldest first
+	contentPeers        = make(map[string][]*contentPeer)      // username -> desktops
+	contentConnsMu      sync.RWMutex
+	contentRequestCount atomic.Uint64
+)
+
+// Actions
+
+// contentParams are an action's parameters. check validates them against
+// the extension that will run the action.
+type contentParams interface {
+	check(ext *contentExtension) error
+}
+
+// tabParams name a tab
+type tabParams struct {
+	TabID int `json:"tabId"`
+}
+
+func (p *tabParams) check(ext *contentExtension) error {
+	_, err := ext.tab(p.TabID, false)
+	return err
+}
+
+// shadowTabParams name a tab the bridge opened; actions that change a tab
+// leave the user's own tabs alone
+type shadowTabParams struct {
+	TabID int `json:"tabId"`
+}
+
+func (p *shadowTabParams) check(ext *contentExtension) error {
+	_, err := ext.tab(p.TabID, true)
+	return err
+}
+
+// screenshotParams name a tab, or none for the active tab of the focused window
+type screenshotParams struct {
+	TabID int `json:"tabId,omitempty"`
+}
+
+func (p *screenshotParams) check(ext *contentExtension) error {
+	if p.TabID == 0 {
+		return nil
+	}
+	_, err := ext.tab(p.TabID, false)
+	return err
+}
+
+type openTabParams struct {
+	URL      string `json:"url"`
+	ShadowID string `json:"shadowId,omitempty"`
+}
+
+func (p *openTabParams) check(ext *contentExtension) error {
+	return checkTabURL(p.URL)
+}
+
+type navigateParams struct {
+	TabID int    `json:"tabId"`
+	URL   string `json:"url"`
+}
+
+func (p *navigateParams) check(ext *contentExtension) error {
+	if _, err := ext.tab(p.TabID, true); err != nil {
+		return err
+	}
+	return checkTabURL(p.URL)
+}
+
+type queryParams struct {
+	TabID    int    `json:"tabId"`
+	Selector string `json:"selector"`
+	All      bool   `json:"all,omitempty"`
+}
+
+func (p *queryParams) check(ext *contentExtension) error {
+	if _, err := ext.tab(p.TabID, false); err != nil {
+		return err
+	}
+	return checkSelector(p.Selector)
+}
+
+type clickParams struct {
+	TabID    int    `json:"tabId"`
+	Selector string `json:"selector"`
+}
+
+func (p *clickParams) check(ext *contentExtension) error {
+	if _, err := ext.tab(p.TabID, true); err != nil {
+		return err
+	}
+	return checkSelector(p.Selector)
+}
+
+func checkTabURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http(s) URL")
+	}
+	return nil
+}
+
+func checkSelector(selector string) error {
+	if strings.TrimSpace(selector) == "" || len(selector) > 1000 {
+		return fmt.Errorf("selector required (at most 1000 characters)")
+	}
+	return nil
+}
+
+// contentActions are the actions extensions run, with their parameters.
+// listTabs and ping are answered by the server.
+var contentActions = map[string]func() contentParams{
+	"getContent": func() contentParams { return &tabParams{} },
+	"extract":    func() contentParams { return &tabParams{} },
+	"screenshot": func() contentParams { return &screenshotParams{} },
+	"query":      func() contentParams { return &queryParams{} },
+	"openTab":    func() contentParams { return &openTabParams{} },
+	"closeTab":   func() contentParams { return &shadowTabParams{} },
+	"navigate":   func() contentParams { return &navigateParams{} },
+	"click":      func() contentParams { return &clickParams{} },
+}
+
+// contentParamError is a request that doesn't match the schema
+type contentParamError struct{ msg string }
+
+func (e *contentParamError) Error() string { return e.msg }
+
+// parseContentParams decodes and checks an action's parameters
+func parseContentParams(ext *contentExtension, action string, raw json.RawMessage) (contentParams, error) {
+	newParams, ok := contentActions[action]
+	if !ok {
+		return nil, &contentParamError{"unknown action: " + action}
+	}
+	params := newParams()
+	if len(raw) > 0 && string(raw) != "null" {
+		dec := json.NewDecoder(strings.NewReader(string(raw)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(params); err != nil {
+			return nil, &contentParamError{fmt.Sprintf("%s: invalid params: %v", action, err)}
+		}
+	}
+	if err := params.check(ext); err != nil {
+		return nil, &contentParamError{fmt.Sprintf("%s: %v", action, err)}
+	}
+	return params, nil
+}
+
+// Registry
+
+// tab finds a registered tab; with shadow set it must be one the bridge opened
+func (ext *contentExtension) tab(id int, shadow bool) (contentTab, error) {
+	ext.mu.Lock()
+	defer ext.mu.Unlock()
+	for _, t := range ext.tabs {
+		if t.ID == id {
+			if shadow && !t.Shadow {
+				return t, fmt.Errorf("tab %d was not opened by the bridge", id)
+			}
+			return t, nil
+		}
+	}
+	return contentTab{}, fmt.Errorf("unknown tab %d", id)
+}
+
+// applyEvent updates the registry from an extension event, reporting whether
+// anything changed
+func (ext *contentExtension) applyEvent(ev *contentEvent) bool {
+	ext.mu.Lock()
+	defer ext.mu.Unlock()
+	switch ev.Event {
+	case "hello":
+		ext.version = ev.Version
+		ext.tabs = ev.Tabs
+	case "tabs":
+		ext.tabs = ev.Tabs
+	case "tabUpdated":
+		if ev.Tab == nil {
+			return false
+		}
+		found := false
+		for i := range ext.tabs {
+			if ext.tabs[i].ID == ev.Tab.ID {
+				ext.tabs[i] = *ev.Tab
+				found = true
+			} else if ev.Tab.Active && ext.tabs[i].WindowID == ev.Tab.WindowID {
+				ext.tabs[i].Active = false
+			}
+		}
+		if !found {
+			ext.tabs = append(ext.tabs, *ev.Tab)
+		}
+	case "tabRemoved":
+		for i := range ext.tabs {
+			if ext.tabs[i].ID == ev.TabID {
+				ext.tabs = append(ext.tabs[:i:i], ext.tabs[i+1:]...)
+				break
+			}
+		}
+	case "tabActivated":
+		for i := range ext.tabs {
+			if ext.tabs[i].WindowID == ev.WindowID {
+				ext.tabs[i].Active = ext.tabs[i].ID == ev.TabID
+			}
+		}
+	default:
+		return false
+	}
+	if ext.tabs == nil {
+		ext.tabs = []contentTab{}
+	}
+	return true
+}
+
+// contentExtensionInfo is an extension and its tabs, as desktops and REST see it
+type contentExtensionInfo struct {
+	ID      string       `json:"id"`
+	Opened  int64        `json:"opened"`
+	Version int          `json:"version"`
+	Tabs    []contentTab `json:"tabs"`
+}
+
+// contentState lists the user's extensions, newest (the default) first
+func contentState(username string) map[string]interface{} {
+	contentConnsMu.RLock()
+	exts := append([]*contentExtension(nil), contentExtensions[username]...)
+	contentConnsMu.RUnlock()
+
+	infos := []contentExtensionInfo{}
+	for i := len(exts) - 1; i >= 0; i-- {
+		ext := exts[i]
+		ext.mu.Lock()
+		infos = append(infos, contentExtensionInfo{
+			ID:      ext.ID,
+			Opened:  ext.Opened.Unix(),
+			Version: ext.version,
+			Tabs:    append([]contentTab{}, ext.tabs...),
+		})
+		ext.mu.Unlock()
+	}
+	return map[string]interface{}{"event": "state", "version": contentBridgeVersion, "extensions": infos}
+}
+
+// broadcastContentState sends the user's desktops the current state
+func broadcastContentState(username string) {
+	msg, _ := json.Marshal(contentState(username))
+	contentConnsMu.RLock()
+	peers := append([]*contentPeer(nil), contentPeers[username]...)
+	contentConnsMu.RUnlock()
+	for _, peer := range peers {
+		peer.out.Text(msg)
+	}
+}
+
+// findContentExtension picks the user's newest extension, or the one with the given ID
+func findContentExtension(username, id string) *contentExtension {
+	contentConnsMu.RLock()
+	defer contentConnsMu.RUnlock()
+	exts := contentExtensions[username]
+	for i := len(exts) - 1; i >= 0; i-- {
+		if id == "" || exts[i].ID == id {
+			return exts[i]
+		}
+	}
+	return nil
+}
+
+// Requests
+
+// contentCall runs an action on one of the user's extensions and waits for
+// the result. Parameters are checked first; a contentParamError means the
+// request never left the server.
+func contentCall(ctx context.Context, username, extID, action string, raw json.RawMessage) (json.RawMessage, error) {
+	ext := findContentExtension(username, extID)
+	if ext == nil {
+		if extID != "" {
+			return nil, fmt.Errorf("%w: no extension %s", errNoExtension, extID)
+		}
+		return nil, errNoExtension
+	}
+
+	if action == "listTabs" {
+		ext.mu.Lock()
+		tabs, _ := json.Marshal(append([]contentTab{}, ext.tabs...))
+		ext.mu.Unlock()
+		return tabs, nil
+	}
+
+	params, err := parseContentParams(ext, action, raw)
+	if err != nil {
+		return nil, err
+	}
+	ext.mu.Lock()
+	version := ext.version
+	ext.mu.Unlock()
+	if version != contentBridgeVersion {
+		return nil, errExtensionVersion
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, contentRequestTimeout)
+		defer cancel()
+	}
+
+	reqID := fmt.Sprintf("c%d", contentRequestCount.Add(1))
+	respChan := make(chan contentResponse, 1)
+	ext.mu.Lock()
+	ext.responses[reqID] = respChan
+	ext.mu.Unlock()
+	defer func() {
+		ext.mu.Lock()
+		delete(ext.responses, reqID)
+		ext.mu.Unlock()
+	}()
+
+	msg, _ := json.Marshal(map[string]interface{}{"id": reqID, "action": action, "params": params})
+	if err := ext.out.Send(ctx, websocket.TextMessage, msg); err == errWriterClosed {
+		return nil, errExtensionGone
+	}
+
+	select {
+	case resp := <-respChan:
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		if len(resp.Result) == 0 {
+			return json.RawMessage("null"), nil
+		}
+		return resp.Result, nil
+	case <-ext.gone:
+		return nil, errExtensionGone
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errContentTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// contentEvent is an event from an extension
+type contentEvent struct {
+	Event    string       `json:"event"`
+	Version  int          `json:"version"`
+	Tabs     []contentTab `json:"tabs"`
+	Tab      *contentTab  `json:"tab"`
+	TabID    int          `json:"tabId"`
+	WindowID int          `json:"windowId"`
+}
+
+// contentRequest is a request from a desktop
+type contentRequest struct {
+	ID        string          `json:"id"`
+	Action    string          `json:"action"`
+	Params    json.RawMessage `json:"params"`
+	Extension string          `json:"extension"`
+}
+
+// Handle content bridge WebSocket - serves both extension and browsers
+func handleContentBridge(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Token required", 401)
+		return
+	}
+	username := verifyToken(token)
+	if username == "" {
+		http.Error(w, "Invalid token", 401)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Check if this is the extension (query param) or a browser
+	if r.URL.Query().Get("ext") == "1" {
+		serveContentExtension(username, conn)
+	} else {
+		serveContentPeer(username, conn)
+	}
+}
+
+func serveContentExtension(username string, conn *websocket.Conn) {
+	ext := &contentExtension{
+		ID:        randomHex(4),
+		Username:  username,
+		Opened:    time.Now(),
+		out:       newWSWriter(conn),
+		gone:      make(chan struct{}),
+		tabs:      []contentTab{},
+		responses: make(map[string]chan contentResponse),
+	}
+	contentConnsMu.Lock()
+	contentExtensions[username] = append(contentExtensions[username], ext)
+	contentConnsMu.Unlock()
+
+	defer func() {
+		contentConnsMu.Lock()
+		exts := contentExtensions[username]
+		for i, e := range exts {
+			if e == ext {
+				exts = append(exts[:i:i], exts[i+1:]...)
+				break
+			}
+		}
+		if len(exts) == 0 {
+			delete(contentExtensions, username)
+		} else {
+			contentExtensions[username] = exts
+		}
+		contentConnsMu.Unlock()
+		close(ext.gone) // fails pending contentCall requests
+		ext.out.Close()
+		broadcastContentState(username)
+		fmt.Printf("[ContentBridge] Extension %s disconnected\n", ext.ID)
+	}()
+
+	fmt.Printf("[ContentBridge] Extension %s connected for %s\n", ext.ID, username)
+	broadcastContentState(username)
+
+	// Read messages from extension
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		var data struct {
+			ID string `json:"id"`
+			contentResponse
+			contentEvent
+		}
+		if err := json.Unmarshal(msg, &data); err != nil {
+			continue
+		}
+
+		// Response to a request
+		if data.ID != "" {
+			ext.mu.Lock()
+			respChan, ok := ext.responses[data.ID]
+			ext.mu.Unlock()
+			if ok {
+				select {
+				case respChan <- data.contentResponse:
+				default:
+				}
+			}
+			continue
+		}
+
+		if ext.applyEvent(&data.contentEvent) {
+			if data.Event == "hello" && data.Version != contentBridgeVersion {
+				fmt.Printf("[ContentBridge] Extension %s speaks v%d, expected v%d\n", ext.ID, data.Version, contentBridgeVersion)
+			}
+			broadcastContentState(username)
+		}
+	}
+}
+
+func serveContentPeer(username string, conn *websocket.Conn) {
+	// This is a browser (Clean View)
+	peer := &contentPeer{Username: username, out: newWSWriter(conn)}
+	contentConnsMu.Lock()
+	contentPeers[username] = append(contentPeers[username], peer)
+	contentConnsMu.Unlock()
+
+	// Requests in flight end when the desktop goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		contentConnsMu.Lock()
+		peers := contentPeers[username]
+		for i, p := range peers {
+			if p == peer {
+				peers = append(peers[:i:i], peers[i+1:]...)
+				break
+			}
+		}
+		if len(peers) == 0 {
+			delete(contentPeers, username)
+		} else {
+			contentPeers[username] = peers
+		}
+		contentConnsMu.Unlock()
+		peer.out.Close()
+	}()
+
+	state, _ := json.Marshal(contentState(username))
+	peer.out.Text(state)
+
+	// Read messages from browser and forward to extension
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		var req contentRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			continue
+		}
+
+		if req.Action == "ping" {
+			if req.ID != "" {
+				resp, _ := json.Marshal(map[string]string{"id": req.ID, "result": "pong"})
+				peer.out.Text(resp)
+			}
+			continue
+		}
+
+		go func(req contentRequest) {
+			result, err := contentCall(ctx, username, req.Extension, req.Action, req.Params)
+			if req.ID == "" || ctx.Err() != nil {
+				return // fire-and-forget, or the desktop is gone
+			}
+			resp := map[string]interface{}{"id": req.ID, "result": result}
+			if err != nil {
+				resp = map[string]interface{}{"id": req.ID, "error": err.Error()}
+			}
+			data, _ := json.Marshal(resp)
+			peer.out.Text(data)
+		}(req)
+	}
+}
+
+// handleContentTabs returns the caller's extensions and their tabs
+func handleContentTabs(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+	state := contentState(username)
+	delete(state, "event")
+	jsonResponse(w, state, 200)
+}
+
+// handleContentCall runs one action: {"action", "params", "extension"}
+func handleContentCall(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+	if r.Method != "POST" {
+		jsonResponse(w, map[string]string{"error": "Method not allowed"}, 405)
+		return
+	}
+
+	var req contentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Action == "" {
+		jsonResponse(w, map[string]string{"error": "action required"}, 400)
+		return
+	}
+
+	result, err := contentCall(r.Context(), username, req.Extension, req.Action, req.Params)
+	if err != nil {
+		status := 502
+		var paramErr *contentParamError
+		switch {
+		case errors.As(err, &paramErr):
+			status = 400
+		case errors.Is(err, errNoExtension):
+			status = 404
+		case errors.Is(err, errExtensionVersion):
+			status = 409
+		case errors.Is(err, errContentTimeout):
+			status = 504
+		}
+		jsonResponse(w, map[string]string{"error": err.Error()}, status)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{"result": result}, 200)
+}
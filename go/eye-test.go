@@ -3,45 +3,40 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
 	"log"
-	"net/http"
+	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"cecilia/client"
 )
 
+// Manual eye bridge check against a running server:
+//   go run eye-test.go
+// Uses the current profile from ~/.algo/config.json (see `eye login`).
 func main() {
-	// Token for root user
-	token := "eyJ1c2VybmFtZSI6ICJyb290IiwgImV4cCI6IDE3Njk4MDMyMjEsICJyYW5kIjogIjkwYzhhMmZkY2RhYTA0ZjUzYjFhMzQzNmI2NWU0Yjk5In0=.12267b97b508c5cf8631788ed29d924d37e103180512d9989f3380de484f0d39"
-
-	// Connect to eye bridge (use wss for HTTPS server)
-	url := "wss://localhost/api/eye?token=" + token
-	fmt.Printf("Connecting to %s\n", url)
-
-	// Skip TLS verification for localhost
-	dialer := websocket.Dialer{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	profile, err := client.LoadProfile("")
+	if err != nil {
+		log.Fatal("profile:", err)
 	}
-	conn, _, err := dialer.Dial(url, http.Header{})
+	c, err := client.New(profile)
 	if err != nil {
-		log.Fatal("dial:", err)
+		log.Fatal("client:", err)
 	}
-	defer conn.Close()
 
-	// Wait for ready message
-	_, msg, err := conn.ReadMessage()
+	ctx := context.Background()
+	fmt.Printf("Connecting to %s\n", profile.Server)
+	eye, err := c.DialEye(ctx, client.EyeOptions{})
 	if err != nil {
-		log.Fatal("read ready:", err)
+		log.Fatal("dial:", err)
 	}
-	fmt.Printf("Server: %s\n", string(msg))
+	defer eye.Close()
 
 	// Test 1: Fire and forget (no response expected)
 	fmt.Println("\n--- Test 1: Fire and forget ---")
 	start := time.Now()
-	err = conn.WriteMessage(websocket.TextMessage, []byte("console.log('eye test')"))
-	if err != nil {
+	if err := eye.Send(ctx, "console.log('eye test')"); err != nil {
 		log.Fatal("write:", err)
 	}
 	fmt.Printf("Fire sent in %v\n", time.Since(start))
@@ -49,44 +44,44 @@ func main() {
 	// Test 2: Request with ID
 	fmt.Println("\n--- Test 2: Request with ID ---")
 	start = time.Now()
-	err = conn.WriteMessage(websocket.TextMessage, []byte("a:document.title"))
+	title, err := eye.Eval(ctx, "document.title")
 	if err != nil {
-		log.Fatal("write:", err)
+		log.Fatal("eval:", err)
 	}
-
-	// Read response
-	_, msg, err = conn.ReadMessage()
-	if err != nil {
-		log.Fatal("read:", err)
-	}
-	elapsed := time.Since(start)
-	fmt.Printf("Response: %s (in %v)\n", string(msg), elapsed)
+	fmt.Printf("Response: %s (in %v)\n", title, time.Since(start))
 
 	// Test 3: Multiple pipelined requests
 	fmt.Println("\n--- Test 3: Pipelined requests ---")
 	start = time.Now()
 
-	// Send 10 requests rapidly
+	results := make([]string, 10)
+	var wg sync.WaitGroup
 	for i := 0; i < 10; i++ {
-		id := fmt.Sprintf("%d", i)
-		msg := fmt.Sprintf("%s:1+%d", id, i)
-		conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := eye.Eval(ctx, fmt.Sprintf("1+%d", i))
+			if err != nil {
+				result = "error: " + err.Error()
+			}
+			results[i] = result
+		}(i)
 	}
-	fmt.Printf("10 requests sent in %v\n", time.Since(start))
-
-	// Read 10 responses
-	for i := 0; i < 10; i++ {
-		_, msg, err = conn.ReadMessage()
-		if err != nil {
-			log.Fatal("read pipeline:", err)
-		}
-		fmt.Printf("  %s\n", string(msg))
+	wg.Wait()
+	for i, result := range results {
+		fmt.Printf("  %d: %s\n", i, result)
 	}
 	fmt.Printf("All 10 responses received in %v total\n", time.Since(start))
 
-	// Test 4: Compare with old MCP method
-	fmt.Println("\n--- Test 4: Old MCP HTTP method ---")
-	// (We'd need to implement this separately)
+	// Test 4: Browser sessions
+	fmt.Println("\n--- Test 4: Browser sessions ---")
+	sessions, def, err := c.EyeSessions(ctx)
+	if err != nil {
+		log.Fatal("sessions:", err)
+	}
+	for _, s := range sessions {
+		fmt.Printf("  %s %-6s page=%s focused=%v default=%v\n", s.ID, s.Kind, s.Page, s.Focused, s.ID == def)
+	}
 
 	fmt.Println("\nDone!")
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Screen capture through the eye bridge
+//
+// The server asks an eye-bridge socket for a capture:
+//   EYE_CAPTURE:{"id":"c1","kind":"screenshot","window":3}
+// kind is "screenshot" (PNG) or "snapshot" (JSON accessibility-style tree);
+// window is optional and defaults to the whole desktop. The browser streams
+// the result as binary frames, each "<id>\n<bytes>", and finishes with
+//   EYE_CAPTURE_END:{"id":"c1","mime":"image/png"}   or   {"id":"c1","error":"..."}
+// Results are saved under ~/.algo/captures and returned to the caller.
+
+const (
+	maxCaptureSize = 32 << 20
+	captureTimeout = 30 * time.Second
+)
+
+// captureResult is a finished capture
+type captureResult struct {
+	Kind string
+	Mime string
+	Data []byte
+	Path string // saved copy, as ~/.algo/captures/...
+}
+
+// pendingCapture collects chunks for one capture request
+type pendingCapture struct {
+	bc     *BrowserConnection
+	buf    bytes.Buffer
+	mime   string
+	failed bool
+	done   chan error
+}
+
+var (
+	pendingCaptures   = make(map[string]*pendingCapture) // capture ID -> request
+	pendingCapturesMu sync.Mutex
+	captureCounter    uint64
+)
+
+// captureBridgeConn picks the eye-bridge socket for a target. Only the
+// desktop page's bridge socket can render, so a Shell or Claude socket
+// target is mapped to the bridge socket of the same tab.
+func captureBridgeConn(username, target string) *BrowserConnection {
+	if target == "all" {
+		target = ""
+	}
+	targets := findBrowserConns(username, target, false)
+	if len(targets) == 0 {
+		return nil
+	}
+	chosen := targets[0]
+	if chosen.Kind == "bridge" {
+		return chosen
+	}
+	var fallback *BrowserConnection
+	for _, bc := range listBrowserConns(username) {
+		if bc.Kind != "bridge" {
+			continue
+		}
+		if chosen.Page != "" && bc.Page == chosen.Page {
+			return bc
+		}
+		fallback = bc
+	}
+	if target != "" {
+		return nil // asked for a specific tab that has no bridge socket
+	}
+	return fallback
+}
+
+// captureFromBrowser asks the browser for a screenshot or snapshot, saves it
+// to the user's home and returns it. windowID < 0 captures the whole desktop.
+func captureFromBrowser(username, target, kind string, windowID int) (*captureResult, error) {
+	if kind != "screenshot" && kind != "snapshot" {
+		return nil, fmt.Errorf("unknown capture kind: %s", kind)
+	}
+	bc := captureBridgeConn(username, target)
+	if bc == nil {
+		return nil, fmt.Errorf("no browser connected")
+	}
+
+	id := "c" + strconv.FormatUint(atomic.AddUint64(&captureCounter, 1), 10)
+	pc := &pendingCapture{bc: bc, done: make(chan error, 1)}
+	pendingCapturesMu.Lock()
+	pendingCaptures[id] = pc
+	pendingCapturesMu.Unlock()
+	defer func() {
+		pendingCapturesMu.Lock()
+		delete(pendingCaptures, id)
+		pendingCapturesMu.Unlock()
+	}()
+
+	req := map[string]interface{}{"id": id, "kind": kind}
+	if windowID >= 0 {
+		req["window"] = windowID
+	}
+	reqJSON, _ := json.Marshal(req)
+	if err := bc.send(append([]byte("EYE_CAPTURE:"), reqJSON...)); err != nil {
+		return nil, fmt.Errorf("browser disconnected")
+	}
+
+	select {
+	case err := <-pc.done:
+		if err != nil {
+			return nil, err
+		}
+	case <-time.After(captureTimeout):
+		return nil, fmt.Errorf("timeout waiting for capture")
+	}
+
+	pendingCapturesMu.Lock()
+	res := &captureResult{Kind: kind, Mime: pc.mime, Data: pc.buf.Bytes()}
+	pendingCapturesMu.Unlock()
+
+	path, err := saveCapture(username, res)
+	if err != nil {
+		return nil, err
+	}
+	res.Path = path
+	return res, nil
+}
+
+// finish completes a pending capture once. Caller holds pendingCapturesMu.
+func (pc *pendingCapture) finish(err error) {
+	select {
+	case pc.done <- err:
+	default:
+	}
+}
+
+// handleCaptureChunk appends a binary "<id>\n<bytes>" frame from the browser
+func handleCaptureChunk(bc *BrowserConnection, msg []byte) {
+	nl := bytes.IndexByte(msg, '\n')
+	if nl <= 0 {
+		return
+	}
+	id := string(msg[:nl])
+
+	pendingCapturesMu.Lock()
+	defer pendingCapturesMu.Unlock()
+	pc, ok := pendingCaptures[id]
+	if !ok || pc.bc != bc || pc.failed {
+		return
+	}
+	if pc.buf.Len()+len(msg)-nl-1 > maxCaptureSize {
+		pc.failed = true
+		pc.buf.Reset()
+		pc.finish(fmt.Errorf("capture larger than %d MB", maxCaptureSize>>20))
+		return
+	}
+	pc.buf.Write(msg[nl+1:])
+}
+
+// handleCaptureEnd completes a capture from an EYE_CAPTURE_END message
+func handleCaptureEnd(bc *BrowserConnection, payload string) {
+	var end struct {
+		ID    string `json:"id"`
+		Mime  string `json:"mime"`
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(payload), &end) != nil {
+		return
+	}
+
+	pendingCapturesMu.Lock()
+	defer pendingCapturesMu.Unlock()
+	pc, ok := pendingCaptures[end.ID]
+	if !ok || pc.bc != bc {
+		return
+	}
+	if end.Error != "" {
+		pc.finish(fmt.Errorf("%s", end.Error))
+		return
+	}
+	pc.mime = end.Mime
+	pc.finish(nil)
+}
+
+// failBrowserCaptures fails captures waiting on a browser socket that went away
+func failBrowserCaptures(bc *BrowserConnection) {
+	pendingCapturesMu.Lock()
+	defer pendingCapturesMu.Unlock()
+	for _, pc := range pendingCaptures {
+		if pc.bc == bc {
+			pc.finish(fmt.Errorf("browser disconnected"))
+		}
+	}
+}
+
+// saveCapture writes a capture to ~/.algo/captures and returns its ~/ path
+func saveCapture(username string, res *captureResult) (string, error) {
+	ext := ".json"
+	if res.Mime == "image/png" {
+		ext = ".png"
+	}
+	rel := ".algo/captures/" + time.Now().Format("20060102-150405.000") + "-" + res.Kind + ext
+	f, err := createHomeFile(username, rel)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(res.Data); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return "~/" + rel, nil
+}
+
+// createHomeFile creates rel (0600) below a user's home, along with any
+// missing directories. A system user can replace ~/.algo with a symlink, so
+// there nothing is followed and the new file is handed to the user.
+func createHomeFile(username, rel string) (*os.File, error) {
+	if sysUser, err := lookupPTYUser(username); err == nil {
+		return sysUser.openInHome(rel, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	}
+	path := filepath.Join(config.HomesDir, username, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Eye event subscriptions - lets eye clients wait for things to happen in the
+// browser instead of polling.
+//
+// Eye clients send control lines starting with "@" (never a valid request ID):
+//   @sub <topic> [filter]     subscribe; a topic ending in "*" matches a prefix
+//   @unsub <topic> [filter]   unsubscribe (every filter for the topic if omitted)
+//   @subs                     list this connection's subscriptions
+//   @capture screenshot|snapshot [window]   see eye_capture.go
+// and get "@ok ..." or "@error ..." back. Events arrive as
+//   @event {"topic":"window.opened","session":"...","page":"...","time":...,"data":{...}}
+// and a client too slow to keep up is told what it missed with
+//   @dropped {"count":N}
+//
+// Topics: window.opened, window.closed, app.launched, console.error,
+// dom.mutation (filter = CSS selector, required) and algo.<topic> for
+// ALGO.pubsub messages and ALGO.eyeEvents.emit() calls. For the other topics
+// the filter is a case-insensitive substring of the event's subject (window
+// title, app name, error message).
+//
+// The browser only runs the hooks someone is listening to: the server sends
+// EYE_SUBS:{"topics":[...],"selectors":[...]} to each eye-bridge socket when
+// the user's subscriptions change, and the browser answers with
+// EYE_EVENT:{"topic":"...","match":"...","data":...}.
+
+var eyeTopicRegex = regexp.MustCompile(`^(\*|[A-Za-z0-9_.:-]{1,64}\*?)$`)
+
+const (
+	eyeEventBuffer    = 256 // events queued per eye connection before dropping
+	maxEyeSubsPerConn = 32
+)
+
+// eyeSub is one subscription on an eye connection
+type eyeSub struct {
+	Topic  string `json:"topic"`
+	Filter string `json:"filter,omitempty"`
+}
+
+// eyeSubsMu guards EyeConnection.subs
+var eyeSubsMu sync.Mutex
+
+// topicMatches reports whether a subscribed topic (possibly "prefix*") covers topic
+func topicMatches(pattern, topic string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(topic, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == topic
+}
+
+func (sub eyeSub) matches(topic, match string) bool {
+	if !topicMatches(sub.Topic, topic) {
+		return false
+	}
+	if sub.Filter == "" {
+		return true
+	}
+	if topic == "dom.mutation" {
+		return sub.Filter == match
+	}
+	return strings.Contains(strings.ToLower(match), strings.ToLower(sub.Filter))
+}
+
+// handleEyeControl applies one "@" control line from an eye client
+func handleEyeControl(ec *EyeConnection, line string) {
+	fields := strings.SplitN(strings.TrimPrefix(line, "@"), " ", 3)
+	op := fields[0]
+	var sub eyeSub
+	if len(fields) > 1 {
+		sub.Topic = fields[1]
+	}
+	if len(fields) > 2 {
+		sub.Filter = strings.TrimSpace(fields[2])
+	}
+
+	switch op {
+	case "sub":
+		if !eyeTopicRegex.MatchString(sub.Topic) {
+			ec.write("@error invalid topic")
+			return
+		}
+		if sub.Topic == "dom.mutation" && sub.Filter == "" {
+			ec.write("@error dom.mutation needs a CSS selector")
+			return
+		}
+		eyeSubsMu.Lock()
+		exists := false
+		for _, s := range ec.subs {
+			if s == sub {
+				exists = true
+				break
+			}
+		}
+		full := !exists && len(ec.subs) >= maxEyeSubsPerConn
+		if !exists && !full {
+			ec.subs = append(ec.subs, sub)
+		}
+		eyeSubsMu.Unlock()
+		if full {
+			ec.write(fmt.Sprintf("@error too many subscriptions (max %d)", maxEyeSubsPerConn))
+			return
+		}
+		syncEyeSubscriptions(ec.Username)
+		ec.write("@ok " + strings.TrimPrefix(line, "@"))
+
+	case "unsub":
+		eyeSubsMu.Lock()
+		kept := ec.subs[:0]
+		for _, s := range ec.subs {
+			if s.Topic != sub.Topic || (sub.Filter != "" && s.Filter != sub.Filter) {
+				kept = append(kept, s)
+			}
+		}
+		ec.subs = kept
+		eyeSubsMu.Unlock()
+		syncEyeSubscriptions(ec.Username)
+		ec.write("@ok " + strings.TrimPrefix(line, "@"))
+
+	case "capture":
+		// "@capture <kind> [window]"; captures take a while, so don't hold up the read loop
+		kind, window := sub.Topic, sub.Filter
+		go func() {
+			windowID := -1
+			if window != "" {
+				n, err := strconv.Atoi(window)
+				if err != nil {
+					ec.write("@error invalid window id")
+					return
+				}
+				windowID = n
+			}
+			res, err := captureFromBrowser(ec.Username, ec.Target, kind, windowID)
+			if err != nil {
+				ec.write("@error capture: " + err.Error())
+				return
+			}
+			reply := map[string]interface{}{"kind": res.Kind, "mime": res.Mime, "size": len(res.Data), "path": res.Path}
+			if res.Kind == "snapshot" {
+				reply["data"] = json.RawMessage(res.Data)
+			}
+			data, _ := json.Marshal(reply)
+			ec.write("@capture " + string(data))
+		}()
+
+	case "subs":
+		eyeSubsMu.Lock()
+		subs := append([]eyeSub{}, ec.subs...)
+		eyeSubsMu.Unlock()
+		data, _ := json.Marshal(subs)
+		ec.write("@subs " + string(data))
+
+	default:
+		ec.write("@error unknown command: " + op)
+	}
+}
+
+// eyeSubscriptionSummary is what the browser needs to know to run its hooks
+func eyeSubscriptionSummary(username string) []byte {
+	topics := map[string]bool{}
+	selectors := map[string]bool{}
+
+	eyeConnMu.RLock()
+	eyeSubsMu.Lock()
+	for _, ec := range eyeConnections[username] {
+		for _, sub := range ec.subs {
+			topics[sub.Topic] = true
+			if sub.Topic == "dom.mutation" {
+				selectors[sub.Filter] = true
+			}
+		}
+	}
+	eyeSubsMu.Unlock()
+	eyeConnMu.RUnlock()
+
+	summary := struct {
+		Topics    []string `json:"topics"`
+		Selectors []string `json:"selectors"`
+	}{sortedKeys(topics), sortedKeys(selectors)}
+	data, _ := json.Marshal(summary)
+	return append([]byte("EYE_SUBS:"), data...)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// syncEyeSubscriptions tells the user's eye-bridge sockets which hooks to run
+func syncEyeSubscriptions(username string) {
+	msg := eyeSubscriptionSummary(username)
+	for _, bc := range listBrowserConns(username) {
+		if bc.Kind == "bridge" {
+			bc.send(msg)
+		}
+	}
+}
+
+// publishEyeEvent fans an EYE_EVENT from the browser out to subscribed eye clients
+func publishEyeEvent(bc *BrowserConnection, payload string) {
+	var ev struct {
+		Topic string          `json:"topic"`
+		Match string          `json:"match"`
+		Data  json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(payload), &ev); err != nil || !eyeTopicRegex.MatchString(ev.Topic) {
+		return
+	}
+	frame, _ := json.Marshal(map[string]interface{}{
+		"topic":   ev.Topic,
+		"session": bc.ID,
+		"page":    bc.Page,
+		"time":    time.Now().UnixMilli(),
+		"data":    ev.Data,
+	})
+	msg := "@event " + string(frame)
+
+	eyeConnMu.RLock()
+	conns := append([]*EyeConnection{}, eyeConnections[bc.Username]...)
+	eyeConnMu.RUnlock()
+
+	for _, ec := range conns {
+		eyeSubsMu.Lock()
+		wanted := false
+		for _, sub := range ec.subs {
+			if sub.matches(ev.Topic, ev.Match) {
+				wanted = true
+				break
+			}
+		}
+		eyeSubsMu.Unlock()
+		if !wanted {
+			continue
+		}
+		// Never block the browser's read loop on a slow client
+		select {
+		case ec.events <- msg:
+		default:
+			ec.dropped.Add(1)
+		}
+	}
+}
+
+// pumpEyeEvents writes queued events to the client until it disconnects
+func (ec *EyeConnection) pumpEyeEvents() {
+	for {
+		select {
+		case msg := <-ec.events:
+			if ec.write(msg) != nil {
+				return
+			}
+			if n := ec.dropped.Swap(0); n > 0 {
+				ec.write(fmt.Sprintf(`@dropped {"count":%d}`, n))
+			}
+		case <-ec.done:
+			return
+		}
+	}
+}
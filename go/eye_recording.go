@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Eye session recording - every expression an eye client sends and every
+// reply it gets, with timing, as JSON lines in ~/.algo/eye-recordings/.
+// `eye replay <file>` re-runs a recording and checks the replies still match.
+//
+//   {"type":"header","version":1,"user":"alice","session":"","started":1700000000}
+//   {"type":"send","t":0.012,"id":"a","expr":"document.title"}
+//   {"type":"recv","t":0.031,"id":"a","result":"\"Cecilia\""}
+//   {"type":"recv","t":0.050,"id":"b","error":"x is not defined"}
+//
+// Recording is on for every connection with EYE_RECORD=1, or per connection
+// with /api/eye?record=1.
+
+// eyeRecordHeader starts an eye recording
+type eyeRecordHeader struct {
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	User    string `json:"user"`
+	Session string `json:"session,omitempty"`
+	Started int64  `json:"started"`
+}
+
+// eyeRecordEvent is one expression sent or one reply received
+type eyeRecordEvent struct {
+	Type   string  `json:"type"` // "send" or "recv"
+	T      float64 `json:"t"`    // seconds since the recording started
+	ID     string  `json:"id"`
+	Expr   string  `json:"expr,omitempty"`
+	Result *string `json:"result,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// eyeRecorder appends an eye connection's traffic to a JSONL file.
+// All methods are safe to call on a nil recorder (recording disabled).
+type eyeRecorder struct {
+	f     *os.File
+	enc   *json.Encoder
+	start time.Time
+	mu    sync.Mutex
+	Path  string // ~/ form, reported to the client
+}
+
+// startEyeRecording opens a new recording in the user's home
+func startEyeRecording(username, session string) (*eyeRecorder, error) {
+	now := time.Now()
+	name := now.Format("20060102-150405") + "-" + randomHex(3) + ".jsonl"
+	f, err := createHomeFile(username, ".algo/eye-recordings/"+name)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &eyeRecorder{f: f, enc: json.NewEncoder(f), start: now, Path: "~/.algo/eye-recordings/" + name}
+	rec.enc.Encode(eyeRecordHeader{Type: "header", Version: 1, User: username, Session: session, Started: now.Unix()})
+	return rec, nil
+}
+
+// Send records an expression sent to the browser ("" id = fire and forget)
+func (rec *eyeRecorder) Send(id, expr string) {
+	rec.event(eyeRecordEvent{Type: "send", ID: id, Expr: expr})
+}
+
+// Reply records a reply in wire form: suffix is ":result" or "!:error"
+func (rec *eyeRecorder) Reply(id, suffix string) {
+	ev := eyeRecordEvent{Type: "recv", ID: id}
+	if len(suffix) > 1 && suffix[0] == '!' {
+		ev.Error = suffix[2:]
+	} else if len(suffix) > 0 {
+		result := suffix[1:]
+		ev.Result = &result
+	}
+	rec.event(ev)
+}
+
+// Close closes the recording file
+func (rec *eyeRecorder) Close() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.f.Close()
+}
+
+func (rec *eyeRecorder) event(ev eyeRecordEvent) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	ev.T = float64(time.Since(rec.start).Microseconds()) / 1e6
+	rec.enc.Encode(ev)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Home directory files
+//
+// The /api/files handlers and the MCP file tools share these functions, so
+// both get the same path resolution and home-directory checks. Paths are
+// relative to the user's home or start with "~/"; results use the "~/" form.
+
+// apiError is a refused request, with the HTTP status to report
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string { return e.msg }
+
+// apiErrorResponse reports err with its status, or as a 500
+func apiErrorResponse(w http.ResponseWriter, err error) {
+	if ae, ok := err.(*apiError); ok {
+		jsonResponse(w, map[string]string{"error": ae.msg}, ae.status)
+		return
+	}
+	jsonResponse(w, map[string]string{"error": err.Error()}, 500)
+}
+
+// homeFileInfo is one entry of a directory listing
+type homeFileInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // "file" or "directory"
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"`
+}
+
+// homeFile is a file read from a user's home
+type homeFile struct {
+	Path     string
+	Content  []byte
+	Size     int64
+	Modified int64
+}
+
+func userHomeDir(username string) string {
+	return filepath.Join(config.HomesDir, username)
+}
+
+// resolveHomePath resolves path against homeDir and refuses anything outside it
+func resolveHomePath(homeDir, path string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		path = filepath.Join(homeDir, path[2:])
+	} else if !filepath.IsAbs(path) {
+		path = filepath.Join(homeDir, path)
+	}
+	resolved, err := filepath.Abs(path)
+	if err != nil || !strings.HasPrefix(resolved, homeDir) {
+		return "", &apiError{403, "Access denied"}
+	}
+	return resolved, nil
+}
+
+// homeDisplayPath turns an absolute path under homeDir into its "~/" form
+func homeDisplayPath(homeDir, resolved string) string {
+	return strings.Replace(resolved, homeDir, "~", 1)
+}
+
+// listHomeDir lists a directory ("" or "~" for home)
+func listHomeDir(username, path string) (string, []homeFileInfo, error) {
+	homeDir := userHomeDir(username)
+	if path == "" || path == "~" {
+		path = homeDir
+	} else if strings.HasPrefix(path, "~") {
+		path = filepath.Join(homeDir, path[1:])
+	}
+
+	resolved, err := filepath.Abs(path)
+	if err != nil || !strings.HasPrefix(resolved, homeDir) {
+		return "", nil, &apiError{403, "Access denied"}
+	}
+
+	entries, err := os.ReadDir(resolved)
+	if err != nil {
+		return "", nil, &apiError{400, "Not a directory"}
+	}
+
+	var files []homeFileInfo
+	for _, entry := range entries {
+		info, _ := entry.Info()
+		fileType := "file"
+		// Check if it's a directory (or symlink to directory)
+		fullPath := filepath.Join(resolved, entry.Name())
+		if stat, err := os.Stat(fullPath); err == nil && stat.IsDir() {
+			fileType = "directory"
+		}
+		size := int64(0)
+		if fileType != "directory" {
+			size = info.Size()
+		}
+		files = append(files, homeFileInfo{
+			Name:     entry.Name(),
+			Type:     fileType,
+			Size:     size,
+			Modified: info.ModTime().Unix(),
+		})
+	}
+	return homeDisplayPath(homeDir, resolved), files, nil
+}
+
+// readHomeFile reads a file from the user's home
+func readHomeFile(username, path string) (*homeFile, error) {
+	if path == "" {
+		return nil, &apiError{400, "Path required"}
+	}
+	homeDir := userHomeDir(username)
+	resolved, err := resolveHomePath(homeDir, path)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(resolved)
+	if err != nil {
+		return nil, &apiError{404, "File not found"}
+	}
+	info, _ := os.Stat(resolved)
+	return &homeFile{
+		Path:     homeDisplayPath(homeDir, resolved),
+		Content:  content,
+		Size:     info.Size(),
+		Modified: info.ModTime().Unix(),
+	}, nil
+}
+
+// saveHomeFile creates or replaces a file, creating parent directories, and
+// returns its display path
+func saveHomeFile(username, path, content string) (string, error) {
+	if path == "" {
+		return "", &apiError{400, "Path required"}
+	}
+	homeDir := userHomeDir(username)
+	os.MkdirAll(homeDir, 0755)
+
+	resolved, err := resolveHomePath(homeDir, path)
+	if err != nil {
+		return "", err
+	}
+
+	// Create parent directory if needed
+	os.MkdirAll(filepath.Dir(resolved), 0755)
+
+	if err := os.WriteFile(resolved, []byte(content), 0644); err != nil {
+		return "", &apiError{500, "Failed to save file"}
+	}
+	return homeDisplayPath(homeDir, resolved), nil
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IPC - named channels between the user's shell and browser
+//
+// Shell side: a Unix domain socket at ~/.algo/ipc.sock
+// Browser side: WebSocket at /api/ipc?token=... (and POST /api/ipc/publish)
+//
+// Both speak newline-delimited JSON frames:
+//   {"op":"sub","channel":"notes"}               subscribe ("*" = every channel)
+//   {"op":"unsub","channel":"notes"}             unsubscribe
+//   {"op":"pub","channel":"notes","data":"hi"}   publish to subscribers
+//   {"op":"channels"}                            list channels with subscribers
+//   {"op":"ping"}                                liveness check
+//   {"op":"eval","data":"document.title"}        run JS in the browser via the eye bridge
+// Requests may carry an "id", echoed in the {"op":"ok"} / {"op":"error"} reply.
+// Subscribers receive {"op":"msg","channel":"notes","data":"hi","from":"shell","time":...}
+// as soon as a message is published; there is no polling and no backlog.
+// An eval is answered with {"op":"result","id":...,"data":...} or an error frame.
+//
+// Legacy PTY IPC interoperates through two reserved channels: IPC_WRITE data
+// is published on "out", and shell messages published on "in" are appended
+// to ~/.algo/in, where IPC_READ picks them up.
+
+var ipcChannelRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+const (
+	ipcSendBuffer  = 64
+	ipcEvalTimeout = 30 * time.Second
+)
+
+// ipcFrame is one message on an IPC connection
+type ipcFrame struct {
+	Op       string         `json:"op"`
+	ID       string         `json:"id,omitempty"`
+	Channel  string         `json:"channel,omitempty"`
+	Data     string         `json:"data,omitempty"`
+	From     string         `json:"from,omitempty"`
+	Time     int64          `json:"time,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Channels map[string]int `json:"channels,omitempty"`
+}
+
+// ipcSubscriber is one shell or browser connection to a user's hub
+type ipcSubscriber struct {
+	From     string // "shell" or "browser"
+	send     chan ipcFrame
+	channels map[string]bool // guarded by hub.mu
+	closed   bool            // guarded by hub.mu
+	done     chan struct{}
+}
+
+// ipcHub routes messages between one user's IPC connections
+type ipcHub struct {
+	Username  string
+	mu        sync.Mutex
+	subs      map[*ipcSubscriber]bool
+	listening bool
+	sysUser   *ptyUser // known once the shell socket is listening
+}
+
+var (
+	ipcHubs   = make(map[string]*ipcHub) // username -> hub
+	ipcHubsMu sync.Mutex
+)
+
+func getIPCHub(username string) *ipcHub {
+	ipcHubsMu.Lock()
+	defer ipcHubsMu.Unlock()
+	hub := ipcHubs[username]
+	if hub == nil {
+		hub = &ipcHub{Username: username, subs: make(map[*ipcSubscriber]bool)}
+		ipcHubs[username] = hub
+	}
+	return hub
+}
+
+func (hub *ipcHub) attach(from string) *ipcSubscriber {
+	sub := &ipcSubscriber{
+		From:     from,
+		send:     make(chan ipcFrame, ipcSendBuffer),
+		channels: make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+	hub.mu.Lock()
+	hub.subs[sub] = true
+	hub.mu.Unlock()
+	return sub
+}
+
+func (hub *ipcHub) detach(sub *ipcSubscriber) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(hub.subs, sub)
+	close(sub.done)
+}
+
+// deliver queues a frame without blocking; a subscriber that can't keep up is dropped.
+// Caller holds hub.mu.
+func (hub *ipcHub) deliver(sub *ipcSubscriber, frame ipcFrame) {
+	if sub.closed {
+		return
+	}
+	select {
+	case sub.send <- frame:
+	default:
+		fmt.Printf("[IPC] Dropping slow %s subscriber for %s\n", sub.From, hub.Username)
+		sub.closed = true
+		delete(hub.subs, sub)
+		close(sub.done)
+	}
+}
+
+// Publish pushes a message to every subscriber of the channel except the sender
+func (hub *ipcHub) Publish(sender *ipcSubscriber, channel, from, data string) int {
+	frame := ipcFrame{Op: "msg", Channel: channel, Data: data, From: from, Time: time.Now().UnixMilli()}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	delivered := 0
+	for sub := range hub.subs {
+		if sub == sender || !(sub.channels[channel] || sub.channels["*"]) {
+			continue
+		}
+		hub.deliver(sub, frame)
+		delivered++
+	}
+	return delivered
+}
+
+// Channels counts subscribers per channel
+func (hub *ipcHub) Channels() map[string]int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	channels := make(map[string]int)
+	for sub := range hub.subs {
+		for ch := range sub.channels {
+			channels[ch]++
+		}
+	}
+	return channels
+}
+
+// reply sends a direct response to one subscriber
+func (hub *ipcHub) reply(sub *ipcSubscriber, frame ipcFrame) {
+	hub.mu.Lock()
+	hub.deliver(sub, frame)
+	hub.mu.Unlock()
+}
+
+// handleFrame applies one request from a subscriber
+func (hub *ipcHub) handleFrame(sub *ipcSubscriber, req ipcFrame) {
+	fail := func(msg string) {
+		hub.reply(sub, ipcFrame{Op: "error", ID: req.ID, Error: msg})
+	}
+	validChannel := ipcChannelRegex.MatchString(req.Channel)
+
+	switch req.Op {
+	case "sub":
+		if !validChannel && req.Channel != "*" {
+			fail("invalid channel name")
+			return
+		}
+		hub.mu.Lock()
+		sub.channels[req.Channel] = true
+		hub.mu.Unlock()
+		hub.reply(sub, ipcFrame{Op: "ok", ID: req.ID, Channel: req.Channel})
+
+	case "unsub":
+		hub.mu.Lock()
+		delete(sub.channels, req.Channel)
+		hub.mu.Unlock()
+		hub.reply(sub, ipcFrame{Op: "ok", ID: req.ID, Channel: req.Channel})
+
+	case "pub":
+		if !validChannel {
+			fail("invalid channel name")
+			return
+		}
+		hub.Publish(sub, req.Channel, sub.From, req.Data)
+		if req.Channel == "in" && sub.From == "shell" {
+			hub.appendLegacyIn(req.Data)
+		}
+		if req.ID != "" {
+			hub.reply(sub, ipcFrame{Op: "ok", ID: req.ID, Channel: req.Channel})
+		}
+
+	case "channels":
+		hub.reply(sub, ipcFrame{Op: "channels", ID: req.ID, Channels: hub.Channels()})
+
+	case "ping":
+		hub.reply(sub, ipcFrame{Op: "pong", ID: req.ID})
+
+	case "eval":
+		// Don't block the connection's read loop while the browser works
+		go func() {
+			result, err := evalInBrowser(hub.Username, req.Data, ipcEvalTimeout)
+			if err != nil {
+				fail(err.Error())
+				return
+			}
+			hub.reply(sub, ipcFrame{Op: "result", ID: req.ID, Data: result})
+		}()
+
+	default:
+		fail("unknown op: " + req.Op)
+	}
+}
+
+// Listen serves the shell-side Unix socket at ~/.algo/ipc.sock (once per hub)
+func (hub *ipcHub) Listen(sysUser *ptyUser, ipcDir string) error {
+	hub.mu.Lock()
+	if hub.listening {
+		hub.mu.Unlock()
+		return nil
+	}
+	hub.listening = true
+	hub.sysUser = sysUser
+	hub.mu.Unlock()
+
+	sockPath := filepath.Join(ipcDir, "ipc.sock")
+	os.Remove(sockPath) // stale socket from a previous server run
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		hub.mu.Lock()
+		hub.listening = false
+		hub.mu.Unlock()
+		return err
+	}
+	os.Chmod(sockPath, 0600)
+	sysUser.own(sockPath)
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go hub.serveSocket(c)
+		}
+	}()
+	return nil
+}
+
+// serveSocket speaks JSON lines with one shell client
+func (hub *ipcHub) serveSocket(c net.Conn) {
+	defer c.Close()
+	sub := hub.attach("shell")
+	defer hub.detach(sub)
+
+	go func() {
+		enc := json.NewEncoder(c) // Encode appends the newline
+		for {
+			select {
+			case frame := <-sub.send:
+				if enc.Encode(frame) != nil {
+					c.Close()
+					return
+				}
+			case <-sub.done:
+				c.Close()
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(c)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var req ipcFrame
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			hub.reply(sub, ipcFrame{Op: "error", Error: "invalid JSON frame"})
+			continue
+		}
+		hub.handleFrame(sub, req)
+	}
+}
+
+// handleIPC is the browser side of the IPC hub over WebSocket
+func handleIPC(w http.ResponseWriter, r *http.Request) {
+	username := verifyToken(r.URL.Query().Get("token"))
+	if username == "" {
+		http.Error(w, "Invalid token", 401)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	hub := getIPCHub(username)
+	sub := hub.attach("browser")
+	defer hub.detach(sub)
+
+	// Optional initial subscriptions: /api/ipc?channel=a&channel=b
+	for _, ch := range r.URL.Query()["channel"] {
+		hub.handleFrame(sub, ipcFrame{Op: "sub", Channel: ch})
+	}
+
+	go func() {
+		for {
+			select {
+			case frame := <-sub.send:
+				if conn.WriteJSON(frame) != nil {
+					conn.Close()
+					return
+				}
+			case <-sub.done:
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		var req ipcFrame
+		if err := conn.ReadJSON(&req); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				hub.reply(sub, ipcFrame{Op: "error", Error: "invalid JSON frame"})
+				continue
+			}
+			return
+		}
+		hub.handleFrame(sub, req)
+	}
+}
+
+// handleIPCPublish publishes one message from an HTTP client
+func handleIPCPublish(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	var req struct {
+		Channel string `json:"channel"`
+		Data    string `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, map[string]string{"error": "Invalid request"}, 400)
+		return
+	}
+	if !ipcChannelRegex.MatchString(req.Channel) {
+		jsonResponse(w, map[string]string{"error": "Invalid channel name"}, 400)
+		return
+	}
+
+	delivered := getIPCHub(username).Publish(nil, req.Channel, "browser", req.Data)
+	jsonResponse(w, map[string]interface{}{"success": true, "delivered": delivered}, 200)
+}
+
+// handleIPCChannels lists the user's channels and subscriber counts
+func handleIPCChannels(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	counts := getIPCHub(username).Channels()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	channels := []map[string]interface{}{}
+	for _, name := range names {
+		channels = append(channels, map[string]interface{}{"name": name, "subscribers": counts[name]})
+	}
+	jsonResponse(w, map[string]interface{}{"channels": channels}, 200)
+}
+
+// Legacy IPC files, relative to the user's home
+const (
+	legacyIPCIn  = ".algo/in"
+	legacyIPCOut = ".algo/out"
+)
+
+// publishLegacyIPCWrite mirrors legacy IPC_WRITE data onto the "out" channel
+func publishLegacyIPCWrite(username, data string) {
+	getIPCHub(username).Publish(nil, "out", "browser", data)
+}
+
+// appendLegacyIn queues a shell message on the "in" channel for legacy
+// IPC_READ clients, which drain ~/.algo/in
+func (hub *ipcHub) appendLegacyIn(data string) {
+	hub.mu.Lock()
+	sysUser := hub.sysUser
+	hub.mu.Unlock()
+	if sysUser == nil {
+		return
+	}
+	// The shell owns ~/.algo, so don't follow links it may have put there
+	f, err := sysUser.openInHome(legacyIPCIn, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	f.WriteString(data + "\n")
+	f.Close()
+}
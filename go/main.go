@@ -8,6 +8,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -24,8 +25,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/creack/pty"
@@ -47,6 +50,27 @@ var config = struct {
 	FolderIcon    string
 	SettingsIcon  string
 	LogoutIcon    string
+
+	// Accept string-prefixed PTY control frames from clients without a subprotocol
+	PTYLegacyProtocol bool
+
+	// Detach PTY sockets with no input for this long (0 = never)
+	PTYIdleTimeout time.Duration
+	// Kill detached tmux sessions created longer ago than this (0 = never)
+	PTYSessionMaxAge time.Duration
+	// Kill detached tmux sessions with no activity for this long (0 = never)
+	PTYSessionIdle time.Duration
+
+	// PTY session recording (asciicast v2)
+	RecordPTY       bool
+	RecordInput     string // "off", "redacted" or "full"
+	RecordRetention time.Duration
+
+	// Eye bridge: reply "id!:timeout" after this long; cap requests per client
+	EyeTimeout     time.Duration
+	EyeMaxInFlight int
+	// Record every eye connection to ~/.algo/eye-recordings
+	EyeRecord bool
 }{
 	OSName:        getEnv("OS_NAME", "Cecilia"),
 	OSIcon:        getEnv("OS_ICON", "🌼"),
@@ -60,60 +84,244 @@ var config = struct {
 	FolderIcon:    "📁",
 	SettingsIcon:  "⚙",
 	LogoutIcon:    "🚪",
+
+	PTYLegacyProtocol: getEnv("PTY_LEGACY_PROTOCOL", "1") == "1",
+	PTYIdleTimeout:    getEnvDuration("PTY_IDLE_TIMEOUT", 0),
+	PTYSessionMaxAge:  getEnvDuration("PTY_SESSION_MAX_AGE", 0),
+	PTYSessionIdle:    getEnvDuration("PTY_SESSION_IDLE_TIMEOUT", 0),
+
+	RecordPTY:       getEnv("PTY_RECORD", "") == "1",
+	RecordInput:     getEnv("PTY_RECORD_INPUT", "off"),
+	RecordRetention: getEnvDuration("PTY_RECORD_RETENTION", 30*24*time.Hour),
+
+	EyeTimeout:     getEnvDuration("EYE_TIMEOUT", 30*time.Second),
+	EyeMaxInFlight: getEnvInt("EYE_MAX_INFLIGHT", 64),
+	EyeRecord:      getEnv("EYE_RECORD", "") == "1",
 }
 
 var (
-	usersDir       string
-	allowedCmds    = []string{"ls", "cd", "pwd", "cat", "head", "tail", "wc", "mkdir", "rmdir", "touch", "cp", "mv", "rm", "echo", "date", "whoami", "id", "uname", "grep", "find", "sort", "uniq", "diff", "tar", "gzip", "gunzip", "zip", "unzip", "curl", "wget", "node", "npm", "npx", "python", "python3", "pip", "pip3", "git", "claude", "go", "vim", "nano", "less", "more"}
-	blockedCmds    = []string{"sudo", "su", "passwd", "useradd", "userdel", "usermod", "chown", "chmod", "chgrp", "mount", "umount", "reboot", "shutdown", "halt", "poweroff", "systemctl", "service", "iptables", "ufw", "dd", "mkfs", "fdisk", "parted"}
-	usernameRegex  = regexp.MustCompile(`^[a-z][a-z0-9_]{2,31}$`)
+	usersDir      string
+	ticketsDir    string
+	allowedCmds   = []string{"ls", "cd", "pwd", "cat", "head", "tail", "wc", "mkdir", "rmdir", "touch", "cp", "mv", "rm", "echo", "date", "whoami", "id", "uname", "grep", "find", "sort", "uniq", "diff", "tar", "gzip", "gunzip", "zip", "unzip", "curl", "wget", "node", "npm", "npx", "python", "python3", "pip", "pip3", "git", "claude", "go", "vim", "nano", "less", "more"}
+	blockedCmds   = []string{"sudo", "su", "passwd", "useradd", "userdel", "usermod", "chown", "chmod", "chgrp", "mount", "umount", "reboot", "shutdown", "halt", "poweroff", "systemctl", "service", "iptables", "ufw", "dd", "mkfs", "fdisk", "parted"}
+	usernameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{2,31}$`)
 )
 
 // MCP Bridge - connects Claude Code to browser ALGO.bridge
 type BrowserConnection struct {
+	ID        string
 	Conn      *websocket.Conn
 	Username  string
+	Kind      string // "pty" (Shell/Claude apps: MCP and eye) or "bridge" (eye only)
+	Page      string // browser tab that opened the socket; a tab's sockets share it
+	UserAgent string
+	Opened    time.Time
+	Protocol  string                 // PTY framing version; "" for legacy and eye-bridge sockets
 	Responses map[string]chan string // request ID -> response channel
 	mu        sync.Mutex
+	out       *wsWriter     // all writes except ping and close go through here
+	gone      chan struct{} // closed on unregister
+	focused   bool          // guarded by browserConnMu
+	focusedAt time.Time     // guarded by browserConnMu
 }
 
 var (
-	browserConnections = make(map[string]*BrowserConnection) // username -> connection
+	browserConnections = make(map[string][]*BrowserConnection) // username -> connections
 	browserConnMu      sync.RWMutex
 )
 
 // Register a browser connection for MCP routing
-func registerBrowserConn(username string, conn *websocket.Conn) *BrowserConnection {
+func registerBrowserConn(username, kind string, conn *websocket.Conn, r *http.Request) *BrowserConnection {
 	browserConnMu.Lock()
 	defer browserConnMu.Unlock()
 
 	bc := &BrowserConnection{
+		ID:        randomHex(4),
 		Conn:      conn,
 		Username:  username,
+		Kind:      kind,
+		Page:      r.URL.Query().Get("page"),
+		UserAgent: r.UserAgent(),
+		Opened:    time.Now(),
+		Protocol:  conn.Subprotocol(),
 		Responses: make(map[string]chan string),
+		out:       newWSWriter(conn),
+		gone:      make(chan struct{}),
+	}
+	// A new socket from an existing tab inherits the tab's focus state
+	for _, other := range browserConnections[username] {
+		if bc.Page != "" && other.Page == bc.Page {
+			bc.focused, bc.focusedAt = other.focused, other.focusedAt
+			break
+		}
+	}
+	browserConnections[username] = append(browserConnections[username], bc)
+	if kind == "pty" {
+		mcpLog(username, "info", map[string]interface{}{"event": "browser connected", "session": bc.ID, "page": bc.Page})
 	}
-	browserConnections[username] = bc
 	return bc
 }
 
-// Unregister a browser connection
-func unregisterBrowserConn(username string) {
+// Unregister a browser connection, leaving the user's other connections in place
+func unregisterBrowserConn(bc *BrowserConnection) {
+	browserConnMu.Lock()
+
+	conns := browserConnections[bc.Username]
+	for i, c := range conns {
+		if c == bc {
+			conns = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(browserConnections, bc.Username)
+	} else {
+		browserConnections[bc.Username] = conns
+	}
+	browserConnMu.Unlock()
+
+	close(bc.gone) // fails pending SendCommand calls
+	bc.out.Close()
+	failBrowserEyeRequests(bc)
+	failBrowserCaptures(bc)
+	if bc.Kind == "pty" {
+		mcpLog(bc.Username, "info", map[string]interface{}{"event": "browser disconnected", "session": bc.ID, "page": bc.Page})
+	}
+}
+
+// setPageFocus records focus changes reported by a browser tab
+func setPageFocus(username, page string, focused bool) {
+	if page == "" {
+		return
+	}
 	browserConnMu.Lock()
 	defer browserConnMu.Unlock()
-	delete(browserConnections, username)
+	for _, bc := range browserConnections[username] {
+		if bc.Page == page {
+			bc.focused = focused
+			if focused {
+				bc.focusedAt = time.Now()
+			}
+		}
+	}
+}
+
+// listBrowserConns returns the user's connections, oldest first
+func listBrowserConns(username string) []*BrowserConnection {
+	browserConnMu.RLock()
+	defer browserConnMu.RUnlock()
+	return append([]*BrowserConnection(nil), browserConnections[username]...)
+}
+
+// Get the connection that untargeted requests go to: the focused tab, else the
+// most recently focused one, else the newest. With mcp set, only connections
+// that handle MCP commands are considered.
+func getBrowserConn(username string, mcp bool) *BrowserConnection {
+	browserConnMu.RLock()
+	defer browserConnMu.RUnlock()
+
+	var best *BrowserConnection
+	for _, bc := range browserConnections[username] {
+		if mcp && bc.Kind != "pty" {
+			continue
+		}
+		if best == nil ||
+			bc.focused && !best.focused ||
+			bc.focused == best.focused && !bc.focusedAt.Before(best.focusedAt) {
+			best = bc
+		}
+	}
+	return best
+}
+
+// findBrowserConns resolves a target: "" for the default connection, "all"
+// for every connection, or a connection ID
+func findBrowserConns(username, target string, mcp bool) []*BrowserConnection {
+	switch target {
+	case "":
+		if bc := getBrowserConn(username, mcp); bc != nil {
+			return []*BrowserConnection{bc}
+		}
+		return nil
+	case "all":
+		var result []*BrowserConnection
+		for _, bc := range listBrowserConns(username) {
+			if !mcp || bc.Kind == "pty" {
+				result = append(result, bc)
+			}
+		}
+		return result
+	}
+	for _, bc := range listBrowserConns(username) {
+		if bc.ID == target && (!mcp || bc.Kind == "pty") {
+			return []*BrowserConnection{bc}
+		}
+	}
+	return nil
 }
 
-// Get a browser connection
-func getBrowserConn(username string) *BrowserConnection {
+// info describes the connection for session listings
+func (bc *BrowserConnection) info() map[string]interface{} {
 	browserConnMu.RLock()
 	defer browserConnMu.RUnlock()
-	return browserConnections[username]
+	info := map[string]interface{}{
+		"id":        bc.ID,
+		"kind":      bc.Kind,
+		"page":      bc.Page,
+		"userAgent": bc.UserAgent,
+		"opened":    bc.Opened.Unix(),
+		"focused":   bc.focused,
+	}
+	if !bc.focusedAt.IsZero() {
+		info["focusedAt"] = bc.focusedAt.Unix()
+	}
+	bc.mu.Lock()
+	info["pending"] = len(bc.Responses)
+	bc.mu.Unlock()
+	info["queue"] = bc.out.Stats()
+	return info
+}
+
+// handleBrowserSessions lists the caller's browser connections for eye and MCP targeting
+func handleBrowserSessions(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	sessions := []map[string]interface{}{}
+	for _, bc := range listBrowserConns(username) {
+		sessions = append(sessions, bc.info())
+	}
+	var defaultID string
+	if bc := getBrowserConn(username, false); bc != nil {
+		defaultID = bc.ID
+	}
+	jsonResponse(w, map[string]interface{}{"sessions": sessions, "default": defaultID}, 200)
+}
+
+// bridgeCommandTimeout bounds SendCommand when the caller's context has no deadline
+const bridgeCommandTimeout = 30 * time.Second
+
+var bridgeRequestCounter atomic.Uint64
+
+// send queues a text message for the browser
+func (bc *BrowserConnection) send(msg []byte) error {
+	return bc.out.Text(msg)
 }
 
-// Send a bridge command to browser and wait for response
-func (bc *BrowserConnection) SendCommand(cmd map[string]interface{}) (string, error) {
-	// Generate unique request ID
-	reqID := fmt.Sprintf("%d", time.Now().UnixNano())
+// Send a bridge command to browser and wait for response. Any number of
+// commands may be in flight at once; each gets its own ID. The call ends
+// early if ctx is done or the browser disconnects.
+func (bc *BrowserConnection) SendCommand(ctx context.Context, cmd map[string]interface{}) (string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bridgeCommandTimeout)
+		defer cancel()
+	}
+
+	reqID := fmt.Sprintf("m%d", bridgeRequestCounter.Add(1))
 	cmd["_mcpReqId"] = reqID
 
 	// Create response channel
@@ -130,24 +338,41 @@ func (bc *BrowserConnection) SendCommand(cmd map[string]interface{}) (string, er
 
 	// Send command to browser
 	cmdJSON, _ := json.Marshal(cmd)
-	err := bc.Conn.WriteMessage(websocket.TextMessage, []byte("MCP_CMD:"+string(cmdJSON)))
-	if err != nil {
+	err := bc.out.Send(ctx, websocket.TextMessage, formatPTYControl(bc.Protocol, &ptyControl{Type: ptyMsgMCPCommand, ID: reqID, Command: cmdJSON}))
+	if err == errWriterClosed {
+		return "", fmt.Errorf("browser disconnected")
+	}
+	if err != nil && ctx.Err() == nil {
 		return "", err
 	}
 
-	// Wait for response with timeout
 	select {
 	case resp := <-respChan:
 		return resp, nil
-	case <-time.After(30 * time.Second):
-		return "", fmt.Errorf("timeout waiting for browser response")
+	case <-bc.gone:
+		return "", fmt.Errorf("browser disconnected")
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("timeout waiting for browser response")
+		}
+		return "", ctx.Err()
 	}
 }
 
 // Eye bridge connections (direct AI-to-browser communication)
 type EyeConnection struct {
-	Conn     *websocket.Conn
-	Username string
+	Conn        *websocket.Conn
+	Username    string
+	Target      string        // ?session= the connection was opened with
+	Timeout     time.Duration // reply "id!:timeout" after this long
+	MaxInFlight int
+	inFlight    int      // guarded by eyeRequestsMu
+	subs        []eyeSub // guarded by eyeSubsMu
+	events      chan string
+	dropped     atomic.Int64 // events lost since the last one delivered
+	done        chan struct{}
+	recorder    *eyeRecorder // nil unless recording
+	out         *wsWriter
 }
 
 var (
@@ -159,33 +384,221 @@ func registerEyeConn(username string, conn *websocket.Conn) *EyeConnection {
 	eyeConnMu.Lock()
 	defer eyeConnMu.Unlock()
 
-	ec := &EyeConnection{Conn: conn, Username: username}
+	ec := &EyeConnection{
+		Conn:        conn,
+		Username:    username,
+		Timeout:     config.EyeTimeout,
+		MaxInFlight: config.EyeMaxInFlight,
+		events:      make(chan string, eyeEventBuffer),
+		done:        make(chan struct{}),
+		out:         newWSWriter(conn),
+	}
 	eyeConnections[username] = append(eyeConnections[username], ec)
 	return ec
 }
 
 func unregisterEyeConn(username string, ec *EyeConnection) {
 	eyeConnMu.Lock()
-	defer eyeConnMu.Unlock()
-
 	conns := eyeConnections[username]
 	for i, c := range conns {
 		if c == ec {
-			eyeConnections[username] = append(conns[:i], conns[i+1:]...)
+			eyeConnections[username] = append(conns[:i:i], conns[i+1:]...)
 			break
 		}
 	}
+	eyeConnMu.Unlock()
+	close(ec.done)
+	ec.out.Close()
+
+	dropEyeRequests(ec)
+
+	eyeSubsMu.Lock()
+	hadSubs := len(ec.subs) > 0
+	eyeSubsMu.Unlock()
+	if hadSubs {
+		syncEyeSubscriptions(username)
+	}
 }
 
-// Send eye response back to all connected Claude instances for this user
-func sendEyeResponse(username string, msg string) {
-	eyeConnMu.RLock()
-	conns := eyeConnections[username]
-	eyeConnMu.RUnlock()
+// write queues one text message; replies arrive from browser goroutines
+func (ec *EyeConnection) write(msg string) error {
+	return ec.out.Text([]byte(msg))
+}
+
+// reply answers request id with suffix ":result" or "!:error"
+func (ec *EyeConnection) reply(id, suffix string) error {
+	ec.recorder.Reply(id, suffix)
+	return ec.write(id + suffix)
+}
+
+// eyeRequest is an eye command waiting for the browser's reply. The browser
+// sees a server-unique ID so clients can't collide on short IDs like "a";
+// the reply goes back to whoever asked, under the ID they chose.
+type eyeRequest struct {
+	ID        string               // the requester's ID
+	Username  string               // only this user's browsers may answer
+	Requester *EyeConnection       // nil for requests issued by the server itself
+	respChan  chan string          // server-issued requests only
+	browsers  []*BrowserConnection // browsers that may answer; broadcasts reach several
+	timer     *time.Timer
+}
+
+var (
+	eyeRequests       = make(map[string]*eyeRequest) // server ID -> request
+	eyeRequestsMu     sync.Mutex
+	eyeRequestCounter uint64
+)
+
+// trackEyeRequest registers a request sent to req.browsers and returns the ID
+// to send in its place. The first browser to answer finishes the request. If
+// none does within timeout the requester gets "id!:timeout".
+func trackEyeRequest(req *eyeRequest, timeout time.Duration) (string, error) {
+	serverID := fmt.Sprintf("e%d", atomic.AddUint64(&eyeRequestCounter, 1))
+
+	eyeRequestsMu.Lock()
+	defer eyeRequestsMu.Unlock()
+	if ec := req.Requester; ec != nil {
+		if ec.MaxInFlight > 0 && ec.inFlight >= ec.MaxInFlight {
+			return "", fmt.Errorf("too many requests in flight (max %d)", ec.MaxInFlight)
+		}
+		ec.inFlight++
+	}
+	eyeRequests[serverID] = req
+	req.timer = time.AfterFunc(timeout, func() { failEyeRequest(serverID, "timeout") })
+	return serverID, nil
+}
+
+// finishEyeRequest removes a request. Caller holds eyeRequestsMu.
+func finishEyeRequest(serverID string, req *eyeRequest) {
+	req.timer.Stop()
+	delete(eyeRequests, serverID)
+	if req.Requester != nil {
+		req.Requester.inFlight--
+	}
+}
+
+// reply delivers "id:result" or "id!:error" (given as suffix ":result" / "!:error")
+func (req *eyeRequest) reply(suffix string) {
+	if req.Requester == nil {
+		select {
+		case req.respChan <- req.ID + suffix:
+		default:
+		}
+		return
+	}
+	req.Requester.reply(req.ID, suffix)
+}
+
+// failEyeRequest stops waiting and sends the requester an error
+func failEyeRequest(serverID, reason string) {
+	eyeRequestsMu.Lock()
+	req, ok := eyeRequests[serverID]
+	if ok {
+		finishEyeRequest(serverID, req)
+	}
+	eyeRequestsMu.Unlock()
+
+	if ok {
+		req.reply("!:" + reason)
+	}
+}
+
+// forgetEyeRequest stops waiting for a reply without telling anyone
+func forgetEyeRequest(serverID string) {
+	eyeRequestsMu.Lock()
+	defer eyeRequestsMu.Unlock()
+	if req, ok := eyeRequests[serverID]; ok {
+		finishEyeRequest(serverID, req)
+	}
+}
+
+// dropEyeRequests forgets everything an eye connection is waiting for
+func dropEyeRequests(ec *EyeConnection) {
+	eyeRequestsMu.Lock()
+	defer eyeRequestsMu.Unlock()
+	for serverID, req := range eyeRequests {
+		if req.Requester == ec {
+			finishEyeRequest(serverID, req)
+		}
+	}
+}
+
+// takeEyeBrowser marks bc as done with req and reports whether it was still
+// expected to answer. Caller holds eyeRequestsMu.
+func (req *eyeRequest) takeEyeBrowser(bc *BrowserConnection) bool {
+	for i, b := range req.browsers {
+		if b == bc {
+			req.browsers = append(req.browsers[:i:i], req.browsers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// failBrowserEyeRequests answers "id!:browser disconnected" for everything
+// that was waiting only on a browser connection that went away
+func failBrowserEyeRequests(bc *BrowserConnection) {
+	var failed []*eyeRequest
+	eyeRequestsMu.Lock()
+	for serverID, req := range eyeRequests {
+		if !req.takeEyeBrowser(bc) || len(req.browsers) > 0 {
+			continue
+		}
+		finishEyeRequest(serverID, req)
+		failed = append(failed, req)
+	}
+	eyeRequestsMu.Unlock()
+
+	for _, req := range failed {
+		req.reply("!:browser disconnected")
+	}
+}
+
+// evalInBrowser runs an expression in the user's browser through the eye bridge
+// and waits for the result
+func evalInBrowser(username, expression string, timeout time.Duration) (string, error) {
+	bc := getBrowserConn(username, false)
+	if bc == nil {
+		return "", fmt.Errorf("no browser connected")
+	}
+
+	respChan := make(chan string, 1)
+	id, _ := trackEyeRequest(&eyeRequest{ID: "srv", Username: username, respChan: respChan, browsers: []*BrowserConnection{bc}}, timeout)
+	defer forgetEyeRequest(id)
+
+	cmdMsg := formatPTYControl(bc.Protocol, &ptyControl{Type: ptyMsgEyeCommand, ID: id, Expr: expression})
+	if err := bc.send(cmdMsg); err != nil {
+		return "", fmt.Errorf("browser disconnected")
+	}
+
+	// A reply always arrives: the result, a timeout or a disconnect
+	resp := <-respChan
+	if strings.HasPrefix(resp, "srv!:") {
+		return "", fmt.Errorf("%s", resp[5:])
+	}
+	return strings.TrimPrefix(resp, "srv:"), nil
+}
+
+// Route an eye response from the browser ("id:result" or "id!:error") back to
+// the client that sent the request, restoring its original ID. Only the first
+// reply to a broadcast is delivered; the rest arrive after it finished.
+func sendEyeResponse(bc *BrowserConnection, msg string) {
+	colonIdx := strings.Index(msg, ":")
+	if colonIdx <= 0 {
+		return // reply to a fire-and-forget command
+	}
+	serverID := strings.TrimSuffix(msg[:colonIdx], "!")
 
-	for _, ec := range conns {
-		ec.Conn.WriteMessage(websocket.TextMessage, []byte(msg))
+	eyeRequestsMu.Lock()
+	req, ok := eyeRequests[serverID]
+	if !ok || !req.takeEyeBrowser(bc) {
+		eyeRequestsMu.Unlock()
+		return // unknown, late, or not sent to this browser
 	}
+	finishEyeRequest(serverID, req)
+	eyeRequestsMu.Unlock()
+
+	req.reply(msg[len(serverID):])
 }
 
 // Handle MCP response from browser
@@ -209,6 +622,24 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
 func init() {
 	// Set homes directory based on platform
 	if config.HomesDir == "" {
@@ -223,7 +654,10 @@ func init() {
 	}
 
 	usersDir = filepath.Join(config.DataDir, "users")
+	recordingsDir = filepath.Join(config.DataDir, "recordings")
+	ticketsDir = filepath.Join(config.DataDir, "tickets")
 	os.MkdirAll(usersDir, 0755)
+	os.MkdirAll(ticketsDir, 0755)
 	os.MkdirAll(config.HomesDir, 0755)
 }
 
@@ -244,27 +678,6 @@ type TokenPayload struct {
 	Rand     string `json:"rand"`
 }
 
-// Ticket represents a task/issue
-type Ticket struct {
-	ID          string   `json:"id"`
-	Title       string   `json:"title"`
-	Description string   `json:"description,omitempty"`
-	Status      string   `json:"status"`
-	Created     string   `json:"created"`
-	Replies     []Reply  `json:"replies,omitempty"`
-}
-
-type Reply struct {
-	Date string `json:"date"`
-	Text string `json:"text"`
-}
-
-var (
-	tickets     []Ticket
-	ticketMutex sync.RWMutex
-	ticketID    int
-)
-
 func generateToken(username string) (string, error) {
 	randBytes := make([]byte, 16)
 	rand.Read(randBytes)
@@ -659,145 +1072,205 @@ func handlePTY(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Upgrade to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Upgrade to WebSocket, negotiating the framing version
+	conn, err := ptyUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
 	defer conn.Close()
 
-	homeDir := user.HomeDir
-	if homeDir == "" {
-		homeDir = getSystemUserHomeDir(username)
+	protocol := conn.Subprotocol()
+	if protocol == "" && !config.PTYLegacyProtocol {
+		conn.WriteMessage(websocket.TextMessage, []byte("Error: legacy PTY protocol disabled, connect with subprotocol "+ptyProtocolV1))
+		return
+	}
+
+	// Resolve the account the session runs as (uid, gid, groups, shell)
+	sysUser, err := lookupPTYUser(username)
+	if err != nil {
+		conn.WriteMessage(websocket.TextMessage, formatPTYControl(protocol, &ptyControl{Type: ptyMsgError, Error: "Error starting PTY: " + err.Error()}))
+		return
 	}
+	homeDir := sysUser.HomeDir
 
 	// Use tmux for persistent sessions
 	// Session name based on username for persistence
 	sessionName := "fs-" + username
 
 	// Enable mouse mode in tmux for scrolling support
-	exec.Command("tmux", "set", "-g", "mouse", "on").Run()
+	// tmux runs as the user, so this talks to the user's own tmux server
+	sysUser.command("tmux", "set", "-g", "mouse", "on").Run()
 
 	// Check if tmux session exists, create or attach
 	// Using tmux new-session -A: attach if exists, create if not
-	cmd := exec.Command("tmux", "new-session", "-A", "-s", sessionName)
-	cmd.Env = append(os.Environ(),
-		"HOME="+homeDir,
-		"USER="+username,
-		"TERM=xterm-256color",
-	)
-	cmd.Dir = homeDir
+	// New sessions start the user's shell as a login shell
+	cmd := sysUser.command("tmux", "new-session", "-A", "-s", sessionName, sysUser.Shell, "-l")
 
-	ptmx, err := pty.Start(cmd)
+	ptmx, err := sysUser.start(cmd)
 	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte("Error starting PTY: "+err.Error()))
+		conn.WriteMessage(websocket.TextMessage, formatPTYControl(protocol, &ptyControl{Type: ptyMsgError, Error: "Error starting PTY: " + err.Error()}))
 		return
 	}
+	defer cmd.Wait() // reap the tmux client once the PTY is closed
 	defer ptmx.Close()
 
+	// Optional asciicast recording of the session
+	initRows, initCols, _ := pty.Getsize(ptmx)
+	recorder, err := startPTYRecording(username, sysUser.Shell, uint16(initCols), uint16(initRows))
+	if err != nil {
+		fmt.Printf("[PTY] Recording disabled for %s: %v\n", username, err)
+	}
+	defer recorder.Close()
+
+	// Make the session available for sharing with other users
+	session := registerPTYSession(username, ptmx, recorder, func() {
+		// Full repaint of every client on the session, run as the user
+		// rather than typed into the PTY
+		clients, err := sysUser.command("tmux", "list-clients", "-t", sessionName, "-F", "#{client_tty}").Output()
+		if err != nil {
+			return
+		}
+		for _, tty := range strings.Fields(string(clients)) {
+			sysUser.command("tmux", "refresh-client", "-t", tty).Run()
+		}
+	})
+	defer unregisterPTYSession(session)
+
 	// Track if user explicitly closed the window
-	userClosed := false
+	var userClosed atomic.Bool
 
 	// IPC directory setup
 	ipcDir := homeDir + "/.algo"
-	ipcInFile := ipcDir + "/in"
-	ipcOutFile := ipcDir + "/out"
-	os.MkdirAll(ipcDir, 0755)
 
-	// Create empty IPC files if they don't exist
-	if _, err := os.Stat(ipcInFile); os.IsNotExist(err) {
-		os.WriteFile(ipcInFile, []byte{}, 0644)
+	// Create empty IPC files if they don't exist. The shell owns ~/.algo, so
+	// the IPC files are only opened through openInHome, which won't follow
+	// links the user put there.
+	for _, name := range []string{legacyIPCIn, legacyIPCOut} {
+		if f, err := sysUser.openInHome(name, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			f.Close()
+		}
 	}
-	if _, err := os.Stat(ipcOutFile); os.IsNotExist(err) {
-		os.WriteFile(ipcOutFile, []byte{}, 0644)
+	sysUser.own(ipcDir)
+
+	// Shell-side IPC socket (~/.algo/ipc.sock) for named channels
+	if err := getIPCHub(username).Listen(sysUser, ipcDir); err != nil {
+		fmt.Printf("[IPC] Could not open socket for %s: %v\n", username, err)
 	}
 
 	// Register browser connection for MCP routing
-	browserConn := registerBrowserConn(username, conn)
-	defer unregisterBrowserConn(username)
+	browserConn := registerBrowserConn(username, "pty", conn, r)
+	defer unregisterBrowserConn(browserConn)
+
+	// Keepalive: ping the browser and drop the socket if pongs stop arriving,
+	// so dead tabs don't hold the PTY open until TCP notices
+	var lastInput atomic.Int64
+	lastInput.Store(time.Now().UnixNano())
+	conn.SetReadDeadline(time.Now().Add(ptyPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(ptyPongWait))
+		return nil
+	})
+	stopKeepalive := make(chan struct{})
+	defer close(stopKeepalive)
+	go func() {
+		ticker := time.NewTicker(ptyPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopKeepalive:
+				return
+			case <-ticker.C:
+			}
+			idle := time.Since(time.Unix(0, lastInput.Load()))
+			if config.PTYIdleTimeout > 0 && idle > config.PTYIdleTimeout {
+				// Idle detach - the tmux session keeps running
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "idle timeout"),
+					time.Now().Add(time.Second))
+				conn.Close()
+				return
+			}
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
 
-	// Handle PTY resize messages and input
+	// Handle PTY input and control messages
 	go func() {
 		for {
 			msgType, msg, err := conn.ReadMessage()
 			if err != nil {
+				// Socket gone (closed, dead tab, idle timeout): stop our tmux
+				// client so the PTY read loop below ends too. Killing the client
+				// detaches it without typing into the session.
+				if !userClosed.Load() {
+					cmd.Process.Kill()
+				}
 				return
 			}
-			if msgType == websocket.TextMessage {
-				msgStr := string(msg)
-				// Check for resize message
-				if strings.HasPrefix(msgStr, "RESIZE:") {
-					var cols, rows uint16
-					fmt.Sscanf(msgStr, "RESIZE:%d:%d", &cols, &rows)
-					if cols > 0 && rows > 0 {
-						pty.Setsize(ptmx, &pty.Winsize{Cols: cols, Rows: rows})
-					}
-					continue
-				}
-				// Check for explicit close message
-				if msgStr == "CLOSE_SESSION" {
-					userClosed = true
-					// Kill the tmux session
-					exec.Command("tmux", "kill-session", "-t", sessionName).Run()
-					return
+			conn.SetReadDeadline(time.Now().Add(ptyPongWait))
+			lastInput.Store(time.Now().UnixNano())
+			input, ctl, err := parsePTYFrame(protocol, msgType, msg)
+			if err != nil {
+				browserConn.send(formatPTYControl(protocol, &ptyControl{Type: ptyMsgError, Error: err.Error()}))
+				continue
+			}
+			if ctl == nil {
+				// Write to PTY
+				session.Input(input)
+				continue
+			}
+
+			switch ctl.Type {
+			case ptyMsgResize:
+				if ctl.Cols > 0 && ctl.Rows > 0 {
+					pty.Setsize(ptmx, &pty.Winsize{Cols: ctl.Cols, Rows: ctl.Rows})
+					recorder.Resize(ctl.Cols, ctl.Rows)
 				}
+
+			case ptyMsgCloseSession:
+				// Explicit close - kill the tmux session
+				userClosed.Store(true)
+				sysUser.command("tmux", "kill-session", "-t", sessionName).Run()
+				return
+
+			case ptyMsgIPCRead:
 				// IPC read: read ~/.algo/in, clear it, return content
-				if msgStr == "IPC_READ" {
-					ipcDir := homeDir + "/.algo"
-					inFile := ipcDir + "/in"
-
-					// Ensure directory exists
-					os.MkdirAll(ipcDir, 0755)
-
-					// Read and clear the input file atomically
-					content, err := os.ReadFile(inFile)
-					if err == nil && len(content) > 0 {
-						// Clear the file
-						os.WriteFile(inFile, []byte{}, 0644)
-						// Send response
-						conn.WriteMessage(websocket.TextMessage, []byte("IPC_RESPONSE:"+string(content)))
-					} else {
-						// Empty or no file
-						conn.WriteMessage(websocket.TextMessage, []byte("IPC_RESPONSE:"))
+				var content []byte
+				if f, err := sysUser.openInHome(legacyIPCIn, os.O_RDWR, 0); err == nil {
+					content, _ = io.ReadAll(f)
+					if len(content) > 0 {
+						f.Truncate(0)
 					}
-					continue
+					f.Close()
 				}
+				browserConn.send(formatPTYControl(protocol, &ptyControl{Type: ptyMsgIPCResponse, ID: ctl.ID, Data: string(content)}))
+
+			case ptyMsgIPCWrite:
 				// IPC write: append to ~/.algo/out
-				if strings.HasPrefix(msgStr, "IPC_WRITE:") {
-					ipcDir := homeDir + "/.algo"
-					outFile := ipcDir + "/out"
-
-					// Ensure directory exists
-					os.MkdirAll(ipcDir, 0755)
-
-					// Append content
-					content := msgStr[10:] // Skip "IPC_WRITE:"
-					f, err := os.OpenFile(outFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-					if err == nil {
-						f.WriteString(content + "\n")
-						f.Close()
-					}
-					continue
+				f, err := sysUser.openInHome(legacyIPCOut, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err == nil {
+					f.WriteString(ctl.Data + "\n")
+					f.Close()
 				}
+				publishLegacyIPCWrite(username, ctl.Data)
+
+			case ptyMsgMCPResponse:
 				// MCP response from browser
-				if strings.HasPrefix(msgStr, "MCP_RESP:") {
-					// Format: MCP_RESP:reqId:jsonResult
-					parts := strings.SplitN(msgStr[9:], ":", 2)
-					if len(parts) == 2 {
-						browserConn.HandleResponse(parts[0], parts[1])
-					}
-					continue
+				if ctl.ID != "" {
+					browserConn.HandleResponse(ctl.ID, string(ctl.Result))
 				}
+
+			case ptyMsgEyeResponse:
 				// Eye response from browser (direct bridge)
-				if strings.HasPrefix(msgStr, "EYE:") {
-					// Format: EYE:id:result or EYE:id!:error
-					// Forward directly to connected Claude instances
-					sendEyeResponse(username, msgStr[4:])
-					continue
-				}
+				// Forward to connected Claude instances as id:result or id!:error
+				sendEyeResponse(browserConn, ctl.eyeWireResponse())
+
+			default:
+				browserConn.send(formatPTYControl(protocol, &ptyControl{Type: ptyMsgError, ID: ctl.ID, Error: "unknown control message: " + ctl.Type}))
 			}
-			// Write to PTY
-			ptmx.Write(msg)
 		}
 	}()
 
@@ -808,16 +1281,20 @@ func handlePTY(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			break
 		}
-		if err := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+		recorder.Output(buf[:n])
+		session.Broadcast(buf[:n])
+		// The writer keeps the frame, so it gets its own copy
+		out := append([]byte(nil), buf[:n]...)
+		if err := browserConn.out.Send(context.Background(), websocket.BinaryMessage, out); err != nil {
 			break
 		}
 	}
 
-	// If user didn't explicitly close, detach cleanly (session persists)
+	// If user didn't explicitly close, detach (session persists): ending
+	// this tmux client leaves the session and the user's other clients alone.
 	// If user closed, session was already killed above
-	if !userClosed {
-		// Detach from tmux (Ctrl+B, D) - session continues running
-		ptmx.Write([]byte{0x02, 0x64}) // tmux detach sequence
+	if !userClosed.Load() {
+		cmd.Process.Kill()
 	}
 }
 
@@ -825,6 +1302,11 @@ func handlePTY(w http.ResponseWriter, r *http.Request) {
 // Protocol:
 //   - "expression"     -> fire and forget (no response)
 //   - "id:expression"  -> request with ID, expects "id:result" or "id!:error"
+//
+// ?session= picks the browser connection (see /api/eye/sessions): a connection
+// ID, or "all" to broadcast. By default commands go to the focused tab. A
+// broadcast request gets one reply: the first tab's result or error.
+// Lines starting with "@" manage event subscriptions (see eye_events.go).
 func handleEye(w http.ResponseWriter, r *http.Request) {
 	// Get token from query string
 	token := r.URL.Query().Get("token")
@@ -854,15 +1336,41 @@ func handleEye(w http.ResponseWriter, r *http.Request) {
 	eyeConn := registerEyeConn(username, conn)
 	defer unregisterEyeConn(username, eyeConn)
 
-	// Get browser connection
-	bc := getBrowserConn(username)
-	if bc == nil {
-		conn.WriteMessage(websocket.TextMessage, []byte("!:No browser connected"))
+	// Clients may shorten the reply deadline (?timeout=10s) and lower the
+	// in-flight cap (?max_inflight=4), but not raise them
+	if d, err := time.ParseDuration(r.URL.Query().Get("timeout")); err == nil && d > 0 && d < eyeConn.Timeout {
+		eyeConn.Timeout = d
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("max_inflight")); err == nil && n > 0 && (eyeConn.MaxInFlight <= 0 || n < eyeConn.MaxInFlight) {
+		eyeConn.MaxInFlight = n
+	}
+
+	// Check there is a browser to talk to; the target is resolved per message
+	// so commands follow focus and survive tab reloads
+	target := r.URL.Query().Get("session")
+	eyeConn.Target = target
+	if len(findBrowserConns(username, target, false)) == 0 {
+		eyeConn.write("!:No browser connected")
 		return
 	}
 
+	// Optional recording for `eye replay`
+	if config.EyeRecord || r.URL.Query().Get("record") == "1" {
+		rec, err := startEyeRecording(username, target)
+		if err != nil {
+			fmt.Printf("[Eye] Recording disabled for %s: %v\n", username, err)
+		}
+		eyeConn.recorder = rec
+		defer rec.Close()
+	}
+
 	// Send ready message
-	conn.WriteMessage(websocket.TextMessage, []byte(":ready"))
+	eyeConn.write(":ready")
+	go eyeConn.pumpEyeEvents()
+	if eyeConn.recorder != nil && r.URL.Query().Get("record") == "1" {
+		data, _ := json.Marshal(map[string]string{"path": eyeConn.recorder.Path})
+		eyeConn.write("@recording " + string(data))
+	}
 
 	// Read messages from Claude and forward to browser
 	for {
@@ -876,6 +1384,12 @@ func handleEye(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		// Event subscriptions: "@sub topic [filter]", "@unsub ...", "@subs"
+		if strings.HasPrefix(msgStr, "@") {
+			handleEyeControl(eyeConn, msgStr)
+			continue
+		}
+
 		// Parse: "expression" (fire) or "id:expression" (request)
 		// If first char is letter/number and contains ':', it's id:expression
 		var id, expression string
@@ -901,11 +1415,31 @@ func handleEye(w http.ResponseWriter, r *http.Request) {
 
 		// Send to browser
 		// Format: EYE_CMD:id:expression (id may be empty for fire-and-forget)
-		cmdMsg := fmt.Sprintf("EYE_CMD:%s:%s", id, expression)
-		err = bc.Conn.WriteMessage(websocket.TextMessage, []byte(cmdMsg))
-		if err != nil {
-			conn.WriteMessage(websocket.TextMessage, []byte(id+"!:Browser disconnected"))
-			break
+		eyeConn.recorder.Send(id, expression)
+		targets := findBrowserConns(username, target, false)
+		if len(targets) == 0 {
+			eyeConn.reply(id, "!:Browser disconnected")
+			continue
+		}
+
+		// The browser sees a server-unique ID; sendEyeResponse maps it back
+		wireID := id
+		if id != "" {
+			wireID, err = trackEyeRequest(&eyeRequest{ID: id, Username: username, Requester: eyeConn, browsers: targets}, eyeConn.Timeout)
+			if err != nil {
+				eyeConn.reply(id, "!:"+err.Error())
+				continue
+			}
+		}
+		for _, bc := range targets {
+			cmdMsg := formatPTYControl(bc.Protocol, &ptyControl{Type: ptyMsgEyeCommand, ID: wireID, Expr: expression})
+			if err := bc.send(cmdMsg); err != nil && len(targets) == 1 {
+				if id != "" {
+					failEyeRequest(wireID, "browser disconnected")
+				} else {
+					eyeConn.write("!:browser disconnected")
+				}
+			}
 		}
 	}
 }
@@ -938,189 +1472,59 @@ func handleEyeBridge(w http.ResponseWriter, r *http.Request) {
 	defer conn.Close()
 
 	// Register as browser connection for eye commands
-	browserConn := registerBrowserConn(username, conn)
-	defer unregisterBrowserConn(username)
+	browserConn := registerBrowserConn(username, "bridge", conn, r)
+	defer unregisterBrowserConn(browserConn)
 
-	// Send ready message
-	conn.WriteMessage(websocket.TextMessage, []byte("EYE_BRIDGE:ready"))
+	// Send ready message, then the hooks eye clients are subscribed to
+	browserConn.send([]byte("EYE_BRIDGE:ready"))
+	browserConn.send(eyeSubscriptionSummary(username))
 
 	// Read messages from browser
 	for {
-		_, msg, err := conn.ReadMessage()
+		msgType, msg, err := conn.ReadMessage()
 		if err != nil {
 			break
 		}
 
+		// Binary frames carry capture data: "<id>\n<bytes>"
+		if msgType == websocket.BinaryMessage {
+			handleCaptureChunk(browserConn, msg)
+			continue
+		}
+
 		msgStr := string(msg)
 
 		// Eye response from browser (direct bridge)
 		// Format: EYE:id:result or EYE:id!:error
 		if strings.HasPrefix(msgStr, "EYE:") {
-			sendEyeResponse(username, msgStr[4:])
+			sendEyeResponse(browserConn, msgStr[4:])
 			continue
 		}
 
 		// Ping/pong for keepalive
 		if msgStr == "ping" {
-			conn.WriteMessage(websocket.TextMessage, []byte("pong"))
+			browserConn.send([]byte("pong"))
 			continue
 		}
-	}
 
-	_ = browserConn // Silence unused variable warning
-}
+		// Capture finished (see eye_capture.go)
+		if strings.HasPrefix(msgStr, "EYE_CAPTURE_END:") {
+			handleCaptureEnd(browserConn, msgStr[16:])
+			continue
+		}
 
-// Content bridge - connects browser extension and Clean View instances
-var (
-	extensionConn    *websocket.Conn
-	extensionConnMu  sync.RWMutex
-	browserConns     = make(map[*websocket.Conn]bool)
-	browserConnsMu   sync.RWMutex
-	pendingResponses = make(map[string]*websocket.Conn) // request ID -> requesting browser conn
-	pendingMu        sync.Mutex
-)
+		// Subscribed event from the browser
+		// Format: EYE_EVENT:{"topic":...,"match":...,"data":...}
+		if strings.HasPrefix(msgStr, "EYE_EVENT:") {
+			publishEyeEvent(browserConn, msgStr[10:])
+			continue
+		}
 
-// Handle content bridge WebSocket - serves both extension and browsers
-func handleContentBridge(w http.ResponseWriter, r *http.Request) {
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		return
-	}
-	defer conn.Close()
-
-	// Check if this is the extension (query param) or a browser
-	isExtension := r.URL.Query().Get("ext") == "1"
-
-	if isExtension {
-		extensionConnMu.Lock()
-		extensionConn = conn
-		extensionConnMu.Unlock()
-
-		defer func() {
-			extensionConnMu.Lock()
-			if extensionConn == conn {
-				extensionConn = nil
-			}
-			extensionConnMu.Unlock()
-		}()
-
-		fmt.Println("[ContentBridge] Extension connected")
-
-		// Read messages from extension
-		for {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				break
-			}
-
-			var data map[string]interface{}
-			if err := json.Unmarshal(msg, &data); err != nil {
-				continue
-			}
-
-			// Forward responses back to requesting browser
-			if id, ok := data["id"].(string); ok {
-				fmt.Printf("[ContentBridge] Extension response for id=%s\n", id)
-				pendingMu.Lock()
-				browserConn, exists := pendingResponses[id]
-				if exists {
-					delete(pendingResponses, id)
-				}
-				pendingMu.Unlock()
-
-				if exists && browserConn != nil {
-					fmt.Printf("[ContentBridge] Forwarding response to browser\n")
-					browserConn.WriteMessage(websocket.TextMessage, msg)
-				} else {
-					fmt.Printf("[ContentBridge] No pending request found for id=%s (exists=%v)\n", id, exists)
-				}
-			}
-
-			// Handle tabList - could broadcast to all browsers
-			if action, ok := data["action"].(string); ok {
-				if action == "tabList" {
-					if tabs, ok := data["tabs"].([]interface{}); ok {
-						fmt.Printf("[ContentBridge] Got %d tabs\n", len(tabs))
-					}
-				}
-			}
-		}
-
-		fmt.Println("[ContentBridge] Extension disconnected")
-	} else {
-		// This is a browser (Clean View)
-		browserConnsMu.Lock()
-		browserConns[conn] = true
-		browserConnsMu.Unlock()
-
-		defer func() {
-			browserConnsMu.Lock()
-			delete(browserConns, conn)
-			browserConnsMu.Unlock()
-		}()
-
-		fmt.Println("[ContentBridge] Browser connected")
-
-		// Read messages from browser and forward to extension
-		for {
-			_, msg, err := conn.ReadMessage()
-			if err != nil {
-				break
-			}
-
-			var data map[string]interface{}
-			if err := json.Unmarshal(msg, &data); err != nil {
-				continue
-			}
-
-			// Handle ping locally
-			if action, ok := data["action"].(string); ok && action == "ping" {
-				if id, ok := data["id"].(string); ok {
-					conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"`+id+`","result":"pong"}`))
-				}
-				continue
-			}
-
-			// Forward request to extension
-			extensionConnMu.RLock()
-			ext := extensionConn
-			extensionConnMu.RUnlock()
-
-			action, _ := data["action"].(string)
-			reqId, _ := data["id"].(string)
-			fmt.Printf("[ContentBridge] Browser request: action=%s id=%s\n", action, reqId)
-
-			if ext == nil {
-				// No extension connected, send error
-				if id, ok := data["id"].(string); ok {
-					errResp := fmt.Sprintf(`{"id":"%s","error":"Extension not connected"}`, id)
-					conn.WriteMessage(websocket.TextMessage, []byte(errResp))
-				}
-				fmt.Println("[ContentBridge] No extension connected!")
-				continue
-			}
-
-			// Track pending request
-			if id, ok := data["id"].(string); ok {
-				pendingMu.Lock()
-				pendingResponses[id] = conn
-				pendingMu.Unlock()
-				fmt.Printf("[ContentBridge] Tracking pending request: %s\n", id)
-			}
-
-			// Forward to extension
-			truncLen := 200
-			if len(msg) < truncLen {
-				truncLen = len(msg)
-			}
-			fmt.Printf("[ContentBridge] Forwarding to extension: %s\n", string(msg)[:truncLen])
-			ext.WriteMessage(websocket.TextMessage, msg)
-		}
-
-		fmt.Println("[ContentBridge] Browser disconnected")
+		// Tab focus changes: FOCUS:1 or FOCUS:0
+		if strings.HasPrefix(msgStr, "FOCUS:") {
+			setPageFocus(username, browserConn.Page, msgStr == "FOCUS:1")
+			continue
+		}
 	}
 }
 
@@ -1166,65 +1570,26 @@ func handleTerminalExec(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	homeDir := filepath.Join(config.HomesDir, username)
+	homeDir := userHomeDir(username)
 	os.MkdirAll(homeDir, 0755)
 
 	// Determine working directory
-	workDir := homeDir
-	if req.Cwd != "" && req.Cwd != "~" {
-		// Expand ~ to home dir
-		cwd := req.Cwd
-		if strings.HasPrefix(cwd, "~/") {
-			cwd = filepath.Join(homeDir, cwd[2:])
-		} else if cwd == "~" {
-			cwd = homeDir
-		} else if !filepath.IsAbs(cwd) {
-			cwd = filepath.Join(homeDir, cwd)
-		}
-		// Resolve and validate path is within home
-		resolved, err := filepath.Abs(cwd)
-		if err == nil && strings.HasPrefix(resolved, homeDir) {
-			if info, err := os.Stat(resolved); err == nil && info.IsDir() {
-				workDir = resolved
-			}
-		}
-	}
+	workDir := execWorkDir(homeDir, req.Cwd)
 
 	parts := strings.Fields(command)
 	baseCmd := parts[0]
 
 	// Handle cd specially - validate and return new cwd
 	if baseCmd == "cd" {
-		newDir := homeDir
+		target := ""
 		if len(parts) > 1 {
-			target := parts[1]
-			if target == "~" || target == "" {
-				newDir = homeDir
-			} else if target == ".." {
-				newDir = filepath.Dir(workDir)
-			} else if strings.HasPrefix(target, "~/") {
-				newDir = filepath.Join(homeDir, target[2:])
-			} else if filepath.IsAbs(target) {
-				newDir = target
-			} else {
-				newDir = filepath.Join(workDir, target)
-			}
-		}
-		// Resolve and validate
-		resolved, err := filepath.Abs(newDir)
-		if err != nil || !strings.HasPrefix(resolved, homeDir) {
-			jsonResponse(w, map[string]interface{}{"error": "Access denied", "cwd": workDir}, 403)
-			return
+			target = parts[1]
 		}
-		if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
-			jsonResponse(w, map[string]interface{}{"error": "Not a directory", "cwd": workDir}, 400)
+		displayPath, err := execCd(homeDir, workDir, target)
+		if ae, ok := err.(*apiError); ok {
+			jsonResponse(w, map[string]interface{}{"error": ae.msg, "cwd": workDir}, ae.status)
 			return
 		}
-		// Return display path with ~ prefix
-		displayPath := strings.Replace(resolved, homeDir, "~", 1)
-		if displayPath == "" {
-			displayPath = "~"
-		}
 		jsonResponse(w, map[string]interface{}{"output": "", "cwd": displayPath}, 200)
 		return
 	}
@@ -1261,48 +1626,11 @@ func handleFileList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	homeDir := filepath.Join(config.HomesDir, username)
-	path := r.URL.Query().Get("path")
-	if path == "" || path == "~" {
-		path = homeDir
-	} else if strings.HasPrefix(path, "~") {
-		path = filepath.Join(homeDir, path[1:])
-	}
-
-	resolved, err := filepath.Abs(path)
-	if err != nil || !strings.HasPrefix(resolved, homeDir) {
-		jsonResponse(w, map[string]string{"error": "Access denied"}, 403)
-		return
-	}
-
-	entries, err := os.ReadDir(resolved)
+	displayPath, files, err := listHomeDir(username, r.URL.Query().Get("path"))
 	if err != nil {
-		jsonResponse(w, map[string]string{"error": "Not a directory"}, 400)
+		apiErrorResponse(w, err)
 		return
 	}
-
-	var files []map[string]interface{}
-	for _, entry := range entries {
-		info, _ := entry.Info()
-		fileType := "file"
-		// Check if it's a directory (or symlink to directory)
-		fullPath := filepath.Join(resolved, entry.Name())
-		if stat, err := os.Stat(fullPath); err == nil && stat.IsDir() {
-			fileType = "directory"
-		}
-		size := int64(0)
-		if fileType != "directory" {
-			size = info.Size()
-		}
-		files = append(files, map[string]interface{}{
-			"name":     entry.Name(),
-			"type":     fileType,
-			"size":     size,
-			"modified": info.ModTime().Unix(),
-		})
-	}
-
-	displayPath := strings.Replace(resolved, homeDir, "~", 1)
 	jsonResponse(w, map[string]interface{}{
 		"path":  displayPath,
 		"files": files,
@@ -1327,38 +1655,11 @@ func handleFileSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Path == "" {
-		jsonResponse(w, map[string]string{"error": "Path required"}, 400)
-		return
-	}
-
-	homeDir := filepath.Join(config.HomesDir, username)
-	os.MkdirAll(homeDir, 0755)
-
-	// Resolve path
-	filePath := req.Path
-	if strings.HasPrefix(filePath, "~/") {
-		filePath = filepath.Join(homeDir, filePath[2:])
-	} else if !filepath.IsAbs(filePath) {
-		filePath = filepath.Join(homeDir, filePath)
-	}
-
-	resolved, err := filepath.Abs(filePath)
-	if err != nil || !strings.HasPrefix(resolved, homeDir) {
-		jsonResponse(w, map[string]string{"error": "Access denied"}, 403)
-		return
-	}
-
-	// Create parent directory if needed
-	os.MkdirAll(filepath.Dir(resolved), 0755)
-
-	// Write file
-	if err := os.WriteFile(resolved, []byte(req.Content), 0644); err != nil {
-		jsonResponse(w, map[string]string{"error": "Failed to save file"}, 500)
+	displayPath, err := saveHomeFile(username, req.Path, req.Content)
+	if err != nil {
+		apiErrorResponse(w, err)
 		return
 	}
-
-	displayPath := strings.Replace(resolved, homeDir, "~", 1)
 	jsonResponse(w, map[string]interface{}{
 		"success": true,
 		"path":    displayPath,
@@ -1372,42 +1673,23 @@ func handleFileGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	path := r.URL.Query().Get("path")
-	if path == "" {
-		jsonResponse(w, map[string]string{"error": "Path required"}, 400)
+	file, err := readHomeFile(username, r.URL.Query().Get("path"))
+	if err != nil {
+		apiErrorResponse(w, err)
 		return
 	}
-
-	homeDir := filepath.Join(config.HomesDir, username)
-
-	// Resolve path
-	filePath := path
-	if strings.HasPrefix(filePath, "~/") {
-		filePath = filepath.Join(homeDir, filePath[2:])
-	} else if !filepath.IsAbs(filePath) {
-		filePath = filepath.Join(homeDir, filePath)
-	}
-
-	resolved, err := filepath.Abs(filePath)
-	if err != nil || !strings.HasPrefix(resolved, homeDir) {
-		jsonResponse(w, map[string]string{"error": "Access denied"}, 403)
-		return
+	resp := map[string]interface{}{
+		"path":     file.Path,
+		"content":  string(file.Content),
+		"size":     file.Size,
+		"modified": file.Modified,
 	}
-
-	content, err := os.ReadFile(resolved)
-	if err != nil {
-		jsonResponse(w, map[string]string{"error": "File not found"}, 404)
-		return
+	// ?encoding=base64 returns binary files intact
+	if r.URL.Query().Get("encoding") == "base64" {
+		resp["content"] = base64.StdEncoding.EncodeToString(file.Content)
+		resp["encoding"] = "base64"
 	}
-
-	info, _ := os.Stat(resolved)
-	displayPath := strings.Replace(resolved, homeDir, "~", 1)
-	jsonResponse(w, map[string]interface{}{
-		"path":     displayPath,
-		"content":  string(content),
-		"size":     info.Size(),
-		"modified": info.ModTime().Unix(),
-	}, 200)
+	jsonResponse(w, resp, 200)
 }
 
 func handleFileDelete(w http.ResponseWriter, r *http.Request) {
@@ -1543,6 +1825,10 @@ func serveOS(w http.ResponseWriter, r *http.Request) {
 func main() {
 	mux := http.NewServeMux()
 
+	// Background maintenance
+	go runRecordingRetention()
+	go runPTYReaper()
+
 	// API routes
 	mux.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "OPTIONS" {
@@ -1588,11 +1874,139 @@ func main() {
 		handlePTY(w, r)
 	})
 
+	// Shared PTY sessions: list, share, revoke, and viewer attach
+	mux.HandleFunc("/api/pty/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handlePTYSessions(w, r)
+	})
+
+	mux.HandleFunc("/api/pty/share", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handlePTYShare(w, r)
+	})
+
+	mux.HandleFunc("/api/pty/revoke", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handlePTYRevoke(w, r)
+	})
+
+	mux.HandleFunc("/api/pty/join", func(w http.ResponseWriter, r *http.Request) {
+		handlePTYJoin(w, r)
+	})
+
+	// Admin: tmux sessions across users and recently reaped ones
+	mux.HandleFunc("/api/admin/pty-sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleAdminPTYSessions(w, r)
+	})
+
+	// Admin: browser and eye socket send queues and pending requests
+	mux.HandleFunc("/api/admin/bridge-metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleAdminBridgeMetrics(w, r)
+	})
+
+	// IPC channels between the user's shell and browser
+	mux.HandleFunc("/api/ipc", func(w http.ResponseWriter, r *http.Request) {
+		handleIPC(w, r)
+	})
+
+	mux.HandleFunc("/api/ipc/publish", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleIPCPublish(w, r)
+	})
+
+	mux.HandleFunc("/api/ipc/channels", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleIPCChannels(w, r)
+	})
+
+	// PTY session recordings (asciicast v2)
+	mux.HandleFunc("/api/recordings/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleRecordingList(w, r)
+	})
+
+	mux.HandleFunc("/api/recordings/get", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleRecordingGet(w, r)
+	})
+
+	mux.HandleFunc("/api/recordings/delete", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleRecordingDelete(w, r)
+	})
+
+	mux.HandleFunc("/api/recordings/replay", func(w http.ResponseWriter, r *http.Request) {
+		handleRecordingReplay(w, r)
+	})
+
 	// Eye bridge: Direct AI-to-browser WebSocket (for Claude/eye CLI)
 	mux.HandleFunc("/api/eye", func(w http.ResponseWriter, r *http.Request) {
 		handleEye(w, r)
 	})
 
+	// Eye bridge: Browser connections available as ?session= targets
+	mux.HandleFunc("/api/eye/sessions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleBrowserSessions(w, r)
+	})
+
 	// Eye bridge: Browser-side connection (auto-connects on page load)
 	mux.HandleFunc("/api/eye-bridge", func(w http.ResponseWriter, r *http.Request) {
 		handleEyeBridge(w, r)
@@ -1603,6 +2017,26 @@ func main() {
 		handleContentBridge(w, r)
 	})
 
+	mux.HandleFunc("/api/content-bridge/tabs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleContentTabs(w, r)
+	})
+
+	mux.HandleFunc("/api/content-bridge/call", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			return
+		}
+		handleContentCall(w, r)
+	})
+
 	mux.HandleFunc("/api/terminal/exec", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "OPTIONS" {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -1664,49 +2098,8 @@ func main() {
 	})
 
 	// Tickets API
-	mux.HandleFunc("/api/tickets", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Content-Type", "application/json")
-
-		if r.Method == "OPTIONS" {
-			return
-		}
-
-		if r.Method == "POST" {
-			var req struct {
-				Title       string `json:"title"`
-				Description string `json:"description"`
-			}
-			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Title == "" {
-				http.Error(w, `{"error":"title required"}`, 400)
-				return
-			}
-			ticketMutex.Lock()
-			ticketID++
-			t := Ticket{
-				ID:          fmt.Sprintf("T%d", ticketID),
-				Title:       req.Title,
-				Description: req.Description,
-				Status:      "open",
-				Created:     time.Now().Format("2006-01-02 15:04"),
-			}
-			tickets = append(tickets, t)
-			ticketMutex.Unlock()
-			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "ticket": t})
-			return
-		}
-
-		// GET - return tickets
-		ticketMutex.RLock()
-		result := tickets
-		if result == nil {
-			result = []Ticket{}
-		}
-		ticketMutex.RUnlock()
-		json.NewEncoder(w).Encode(map[string]interface{}{"tickets": result})
-	})
+	mux.HandleFunc("/api/tickets", handleTickets)
+	mux.HandleFunc("/api/tickets/", handleTicket)
 
 	// Serve system apps list
 	mux.HandleFunc("/api/system-apps", func(w http.ResponseWriter, r *http.Request) {
@@ -1844,271 +2237,8 @@ func main() {
 		io.Copy(w, resp.Body)
 	})
 
-	// MCP (Model Context Protocol) endpoint for Claude Code integration
-	mux.HandleFunc("/api/mcp", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", 405)
-			return
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-
-		// Authenticate via Bearer token
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": "Authorization required. Use: Authorization: Bearer <session_token>",
-			})
-			return
-		}
-		tokenUser := verifyToken(auth[7:])
-		if tokenUser == "" {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": "Invalid or expired token",
-			})
-			return
-		}
-
-		// Parse MCP request
-		var req struct {
-			Method string                 `json:"method"`
-			Params map[string]interface{} `json:"params"`
-			User   string                 `json:"user"` // Target user's browser session (optional, defaults to token user)
-		}
-
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": "Invalid request body",
-			})
-			return
-		}
-
-		// Use token user if not specified, or allow targeting another user's session
-		// (In future, could add permission checks for cross-user access)
-		if req.User == "" {
-			req.User = tokenUser
-		}
-
-		// Handle MCP methods
-		switch req.Method {
-		case "tools/list":
-			// Return available tools
-			tools := []map[string]interface{}{
-				{
-					"name":        "algo_eval",
-					"description": "Execute JavaScript code in the browser and return the result",
-					"inputSchema": map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"code": map[string]interface{}{
-								"type":        "string",
-								"description": "JavaScript code to execute",
-							},
-						},
-						"required": []string{"code"},
-					},
-				},
-				{
-					"name":        "algo_getState",
-					"description": "Get the current ALGO OS state including windows, apps, and user info",
-					"inputSchema": map[string]interface{}{
-						"type":       "object",
-						"properties": map[string]interface{}{},
-					},
-				},
-				{
-					"name":        "algo_query",
-					"description": "Query a DOM element using CSS selector",
-					"inputSchema": map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"selector": map[string]interface{}{
-								"type":        "string",
-								"description": "CSS selector",
-							},
-						},
-						"required": []string{"selector"},
-					},
-				},
-				{
-					"name":        "algo_queryAll",
-					"description": "Query all matching DOM elements using CSS selector",
-					"inputSchema": map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"selector": map[string]interface{}{
-								"type":        "string",
-								"description": "CSS selector",
-							},
-						},
-						"required": []string{"selector"},
-					},
-				},
-				{
-					"name":        "algo_click",
-					"description": "Click a DOM element by CSS selector",
-					"inputSchema": map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"selector": map[string]interface{}{
-								"type":        "string",
-								"description": "CSS selector of element to click",
-							},
-						},
-						"required": []string{"selector"},
-					},
-				},
-				{
-					"name":        "algo_setValue",
-					"description": "Set the value of an input element",
-					"inputSchema": map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"selector": map[string]interface{}{
-								"type":        "string",
-								"description": "CSS selector of input element",
-							},
-							"value": map[string]interface{}{
-								"type":        "string",
-								"description": "Value to set",
-							},
-						},
-						"required": []string{"selector", "value"},
-					},
-				},
-				{
-					"name":        "algo_openApp",
-					"description": "Open an application by ID",
-					"inputSchema": map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"appId": map[string]interface{}{
-								"type":        "string",
-								"description": "Application ID to open",
-							},
-						},
-						"required": []string{"appId"},
-					},
-				},
-				{
-					"name":        "algo_closeWindow",
-					"description": "Close a window by ID",
-					"inputSchema": map[string]interface{}{
-						"type": "object",
-						"properties": map[string]interface{}{
-							"windowId": map[string]interface{}{
-								"type":        "integer",
-								"description": "Window ID to close",
-							},
-						},
-						"required": []string{"windowId"},
-					},
-				},
-			}
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"tools": tools,
-			})
-
-		case "tools/call":
-			// Execute a tool
-			toolName, _ := req.Params["name"].(string)
-			toolArgs, _ := req.Params["arguments"].(map[string]interface{})
-
-			// Get browser connection
-			bc := getBrowserConn(req.User)
-			if bc == nil {
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"error": fmt.Sprintf("No browser session for user %s. Open the Claude app in the browser first.", req.User),
-				})
-				return
-			}
-
-			// Map tool to bridge command
-			var bridgeCmd map[string]interface{}
-			switch toolName {
-			case "algo_eval":
-				bridgeCmd = map[string]interface{}{
-					"_bridge": "eval",
-					"_args":   []interface{}{toolArgs["code"]},
-				}
-			case "algo_getState":
-				bridgeCmd = map[string]interface{}{
-					"_bridge": "getState",
-					"_args":   []interface{}{},
-				}
-			case "algo_query":
-				bridgeCmd = map[string]interface{}{
-					"_bridge": "query",
-					"_args":   []interface{}{toolArgs["selector"]},
-				}
-			case "algo_queryAll":
-				bridgeCmd = map[string]interface{}{
-					"_bridge": "queryAll",
-					"_args":   []interface{}{toolArgs["selector"]},
-				}
-			case "algo_click":
-				bridgeCmd = map[string]interface{}{
-					"_bridge": "click",
-					"_args":   []interface{}{toolArgs["selector"]},
-				}
-			case "algo_setValue":
-				bridgeCmd = map[string]interface{}{
-					"_bridge": "setValue",
-					"_args":   []interface{}{toolArgs["selector"], toolArgs["value"]},
-				}
-			case "algo_openApp":
-				bridgeCmd = map[string]interface{}{
-					"_bridge": "openApp",
-					"_args":   []interface{}{toolArgs["appId"]},
-				}
-			case "algo_closeWindow":
-				bridgeCmd = map[string]interface{}{
-					"_bridge": "closeWindow",
-					"_args":   []interface{}{toolArgs["windowId"]},
-				}
-			default:
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"error": fmt.Sprintf("Unknown tool: %s", toolName),
-				})
-				return
-			}
-
-			// Send to browser and wait for response
-			result, err := bc.SendCommand(bridgeCmd)
-			if err != nil {
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"error": err.Error(),
-				})
-				return
-			}
-
-			// Parse and return result
-			var resultObj interface{}
-			json.Unmarshal([]byte(result), &resultObj)
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"content": []map[string]interface{}{
-					{
-						"type": "text",
-						"text": result,
-					},
-				},
-			})
-
-		default:
-			json.NewEncoder(w).Encode(map[string]interface{}{
-				"error": fmt.Sprintf("Unknown method: %s", req.Method),
-			})
-		}
-	})
+	// MCP (Model Context Protocol) endpoint, see mcp.go
+	mux.HandleFunc("/api/mcp", handleMCP)
 
 	// Serve install script from www folder
 	mux.HandleFunc("/install", func(w http.ResponseWriter, r *http.Request) {
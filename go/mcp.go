@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MCP server: JSON-RPC 2.0 over the Streamable HTTP transport
+//
+//   POST   /api/mcp   a message or a batch. Requests are answered with
+//                     application/json, or text/event-stream when the client
+//                     accepts it and the batch calls a tool; notifications
+//                     and responses alone get 202.
+//   GET    /api/mcp   SSE stream of server notifications for a session
+//   DELETE /api/mcp   end a session
+//
+// initialize starts a session and returns its ID in Mcp-Session-Id, which
+// the client sends on later requests; requests without one are served
+// statelessly. Every request needs the usual Bearer token, and a session
+// only works with its own user's token.
+//
+// Browser tools go to the focused tab unless the endpoint URL has
+// ?session=<id|all> or the call passes a "session" argument, as with eye.
+
+const (
+	mcpSessionIdle = time.Hour
+	mcpMaxBody     = 4 << 20
+	mcpKeepAlive   = 25 * time.Second
+)
+
+// Supported protocol versions, newest first
+var mcpProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcUnauthorized   = -32001
+)
+
+// Log levels for notifications/message, lowest first
+var mcpLogLevels = []string{"debug", "info", "notice", "warning", "error", "critical", "alert", "emergency"}
+
+// rpcMessage is any incoming JSON-RPC message. A request has an ID and a
+// method, a notification only a method, a response an ID and a result or error.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+
+	request *rpcMessage // what this answers, for ordering batches
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// mcpSession is the state kept between requests of one MCP client
+type mcpSession struct {
+	ID         string
+	Username   string
+	Protocol   string
+	lastSeen   time.Time                     // guarded by mcpSessionsMu
+	logLevel   string                        // guarded by mu
+	toolsStamp string                        // user tool manifest last listed; guarded by mu
+	stream     chan []byte                   // open GET stream, nil if none; guarded by mu
+	inflight   map[string]context.CancelFunc // request ID -> cancel; guarded by mu
+	mu         sync.Mutex
+}
+
+var (
+	mcpSessions   = make(map[string]*mcpSession) // session ID -> session
+	mcpSessionsMu sync.Mutex
+)
+
+func rpcErr(code int, format string, args ...interface{}) *rpcError {
+	return &rpcError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// newMCPSession starts a session, dropping ones idle for mcpSessionIdle
+func newMCPSession(username, protocol string) *mcpSession {
+	s := &mcpSession{
+		ID:         randomHex(16),
+		Username:   username,
+		Protocol:   protocol,
+		lastSeen:   time.Now(),
+		logLevel:   "info",
+		toolsStamp: userToolsStamp(username),
+		inflight:   make(map[string]context.CancelFunc),
+	}
+	mcpSessionsMu.Lock()
+	var idle []*mcpSession
+	for id, other := range mcpSessions {
+		if time.Since(other.lastSeen) > mcpSessionIdle {
+			delete(mcpSessions, id)
+			idle = append(idle, other)
+		}
+	}
+	mcpSessions[s.ID] = s
+	mcpSessionsMu.Unlock()
+
+	for _, other := range idle {
+		other.close()
+	}
+	return s
+}
+
+// getMCPSession returns a live session belonging to username
+func getMCPSession(id, username string) *mcpSession {
+	mcpSessionsMu.Lock()
+	defer mcpSessionsMu.Unlock()
+	s := mcpSessions[id]
+	if s == nil || s.Username != username {
+		return nil
+	}
+	s.lastSeen = time.Now()
+	return s
+}
+
+func endMCPSession(s *mcpSession) {
+	mcpSessionsMu.Lock()
+	delete(mcpSessions, s.ID)
+	mcpSessionsMu.Unlock()
+	s.close()
+}
+
+// close ends the session's stream and cancels its requests
+func (s *mcpSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream != nil {
+		close(s.stream)
+		s.stream = nil
+	}
+	for _, cancel := range s.inflight {
+		cancel()
+	}
+}
+
+// notify queues a notification on the session's GET stream, if one is
+// open. Notifications are dropped when the client falls behind.
+func (s *mcpSession) notify(method string, params interface{}) {
+	data, _ := json.Marshal(rpcNotification{JSONRPC: "2.0", Method: method, Params: params})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stream == nil {
+		return
+	}
+	select {
+	case s.stream <- data:
+	default:
+	}
+}
+
+// mcpLog sends notifications/message to the user's sessions that log at
+// level or below
+func mcpLog(username, level string, data interface{}) {
+	mcpSessionsMu.Lock()
+	var targets []*mcpSession
+	for _, s := range mcpSessions {
+		if s.Username == username {
+			targets = append(targets, s)
+		}
+	}
+	mcpSessionsMu.Unlock()
+
+	for _, s := range targets {
+		s.mu.Lock()
+		min := s.logLevel
+		s.mu.Unlock()
+		if logLevelRank(level) >= logLevelRank(min) {
+			s.notify("notifications/message", map[string]interface{}{"level": level, "logger": "algo", "data": data})
+		}
+	}
+}
+
+func logLevelRank(level string) int {
+	for i, l := range mcpLogLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// rpcIDKey normalizes a request ID so "1" and " 1 " match
+func rpcIDKey(id json.RawMessage) string {
+	var buf bytes.Buffer
+	if json.Compact(&buf, id) != nil {
+		return string(id)
+	}
+	return buf.String()
+}
+
+// writeRPCError answers a whole HTTP request with one JSON-RPC error
+func writeRPCError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: code, Message: message}})
+}
+
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func handleMCP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Mcp-Session-Id, Mcp-Protocol-Version")
+	w.Header().Set("Access-Control-Expose-Headers", "Mcp-Session-Id")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	username := requireAuth(r)
+	if username == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="algo"`)
+		writeRPCError(w, 401, rpcUnauthorized, "Authorization required. Use: Authorization: Bearer <session_token>")
+		return
+	}
+
+	var sess *mcpSession
+	if id := r.Header.Get("Mcp-Session-Id"); id != "" {
+		if sess = getMCPSession(id, username); sess == nil {
+			writeRPCError(w, 404, rpcInvalidRequest, "Unknown or expired MCP session; initialize again")
+			return
+		}
+	}
+
+	switch r.Method {
+	case "POST":
+		handleMCPPost(w, r, username, sess)
+	case "GET":
+		handleMCPStream(w, r, sess)
+	case "DELETE":
+		if sess == nil {
+			writeRPCError(w, 400, rpcInvalidRequest, "Mcp-Session-Id required")
+			return
+		}
+		endMCPSession(sess)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", 405)
+	}
+}
+
+func handleMCPPost(w http.ResponseWriter, r *http.Request, username string, sess *mcpSession) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, mcpMaxBody+1))
+	if err != nil || len(body) > mcpMaxBody {
+		writeRPCError(w, 413, rpcInvalidRequest, "Request too large")
+		return
+	}
+	body = bytes.TrimSpace(body)
+
+	batch := len(body) > 0 && body[0] == '['
+	var raws []json.RawMessage
+	if batch {
+		if json.Unmarshal(body, &raws) != nil {
+			writeRPCError(w, 400, rpcParseError, "Parse error")
+			return
+		}
+		if len(raws) == 0 {
+			writeRPCError(w, 400, rpcInvalidRequest, "Empty batch")
+			return
+		}
+	} else {
+		raws = []json.RawMessage{body}
+	}
+
+	var requests []*rpcMessage
+	var invalid []*rpcResponse // for messages that can't be handled at all
+	for _, raw := range raws {
+		var m rpcMessage
+		if err := json.Unmarshal(raw, &m); err != nil {
+			if !batch {
+				writeRPCError(w, 400, rpcParseError, "Parse error")
+				return
+			}
+			invalid = append(invalid, &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: rpcErr(rpcInvalidRequest, "Invalid Request")})
+			continue
+		}
+		switch {
+		case m.JSONRPC != "2.0" || m.Method == "" && m.Result == nil && m.Error == nil:
+			id := m.ID
+			if len(id) == 0 {
+				id = json.RawMessage("null")
+			}
+			invalid = append(invalid, &rpcResponse{JSONRPC: "2.0", ID: id, Error: rpcErr(rpcInvalidRequest, "Invalid Request")})
+		case m.Method == "":
+			// A response; the server sends no requests, so there is nothing to match
+		case len(m.ID) == 0:
+			handleMCPNotification(sess, &m)
+		default:
+			requests = append(requests, &m)
+		}
+	}
+
+	if len(requests) == 0 && len(invalid) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if !batch && len(invalid) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(invalid[0])
+		return
+	}
+
+	// initialize starts a session, so it is answered before anything else
+	// and can't share a batch
+	if len(requests) == 1 && !batch && requests[0].Method == "initialize" {
+		resp, s := mcpInitialize(username, requests[0])
+		if s != nil {
+			w.Header().Set("Mcp-Session-Id", s.ID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	target := r.URL.Query().Get("session")
+	stream := false
+	if acceptsEventStream(r) {
+		for _, m := range requests {
+			stream = stream || m.Method == "tools/call"
+		}
+	}
+
+	responses := make(chan *rpcResponse, len(requests))
+	for _, m := range requests {
+		go func(m *rpcMessage) {
+			responses <- handleMCPRequest(r.Context(), username, target, sess, m)
+		}(m)
+	}
+
+	if stream {
+		streamMCPResponses(w, r, invalid, responses, len(requests))
+		return
+	}
+
+	// Batch responses keep request order
+	results := make(map[*rpcMessage]*rpcResponse)
+	for range requests {
+		resp := <-responses
+		if resp != nil {
+			results[resp.request] = resp
+		}
+	}
+	var out []*rpcResponse
+	out = append(out, invalid...)
+	for _, m := range requests {
+		if resp := results[m]; resp != nil {
+			out = append(out, resp)
+		}
+	}
+
+	if len(out) == 0 {
+		w.WriteHeader(http.StatusAccepted) // every request was cancelled
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if batch {
+		json.NewEncoder(w).Encode(out)
+	} else {
+		json.NewEncoder(w).Encode(out[0])
+	}
+}
+
+// streamMCPResponses answers a POST as an SSE stream, sending each response
+// as it is ready and closing the stream after the last one
+func streamMCPResponses(w http.ResponseWriter, r *http.Request, invalid []*rpcResponse, responses chan *rpcResponse, n int) {
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(resp *rpcResponse) {
+		data, _ := json.Marshal(resp)
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	for _, resp := range invalid {
+		send(resp)
+	}
+
+	keepAlive := time.NewTicker(mcpKeepAlive)
+	defer keepAlive.Stop()
+	for n > 0 {
+		select {
+		case resp := <-responses:
+			n--
+			if resp != nil {
+				send(resp)
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleMCPStream serves GET: server notifications for the session until
+// the client goes away. A new stream replaces the previous one.
+func handleMCPStream(w http.ResponseWriter, r *http.Request, sess *mcpSession) {
+	if !acceptsEventStream(r) {
+		http.Error(w, "Accept: text/event-stream required", http.StatusNotAcceptable)
+		return
+	}
+	if sess == nil {
+		writeRPCError(w, 400, rpcInvalidRequest, "Mcp-Session-Id required")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", 500)
+		return
+	}
+
+	ch := make(chan []byte, 64)
+	sess.mu.Lock()
+	if sess.stream != nil {
+		close(sess.stream)
+	}
+	sess.stream = ch
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		if sess.stream == ch {
+			sess.stream = nil
+		}
+		sess.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(mcpKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case data, ok := <-ch:
+			if !ok {
+				return // replaced or session ended
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		case <-keepAlive.C:
+			// The user's tool manifest is checked here rather than watched
+			stamp := userToolsStamp(sess.Username)
+			sess.mu.Lock()
+			changed := stamp != sess.toolsStamp
+			sess.toolsStamp = stamp
+			sess.mu.Unlock()
+			if changed {
+				data, _ := json.Marshal(rpcNotification{JSONRPC: "2.0", Method: "notifications/tools/list_changed"})
+				fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			} else {
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func mcpInitialize(username string, m *rpcMessage) (*rpcResponse, *mcpSession) {
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if len(m.Params) > 0 && json.Unmarshal(m.Params, &params) != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: m.ID, Error: rpcErr(rpcInvalidParams, "Invalid params")}, nil
+	}
+	// Use the client's version if supported, otherwise offer the newest
+	version := mcpProtocolVersions[0]
+	for _, v := range mcpProtocolVersions {
+		if v == params.ProtocolVersion {
+			version = v
+		}
+	}
+
+	s := newMCPSession(username, version)
+	return &rpcResponse{JSONRPC: "2.0", ID: m.ID, Result: map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities": map[string]interface{}{
+			"tools":   map[string]interface{}{"listChanged": true},
+			"logging": map[string]interface{}{},
+		},
+		"serverInfo": map[string]interface{}{
+			"name":    "algo",
+			"version": "1.0.0",
+		},
+		"instructions": "Tools act on the user's ALGO desktop in their browser. " +
+			"They target the focused tab; pass \"session\" (an ID from sessions/list, or \"all\") to pick another.",
+	}}, s
+}
+
+func handleMCPNotification(sess *mcpSession, m *rpcMessage) {
+	switch m.Method {
+	case "notifications/cancelled":
+		var params struct {
+			RequestID json.RawMessage `json:"requestId"`
+		}
+		if sess == nil || json.Unmarshal(m.Params, &params) != nil {
+			return
+		}
+		sess.mu.Lock()
+		if cancel, ok := sess.inflight[rpcIDKey(params.RequestID)]; ok {
+			cancel()
+		}
+		sess.mu.Unlock()
+	}
+	// notifications/initialized and others need nothing
+}
+
+// handleMCPRequest answers one request; nil if it was cancelled, which
+// gets no response
+func handleMCPRequest(ctx context.Context, username, target string, sess *mcpSession, m *rpcMessage) *rpcResponse {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if sess != nil {
+		key := rpcIDKey(m.ID)
+		sess.mu.Lock()
+		sess.inflight[key] = cancel
+		sess.mu.Unlock()
+		defer func() {
+			sess.mu.Lock()
+			delete(sess.inflight, key)
+			sess.mu.Unlock()
+		}()
+	}
+
+	result, err := mcpDispatch(ctx, username, target, sess, m)
+	if ctx.Err() != nil {
+		return nil
+	}
+	resp := &rpcResponse{JSONRPC: "2.0", ID: m.ID, request: m}
+	if err != nil {
+		resp.Error = err
+	} else {
+		resp.Result = result
+	}
+	return resp
+}
+
+func mcpDispatch(ctx context.Context, username, target string, sess *mcpSession, m *rpcMessage) (interface{}, *rpcError) {
+	switch m.Method {
+	case "initialize":
+		return nil, rpcErr(rpcInvalidRequest, "initialize must be sent on its own")
+
+	case "ping":
+		return map[string]interface{}{}, nil
+
+	case "logging/setLevel":
+		var params struct {
+			Level string `json:"level"`
+		}
+		if json.Unmarshal(m.Params, &params) != nil || logLevelRank(params.Level) < 0 {
+			return nil, rpcErr(rpcInvalidParams, "Invalid log level")
+		}
+		if sess == nil {
+			return nil, rpcErr(rpcInvalidRequest, "logging/setLevel needs a session; initialize first")
+		}
+		sess.mu.Lock()
+		sess.logLevel = params.Level
+		sess.mu.Unlock()
+		return map[string]interface{}{}, nil
+
+	case "sessions/list":
+		sessions := []map[string]interface{}{}
+		for _, bc := range findBrowserConns(username, "all", true) {
+			sessions = append(sessions, bc.info())
+		}
+		return map[string]interface{}{"sessions": sessions}, nil
+
+	case "tools/list":
+		if sess != nil {
+			sess.mu.Lock()
+			sess.toolsStamp = userToolsStamp(username)
+			sess.mu.Unlock()
+		}
+		return map[string]interface{}{"tools": mcpToolInfos(username)}, nil
+
+	case "tools/call":
+		var params struct {
+			Name      string                 `json:"name"`
+			Arguments map[string]interface{} `json:"arguments"`
+		}
+		if json.Unmarshal(m.Params, &params) != nil || params.Name == "" {
+			return nil, rpcErr(rpcInvalidParams, "Invalid params")
+		}
+		if params.Arguments == nil {
+			params.Arguments = map[string]interface{}{}
+		}
+		if s, ok := params.Arguments["session"].(string); ok && s != "" {
+			target = s
+		}
+		return callMCPTool(ctx, username, target, params.Name, params.Arguments)
+	}
+	return nil, rpcErr(rpcMethodNotFound, "Method not found: %s", m.Method)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newMCPServer serves /api/mcp and returns a token for alice
+func newMCPServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	old := config
+	config.SessionSecret = "test-secret"
+	config.SessionExpiry = time.Hour
+	config.HomesDir = t.TempDir()
+	t.Cleanup(func() { config = old })
+
+	srv := httptest.NewServer(http.HandlerFunc(handleMCP))
+	t.Cleanup(srv.Close)
+	token, _ := generateToken("alice")
+	return srv, token
+}
+
+// newFakeBrowser registers a Shell tab for alice that never answers on its
+// own; the request IDs of bridge commands sent to it arrive on the channel
+func newFakeBrowser(t *testing.T) (*BrowserConnection, chan string) {
+	t.Helper()
+	commands := make(chan string, 8)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var cmd struct {
+				ReqID string `json:"_mcpReqId"`
+			}
+			json.Unmarshal([]byte(strings.TrimPrefix(string(msg), "MCP_CMD:")), &cmd)
+			commands <- cmd.ReqID
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := &BrowserConnection{
+		ID:        "tab1",
+		Conn:      conn,
+		Username:  "alice",
+		Kind:      "pty",
+		Responses: make(map[string]chan string),
+		out:       newWSWriter(conn),
+		gone:      make(chan struct{}),
+	}
+	browserConnMu.Lock()
+	browserConnections["alice"] = append(browserConnections["alice"], bc)
+	browserConnMu.Unlock()
+	t.Cleanup(func() {
+		browserConnMu.Lock()
+		delete(browserConnections, "alice")
+		browserConnMu.Unlock()
+		bc.out.Close()
+		conn.Close()
+	})
+	return bc, commands
+}
+
+// mcpPost sends body and returns the status, session header and body
+func mcpPost(t *testing.T, srv *httptest.Server, token, session, body string) (int, string, string) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", srv.URL, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	if session != "" {
+		req.Header.Set("Mcp-Session-Id", session)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, resp.Header.Get("Mcp-Session-Id"), string(data)
+}
+
+func mcpInit(t *testing.T, srv *httptest.Server, token string) string {
+	t.Helper()
+	status, session, body := mcpPost(t, srv, token, "", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}`)
+	if status != 200 || session == "" {
+		t.Fatalf("initialize: %d %q %s", status, session, body)
+	}
+	var resp struct {
+		Result struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		} `json:"result"`
+	}
+	if json.Unmarshal([]byte(body), &resp) != nil || resp.Result.ProtocolVersion != "2024-11-05" {
+		t.Errorf("initialize: got %s, want the client's protocol version", body)
+	}
+	return session
+}
+
+// decodeError reads a single JSON-RPC error response
+func decodeError(t *testing.T, body string) (string, int) {
+	t.Helper()
+	var resp struct {
+		ID    json.RawMessage `json:"id"`
+		Error *rpcError       `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Error == nil {
+		t.Fatalf("want an error response, got %s", body)
+	}
+	return string(resp.ID), resp.Error.Code
+}
+
+func TestMCPSessions(t *testing.T) {
+	srv, token := newMCPServer(t)
+	session := mcpInit(t, srv, token)
+
+	if status, _, body := mcpPost(t, srv, token, session, `{"jsonrpc":"2.0","id":2,"method":"ping"}`); status != 200 {
+		t.Errorf("ping on the session: %d %s", status, body)
+	}
+
+	status, _, body := mcpPost(t, srv, token, "unknown", `{"jsonrpc":"2.0","id":3,"method":"ping"}`)
+	if id, code := decodeError(t, body); status != 404 || id != "null" || code != rpcInvalidRequest {
+		t.Errorf("unknown session: %d %s", status, body)
+	}
+
+	// Sessions idle too long are dropped when the next one starts
+	mcpSessionsMu.Lock()
+	mcpSessions[session].lastSeen = time.Now().Add(-2 * mcpSessionIdle)
+	mcpSessionsMu.Unlock()
+	mcpInit(t, srv, token)
+	status, _, body = mcpPost(t, srv, token, session, `{"jsonrpc":"2.0","id":4,"method":"ping"}`)
+	if id, code := decodeError(t, body); status != 404 || id != "null" || code != rpcInvalidRequest {
+		t.Errorf("expired session: %d %s", status, body)
+	}
+}
+
+func TestMCPBatch(t *testing.T) {
+	srv, token := newMCPServer(t)
+	bc, commands := newFakeBrowser(t)
+
+	// The tool call finishes last but is still answered first
+	go func() {
+		reqID := <-commands
+		time.Sleep(50 * time.Millisecond)
+		bc.HandleResponse(reqID, `{"success":true}`)
+	}()
+	status, _, body := mcpPost(t, srv, token, "", `[
+		{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"algo_getState"}},
+		{"jsonrpc":"2.0","method":"notifications/initialized"},
+		{"jsonrpc":"2.0","id":"two","method":"ping"},
+		{"jsonrpc":"2.0","id":3,"method":"no/such/method"}
+	]`)
+	var out []struct {
+		ID    json.RawMessage `json:"id"`
+		Error *rpcError       `json:"error"`
+	}
+	if status != 200 || json.Unmarshal([]byte(body), &out) != nil || len(out) != 3 {
+		t.Fatalf("batch: %d %s", status, body)
+	}
+	for i, want := range []string{`1`, `"two"`, `3`} {
+		if string(out[i].ID) != want {
+			t.Errorf("response %d has id %s, want %s", i, out[i].ID, want)
+		}
+	}
+	if out[0].Error != nil || out[1].Error != nil || out[2].Error == nil || out[2].Error.Code != rpcMethodNotFound {
+		t.Errorf("batch: %s", body)
+	}
+
+	// Notifications alone get no body
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`[{"jsonrpc":"2.0","method":"notifications/initialized"},{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":9}}]`,
+	} {
+		if status, _, resp := mcpPost(t, srv, token, "", body); status != 202 || resp != "" {
+			t.Errorf("%s: got %d %q, want 202 and no body", body, status, resp)
+		}
+	}
+}
+
+func TestMCPInvalidMessages(t *testing.T) {
+	srv, token := newMCPServer(t)
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":`, rpcParseError},
+		{`[{"jsonrpc":"2.0","id":1,"method":"ping"}`, rpcParseError},
+		{`[]`, rpcInvalidRequest},
+		{`{"jsonrpc":"2.0"}`, rpcInvalidRequest},
+		{`{"jsonrpc":"1.0","method":"ping"}`, rpcInvalidRequest},
+	} {
+		status, _, body := mcpPost(t, srv, token, "", tc.body)
+		if id, code := decodeError(t, body); status != 400 || id != "null" || code != tc.code {
+			t.Errorf("%s: got %d %s, want 400 with code %d and id null", tc.body, status, body, tc.code)
+		}
+	}
+
+	// Broken entries in a batch are answered in place of the request
+	_, _, body := mcpPost(t, srv, token, "", `[1, {"jsonrpc":"2.0","id":2,"method":"ping"}]`)
+	var out []rpcResponse
+	if json.Unmarshal([]byte(body), &out) != nil || len(out) != 2 ||
+		string(out[0].ID) != "null" || out[0].Error == nil || out[0].Error.Code != rpcInvalidRequest ||
+		string(out[1].ID) != "2" || out[1].Error != nil {
+		t.Errorf("batch with a bad entry: %s", body)
+	}
+}
+
+func TestMCPCancel(t *testing.T) {
+	srv, token := newMCPServer(t)
+	bc, commands := newFakeBrowser(t)
+	session := mcpInit(t, srv, token)
+
+	type reply struct {
+		status int
+		body   string
+	}
+	done := make(chan reply, 1)
+	go func() {
+		status, _, body := mcpPost(t, srv, token, session, `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"algo_getState"}}`)
+		done <- reply{status, body}
+	}()
+
+	select {
+	case <-commands:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the tool call never reached the browser")
+	}
+	if status, _, body := mcpPost(t, srv, token, session, `{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7}}`); status != 202 {
+		t.Errorf("cancel: %d %s", status, body)
+	}
+
+	select {
+	case r := <-done:
+		if r.status != 202 || r.body != "" {
+			t.Errorf("cancelled call: got %d %q, want 202 and no response", r.status, r.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cancelled call is still running")
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if len(bc.Responses) != 0 {
+		t.Errorf("%d bridge commands still waiting", len(bc.Responses))
+	}
+}
@@ -0,0 +1,873 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MCP tools
+//
+// Each tool implements mcpTool, and tools/list and tools/call go through a
+// registry. There are four kinds:
+//
+//   browser  ALGO.bridge functions and captures, run in the user's tab
+//   tab      content bridge actions, run by the browser extension in the
+//            user's own browser tabs (see content_bridge.go)
+//   server   files, exec and tickets, run on the server through the same
+//            functions (and checks) as the HTTP API; commands run as the
+//            user's system account without a shell (see user_exec.go)
+//   user     tools declared in ~/.algo/mcp-tools.json, run as JS in the
+//            browser or as an allow-listed command like algo_exec
+//
+// Built-in tools are registered below; a user's tools are read from their
+// manifest whenever it changes and can't replace a built-in.
+//
+// A manifest looks like:
+//
+//   {"tools": [
+//     {"name": "note_count", "description": "Count sticky notes",
+//      "js": "return document.querySelectorAll('.sticky-note').length"},
+//     {"name": "grep_notes", "description": "Search my notes",
+//      "inputSchema": {"type": "object", "properties": {"q": {"type": "string"}}, "required": ["q"]},
+//      "command": "grep -rn {{q}} notes", "cwd": "~"}
+//   ]}
+//
+// JS runs as a function body with the arguments in `args`; its return value
+// is the result. {{name}} in a command is replaced by the argument as a
+// single quoted word, so arguments can't add shell syntax.
+
+const mcpUserManifest = ".algo/mcp-tools.json"
+
+// mcpTool is one tool served by /api/mcp
+type mcpTool interface {
+	Info() mcpToolInfo
+	// Call runs the tool. An error is reported to the client as a tool
+	// result with isError set.
+	Call(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error)
+}
+
+// mcpToolInfo is a tool's tools/list entry
+type mcpToolInfo struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// mcpToolCall is one tools/call
+type mcpToolCall struct {
+	Username string
+	Target   string // browser connection: "" for the focused tab, "all" or an ID
+	Args     map[string]interface{}
+}
+
+// mcpToolResult is the result of tools/call
+type mcpToolResult struct {
+	Content []map[string]interface{} `json:"content"`
+	IsError bool                     `json:"isError,omitempty"`
+}
+
+// mcpParam is a tool argument
+type mcpParam struct {
+	Name        string
+	Type        string
+	Description string
+	Optional    bool
+}
+
+func textContent(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "text", "text": text}
+}
+
+func textResult(text string) *mcpToolResult {
+	return &mcpToolResult{Content: []map[string]interface{}{textContent(text)}}
+}
+
+func errorResult(text string) *mcpToolResult {
+	return &mcpToolResult{Content: []map[string]interface{}{textContent(text)}, IsError: true}
+}
+
+// paramSchema builds an input schema from a parameter list
+func paramSchema(params []mcpParam) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	for _, p := range params {
+		props[p.Name] = map[string]interface{}{"type": p.Type, "description": p.Description}
+		if !p.Optional {
+			required = append(required, p.Name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// withSessionParam adds the optional "session" argument browser tools take
+func withSessionParam(schema map[string]interface{}) map[string]interface{} {
+	props := map[string]interface{}{}
+	if p, ok := schema["properties"].(map[string]interface{}); ok {
+		for k, v := range p {
+			props[k] = v
+		}
+	}
+	props["session"] = map[string]interface{}{
+		"type":        "string",
+		"description": "Browser connection ID from sessions/list, or \"all\" (default: the focused tab)",
+	}
+	out := map[string]interface{}{}
+	for k, v := range schema {
+		out[k] = v
+	}
+	out["properties"] = props
+	return out
+}
+
+// requiredArgs lists a schema's required arguments
+func requiredArgs(schema map[string]interface{}) []string {
+	switch r := schema["required"].(type) {
+	case []string:
+		return r
+	case []interface{}:
+		var names []string
+		for _, name := range r {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// Registry
+
+// mcpRegistry holds tools by name
+type mcpRegistry struct {
+	mu    sync.RWMutex
+	tools []mcpTool
+	names map[string]bool
+}
+
+// Register adds tools; like http.HandleFunc it panics on a duplicate name
+func (reg *mcpRegistry) Register(tools ...mcpTool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.names == nil {
+		reg.names = make(map[string]bool)
+	}
+	for _, t := range tools {
+		name := t.Info().Name
+		if reg.names[name] {
+			panic("mcp: tool registered twice: " + name)
+		}
+		reg.names[name] = true
+		reg.tools = append(reg.tools, t)
+	}
+}
+
+// List returns the tools in registration order
+func (reg *mcpRegistry) List() []mcpTool {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return append([]mcpTool(nil), reg.tools...)
+}
+
+// Has reports whether a tool is registered
+func (reg *mcpRegistry) Has(name string) bool {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return reg.names[name]
+}
+
+var mcpBuiltinTools = &mcpRegistry{}
+
+// mcpToolsFor returns the built-in tools followed by the user's own
+func mcpToolsFor(username string) []mcpTool {
+	return append(mcpBuiltinTools.List(), userMCPTools(username)...)
+}
+
+func mcpToolInfos(username string) []mcpToolInfo {
+	tools := mcpToolsFor(username)
+	infos := make([]mcpToolInfo, len(tools))
+	for i, t := range tools {
+		infos[i] = t.Info()
+	}
+	return infos
+}
+
+// callMCPTool runs a tool. Unknown tools and missing arguments are protocol
+// errors; failures while running are tool results with isError set.
+func callMCPTool(ctx context.Context, username, target, name string, args map[string]interface{}) (interface{}, *rpcError) {
+	var tool mcpTool
+	for _, t := range mcpToolsFor(username) {
+		if t.Info().Name == name {
+			tool = t
+			break
+		}
+	}
+	if tool == nil {
+		return nil, rpcErr(rpcInvalidParams, "Unknown tool: %s", name)
+	}
+	for _, arg := range requiredArgs(tool.Info().InputSchema) {
+		if _, ok := args[arg]; !ok {
+			return nil, rpcErr(rpcInvalidParams, "Missing argument: %s", arg)
+		}
+	}
+
+	result, err := tool.Call(ctx, &mcpToolCall{Username: username, Target: target, Args: args})
+	if err != nil {
+		return errorResult(err.Error()), nil
+	}
+	return result, nil
+}
+
+// awaitContext runs fn, giving up when ctx is done
+func awaitContext(ctx context.Context, fn func() (string, error)) (string, error) {
+	type reply struct {
+		result string
+		err    error
+	}
+	ch := make(chan reply, 1)
+	go func() {
+		result, err := fn()
+		ch <- reply{result, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.result, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Browser tools
+
+// bridgeTool calls an ALGO.bridge function with the arguments in order
+type bridgeTool struct {
+	name        string
+	description string
+	fn          string
+	params      []mcpParam
+}
+
+func (t *bridgeTool) Info() mcpToolInfo {
+	return mcpToolInfo{Name: t.name, Description: t.description, InputSchema: withSessionParam(paramSchema(t.params))}
+}
+
+func (t *bridgeTool) Call(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+	args := make([]interface{}, len(t.params))
+	for i, p := range t.params {
+		args[i] = call.Args[p.Name]
+	}
+	return runBridge(ctx, call, t.fn, args...)
+}
+
+// runBridge sends a bridge command to the call's target, or to every
+// connection for "all". Bridge functions answer {success, error, ...}.
+func runBridge(ctx context.Context, call *mcpToolCall, fn string, args ...interface{}) (*mcpToolResult, error) {
+	targets := findBrowserConns(call.Username, call.Target, true)
+	if len(targets) == 0 {
+		if call.Target != "" && call.Target != "all" {
+			return nil, fmt.Errorf("No browser session %s for user %s", call.Target, call.Username)
+		}
+		return nil, fmt.Errorf("No browser session for user %s. Open the Claude app in the browser first.", call.Username)
+	}
+	command := func() map[string]interface{} {
+		return map[string]interface{}{"_bridge": fn, "_args": args}
+	}
+
+	// Broadcast: run on every connection and report each result
+	if call.Target == "all" {
+		results := make([]map[string]interface{}, len(targets))
+		var wg sync.WaitGroup
+		for i, bc := range targets {
+			wg.Add(1)
+			go func(i int, bc *BrowserConnection) {
+				defer wg.Done()
+				entry := map[string]interface{}{"session": bc.ID}
+				if result, err := bc.SendCommand(ctx, command()); err != nil {
+					entry["error"] = err.Error()
+				} else {
+					entry["result"] = json.RawMessage(result)
+					if !json.Valid([]byte(result)) {
+						entry["result"] = result
+					}
+				}
+				results[i] = entry
+			}(i, bc)
+		}
+		wg.Wait()
+		text, _ := json.Marshal(results)
+		return textResult(string(text)), nil
+	}
+
+	bc := targets[0]
+	result, err := bc.SendCommand(ctx, command())
+	if err != nil {
+		return nil, err
+	}
+	var status struct {
+		Success *bool `json:"success"`
+	}
+	json.Unmarshal([]byte(result), &status)
+	res := textResult(result)
+	res.IsError = status.Success != nil && !*status.Success
+	return res, nil
+}
+
+// captureTool renders a screenshot or snapshot in the desktop page
+type captureTool struct {
+	name        string
+	description string
+	kind        string // "screenshot" or "snapshot"
+}
+
+func (t *captureTool) Info() mcpToolInfo {
+	return mcpToolInfo{Name: t.name, Description: t.description, InputSchema: withSessionParam(paramSchema([]mcpParam{
+		{Name: "windowId", Type: "integer", Description: "Window ID to capture (omit for the whole desktop)", Optional: true},
+	}))}
+}
+
+func (t *captureTool) Call(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+	windowID := -1
+	if id, ok := call.Args["windowId"].(float64); ok {
+		windowID = int(id)
+	}
+	var res *captureResult
+	_, err := awaitContext(ctx, func() (string, error) {
+		var err error
+		res, err = captureFromBrowser(call.Username, call.Target, t.kind, windowID)
+		return "", err
+	})
+	if err != nil {
+		return nil, err
+	}
+	content := textContent(string(res.Data))
+	if res.Kind == "screenshot" {
+		content = map[string]interface{}{
+			"type":     "image",
+			"data":     base64.StdEncoding.EncodeToString(res.Data),
+			"mimeType": res.Mime,
+		}
+	}
+	return &mcpToolResult{Content: []map[string]interface{}{content, textContent("Saved to " + res.Path)}}, nil
+}
+
+// Tab tools
+
+// maxTabContent caps the HTML algo_tabContent returns
+const maxTabContent = 200000
+
+// tabTool runs a content bridge action through the user's extension
+type tabTool struct {
+	name        string
+	description string
+	action      string
+	params      []mcpParam
+	format      func(result json.RawMessage) (*mcpToolResult, error) // nil returns the result as JSON
+}
+
+func (t *tabTool) Info() mcpToolInfo {
+	params := append(append([]mcpParam{}, t.params...), mcpParam{
+		Name: "extension", Type: "string", Description: "Extension ID from algo_tabs when the user has several (default: the newest)", Optional: true,
+	})
+	return mcpToolInfo{Name: t.name, Description: t.description, InputSchema: paramSchema(params)}
+}
+
+func (t *tabTool) Call(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+	params := map[string]interface{}{}
+	for k, v := range call.Args {
+		if k != "extension" {
+			params[k] = v
+		}
+	}
+	raw, _ := json.Marshal(params)
+	result, err := contentCall(ctx, call.Username, stringArg(call.Args, "extension"), t.action, raw)
+	if err != nil {
+		return nil, err
+	}
+	if t.format != nil {
+		return t.format(result)
+	}
+	var pretty bytes.Buffer
+	if json.Indent(&pretty, result, "", "  ") != nil {
+		return textResult(string(result)), nil
+	}
+	return textResult(pretty.String()), nil
+}
+
+// Server tools
+
+// serverTool runs Go code on the server
+type serverTool struct {
+	name        string
+	description string
+	params      []mcpParam
+	run         func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error)
+}
+
+func (t *serverTool) Info() mcpToolInfo {
+	return mcpToolInfo{Name: t.name, Description: t.description, InputSchema: paramSchema(t.params)}
+}
+
+func (t *serverTool) Call(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+	return t.run(ctx, call)
+}
+
+func stringArg(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+// execResult turns a command's output into a tool result; a failed
+// command is an error result that keeps its output
+func execResult(res *execOutput) *mcpToolResult {
+	if res.Error != "" {
+		return errorResult(strings.TrimLeft(res.Output+"\n["+res.Error+"]", "\n"))
+	}
+	if res.Cwd != "" {
+		return textResult("cwd: " + res.Cwd)
+	}
+	return textResult(res.Output)
+}
+
+// User tools
+
+// userTool is a tool from a user's manifest
+type userTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+	JS          string                 `json:"js"`
+	Command     string                 `json:"command"`
+	Cwd         string                 `json:"cwd"`
+}
+
+func (t *userTool) Info() mcpToolInfo {
+	schema := t.InputSchema
+	if schema == nil {
+		schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	if t.JS != "" {
+		schema = withSessionParam(schema)
+	}
+	return mcpToolInfo{Name: t.Name, Description: t.Description, InputSchema: schema}
+}
+
+func (t *userTool) Call(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+	args := map[string]interface{}{}
+	for k, v := range call.Args {
+		if k != "session" || t.JS == "" {
+			args[k] = v
+		}
+	}
+
+	if t.JS != "" {
+		argsJSON, _ := json.Marshal(args)
+		return runBridge(ctx, call, "eval", "(function(args) {\n"+t.JS+"\n})("+string(argsJSON)+")")
+	}
+
+	command := userToolPlaceholder.ReplaceAllStringFunc(t.Command, func(m string) string {
+		value, ok := args[m[2:len(m)-2]]
+		if !ok || value == nil {
+			return "''"
+		}
+		if s, ok := value.(string); ok {
+			return shellQuote(s)
+		}
+		data, _ := json.Marshal(value)
+		return shellQuote(string(data))
+	})
+	res, err := runUserCommand(ctx, call.Username, command, t.Cwd)
+	if err != nil {
+		return nil, err
+	}
+	return execResult(res), nil
+}
+
+var (
+	userToolName        = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+	userToolPlaceholder = regexp.MustCompile(`\{\{[a-zA-Z0-9_]+\}\}`)
+)
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// userToolSet is a parsed manifest, kept until the file changes
+type userToolSet struct {
+	modified time.Time
+	size     int64
+	tools    []mcpTool
+}
+
+var (
+	userToolSets   = make(map[string]*userToolSet) // username -> manifest
+	userToolSetsMu sync.Mutex
+)
+
+func userManifestPath(username string) string {
+	return filepath.Join(config.HomesDir, username, mcpUserManifest)
+}
+
+// userToolsStamp identifies the current version of a user's manifest
+// ("" if there is none), to notice when the tool list changes
+func userToolsStamp(username string) string {
+	info, err := os.Stat(userManifestPath(username))
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// userMCPTools returns the tools in a user's manifest. Invalid entries
+// and ones that clash with a built-in or an earlier entry are skipped.
+func userMCPTools(username string) []mcpTool {
+	path := userManifestPath(username)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	userToolSetsMu.Lock()
+	defer userToolSetsMu.Unlock()
+	if set := userToolSets[username]; set != nil && set.modified.Equal(info.ModTime()) && set.size == info.Size() {
+		return set.tools
+	}
+
+	set := &userToolSet{modified: info.ModTime(), size: info.Size()}
+	userToolSets[username] = set
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var manifest struct {
+		Tools []*userTool `json:"tools"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		fmt.Printf("[MCP] %s: bad %s: %v\n", username, mcpUserManifest, err)
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, t := range manifest.Tools {
+		var problem string
+		switch {
+		case !userToolName.MatchString(t.Name):
+			problem = "invalid name"
+		case mcpBuiltinTools.Has(t.Name) || seen[t.Name]:
+			problem = "name already used"
+		case (t.JS == "") == (t.Command == ""):
+			problem = "needs exactly one of js or command"
+		}
+		if problem != "" {
+			fmt.Printf("[MCP] %s: skipping tool %q in %s: %s\n", username, t.Name, mcpUserManifest, problem)
+			continue
+		}
+		seen[t.Name] = true
+		set.tools = append(set.tools, t)
+	}
+	return set.tools
+}
+
+// Built-in tools
+
+func init() {
+	mcpBuiltinTools.Register(
+		&bridgeTool{
+			name:        "algo_eval",
+			description: "Execute JavaScript code in the browser and return the result",
+			fn:          "eval",
+			params:      []mcpParam{{Name: "code", Type: "string", Description: "JavaScript code to execute"}},
+		},
+		&bridgeTool{
+			name:        "algo_getState",
+			description: "Get the current ALGO OS state including windows, apps, and user info",
+			fn:          "getState",
+		},
+		&bridgeTool{
+			name:        "algo_query",
+			description: "Query a DOM element using CSS selector",
+			fn:          "query",
+			params:      []mcpParam{{Name: "selector", Type: "string", Description: "CSS selector"}},
+		},
+		&bridgeTool{
+			name:        "algo_queryAll",
+			description: "Query all matching DOM elements using CSS selector",
+			fn:          "queryAll",
+			params:      []mcpParam{{Name: "selector", Type: "string", Description: "CSS selector"}},
+		},
+		&bridgeTool{
+			name:        "algo_click",
+			description: "Click a DOM element by CSS selector",
+			fn:          "click",
+			params:      []mcpParam{{Name: "selector", Type: "string", Description: "CSS selector of element to click"}},
+		},
+		&bridgeTool{
+			name:        "algo_setValue",
+			description: "Set the value of an input element",
+			fn:          "setValue",
+			params: []mcpParam{
+				{Name: "selector", Type: "string", Description: "CSS selector of input element"},
+				{Name: "value", Type: "string", Description: "Value to set"},
+			},
+		},
+		&bridgeTool{
+			name:        "algo_openApp",
+			description: "Open an application by ID",
+			fn:          "openApp",
+			params:      []mcpParam{{Name: "appId", Type: "string", Description: "Application ID to open"}},
+		},
+		&bridgeTool{
+			name:        "algo_closeWindow",
+			description: "Close a window by ID",
+			fn:          "closeWindow",
+			params:      []mcpParam{{Name: "windowId", Type: "integer", Description: "Window ID to close"}},
+		},
+		&captureTool{
+			name:        "algo_screenshot",
+			description: "Take a PNG screenshot of the desktop or one window (also saved to ~/.algo/captures)",
+			kind:        "screenshot",
+		},
+		&captureTool{
+			name:        "algo_snapshot",
+			description: "Get a simplified accessibility tree of the desktop or one window (roles, names, text)",
+			kind:        "snapshot",
+		},
+
+		&serverTool{
+			name:        "algo_tabs",
+			description: "List the tabs open in the user's own browser, per connected browser extension (newest first)",
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				state := contentState(call.Username)
+				if exts, _ := state["extensions"].([]contentExtensionInfo); len(exts) == 0 {
+					return nil, errNoExtension
+				}
+				data, _ := json.MarshalIndent(state["extensions"], "", "  ")
+				return textResult(string(data)), nil
+			},
+		},
+		&tabTool{
+			name:        "algo_tabContent",
+			description: "Get the HTML, URL and title of a tab in the user's own browser",
+			action:      "getContent",
+			params:      []mcpParam{{Name: "tabId", Type: "integer", Description: "Tab ID from algo_tabs"}},
+			format: func(result json.RawMessage) (*mcpToolResult, error) {
+				var page struct {
+					HTML  string `json:"html"`
+					URL   string `json:"url"`
+					Title string `json:"title"`
+				}
+				if err := json.Unmarshal(result, &page); err != nil {
+					return nil, fmt.Errorf("unexpected getContent result")
+				}
+				html := page.HTML
+				if len(html) > maxTabContent {
+					html = html[:maxTabContent] + fmt.Sprintf("\n[truncated, %d bytes total]", len(page.HTML))
+				}
+				return textResult(fmt.Sprintf("%s\n%s\n\n%s", page.Title, page.URL, html)), nil
+			},
+		},
+		&tabTool{
+			name:        "algo_tabExtract",
+			description: "Get the readable article text of a tab in the user's own browser, without navigation and ads",
+			action:      "extract",
+			params:      []mcpParam{{Name: "tabId", Type: "integer", Description: "Tab ID from algo_tabs"}},
+			format: func(result json.RawMessage) (*mcpToolResult, error) {
+				var article struct {
+					URL    string `json:"url"`
+					Title  string `json:"title"`
+					Byline string `json:"byline"`
+					Text   string `json:"text"`
+				}
+				if err := json.Unmarshal(result, &article); err != nil {
+					return nil, fmt.Errorf("unexpected extract result")
+				}
+				head := article.Title + "\n" + article.URL
+				if article.Byline != "" {
+					head += "\n" + article.Byline
+				}
+				return textResult(head + "\n\n" + article.Text), nil
+			},
+		},
+		&tabTool{
+			name:        "algo_tabScreenshot",
+			description: "Take a PNG screenshot of a tab in the user's own browser (the tab is brought to the front briefly)",
+			action:      "screenshot",
+			params:      []mcpParam{{Name: "tabId", Type: "integer", Description: "Tab ID from algo_tabs (default: the active tab)", Optional: true}},
+			format: func(result json.RawMessage) (*mcpToolResult, error) {
+				var dataURL string
+				json.Unmarshal(result, &dataURL)
+				mime, data, ok := strings.Cut(strings.TrimPrefix(dataURL, "data:"), ";base64,")
+				if !ok {
+					return nil, fmt.Errorf("unexpected screenshot result")
+				}
+				return &mcpToolResult{Content: []map[string]interface{}{{"type": "image", "data": data, "mimeType": mime}}}, nil
+			},
+		},
+		&tabTool{
+			name:        "algo_openTab",
+			description: "Open a URL in a background shadow tab of the user's own browser; returns its tabId",
+			action:      "openTab",
+			params:      []mcpParam{{Name: "url", Type: "string", Description: "http(s) URL to open"}},
+		},
+		&tabTool{
+			name:        "algo_closeTab",
+			description: "Close a shadow tab opened with algo_openTab",
+			action:      "closeTab",
+			params:      []mcpParam{{Name: "tabId", Type: "integer", Description: "Tab ID returned by algo_openTab"}},
+		},
+
+		&serverTool{
+			name:        "algo_readFile",
+			description: "Read a text file from the user's home directory",
+			params:      []mcpParam{{Name: "path", Type: "string", Description: "File path, relative to home or starting with ~/"}},
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				file, err := readHomeFile(call.Username, stringArg(call.Args, "path"))
+				if err != nil {
+					return nil, err
+				}
+				return textResult(string(file.Content)), nil
+			},
+		},
+		&serverTool{
+			name:        "algo_writeFile",
+			description: "Create or overwrite a text file in the user's home directory",
+			params: []mcpParam{
+				{Name: "path", Type: "string", Description: "File path, relative to home or starting with ~/"},
+				{Name: "content", Type: "string", Description: "New file content"},
+			},
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				content := stringArg(call.Args, "content")
+				path, err := saveHomeFile(call.Username, stringArg(call.Args, "path"), content)
+				if err != nil {
+					return nil, err
+				}
+				return textResult(fmt.Sprintf("Saved %s (%d bytes)", path, len(content))), nil
+			},
+		},
+		&serverTool{
+			name:        "algo_listFiles",
+			description: "List a directory in the user's home; directories end with /",
+			params:      []mcpParam{{Name: "path", Type: "string", Description: "Directory path (default: home)", Optional: true}},
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				path, files, err := listHomeDir(call.Username, stringArg(call.Args, "path"))
+				if err != nil {
+					return nil, err
+				}
+				var b strings.Builder
+				b.WriteString(path + "\n")
+				for _, f := range files {
+					name := f.Name
+					if f.Type == "directory" {
+						name += "/"
+					}
+					b.WriteString(name + "\n")
+				}
+				return textResult(b.String()), nil
+			},
+		},
+		&serverTool{
+			name:        "algo_exec",
+			description: "Run an allow-listed command as the user in their home and return its output. There is no shell: quoting works, but pipes, redirects and globs do not",
+			params: []mcpParam{
+				{Name: "command", Type: "string", Description: "Command line to run"},
+				{Name: "cwd", Type: "string", Description: "Working directory (default: home)", Optional: true},
+			},
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				res, err := runUserCommand(ctx, call.Username, stringArg(call.Args, "command"), stringArg(call.Args, "cwd"))
+				if err != nil {
+					return nil, err
+				}
+				return execResult(res), nil
+			},
+		},
+		&serverTool{
+			name:        "algo_listTickets",
+			description: "List tickets, unfinished ones first",
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				list, err := listTickets()
+				if err != nil {
+					return nil, err
+				}
+				unfinished := func(t Ticket) bool { return t.Status == ticketOpen || t.Status == ticketInProgress }
+				sort.SliceStable(list, func(i, j int) bool { return unfinished(list[i]) && !unfinished(list[j]) })
+				data, _ := json.MarshalIndent(list, "", "  ")
+				return textResult(string(data)), nil
+			},
+		},
+		&serverTool{
+			name:        "algo_createTicket",
+			description: "Open a ticket",
+			params: []mcpParam{
+				{Name: "title", Type: "string", Description: "Short summary"},
+				{Name: "description", Type: "string", Description: "Details", Optional: true},
+			},
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				title := strings.TrimSpace(stringArg(call.Args, "title"))
+				if title == "" {
+					return nil, errors.New("title required")
+				}
+				t, err := createTicket(title, stringArg(call.Args, "description"))
+				if err != nil {
+					return nil, err
+				}
+				data, _ := json.MarshalIndent(t, "", "  ")
+				return textResult(string(data)), nil
+			},
+		},
+		&serverTool{
+			name:        "algo_updateTicket",
+			description: "Change a ticket's status, title or description. Status moves open -> in_progress -> done; closed drops it, open reopens it",
+			params: []mcpParam{
+				{Name: "id", Type: "string", Description: "Ticket ID, e.g. T3"},
+				{Name: "status", Type: "string", Description: "open, in_progress, done or closed", Optional: true},
+				{Name: "title", Type: "string", Description: "New title", Optional: true},
+				{Name: "description", Type: "string", Description: "New description", Optional: true},
+			},
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				field := func(name string) *string {
+					if v, ok := call.Args[name].(string); ok {
+						return &v
+					}
+					return nil
+				}
+				t, err := editTicket(stringArg(call.Args, "id"), field("title"), field("description"), field("status"))
+				if err != nil {
+					return nil, err
+				}
+				data, _ := json.MarshalIndent(t, "", "  ")
+				return textResult(string(data)), nil
+			},
+		},
+		&serverTool{
+			name:        "algo_replyTicket",
+			description: "Add a reply to a ticket, e.g. progress notes or the outcome",
+			params: []mcpParam{
+				{Name: "id", Type: "string", Description: "Ticket ID, e.g. T3"},
+				{Name: "text", Type: "string", Description: "Reply text"},
+			},
+			run: func(ctx context.Context, call *mcpToolCall) (*mcpToolResult, error) {
+				text := stringArg(call.Args, "text")
+				t, err := updateTicket(stringArg(call.Args, "id"), func(t *Ticket) error { return addTicketReply(t, call.Username, text) })
+				if err != nil {
+					return nil, err
+				}
+				data, _ := json.MarshalIndent(t, "", "  ")
+				return textResult(string(data)), nil
+			},
+		},
+	)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// PTY socket framing
+//
+// Version 1 is negotiated with the "fs-pty.v1" WebSocket subprotocol:
+//   - binary frames carry terminal data in both directions
+//   - text frames carry JSON control messages: {"type": "...", "id": "...", ...}
+//
+// Clients that do not request a subprotocol get the legacy format, where
+// control messages are text frames with magic prefixes (RESIZE:, IPC_READ, ...)
+// and every other frame is terminal input. Legacy support can be turned off
+// with PTY_LEGACY_PROTOCOL=0.
+const ptyProtocolV1 = "fs-pty.v1"
+
+// Control message types
+const (
+	// client -> server
+	ptyMsgResize       = "resize"        // cols, rows
+	ptyMsgCloseSession = "close_session" //
+	ptyMsgIPCRead      = "ipc_read"      // id
+	ptyMsgIPCWrite     = "ipc_write"     // data
+	ptyMsgMCPResponse  = "mcp_resp"      // id, result
+	ptyMsgEyeResponse  = "eye"           // id, data or error
+
+	// server -> client
+	ptyMsgIPCResponse = "ipc_response" // id, data
+	ptyMsgMCPCommand  = "mcp_cmd"      // id, command
+	ptyMsgEyeCommand  = "eye_cmd"      // id, expr
+	ptyMsgError       = "error"        // id, error
+)
+
+// ptyControl is a control message on the PTY socket
+type ptyControl struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Cols    uint16          `json:"cols,omitempty"`
+	Rows    uint16          `json:"rows,omitempty"`
+	Data    string          `json:"data,omitempty"`
+	Expr    string          `json:"expr,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Command json.RawMessage `json:"command,omitempty"`
+}
+
+// ptyUpgrader negotiates the PTY framing version
+var ptyUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{ptyProtocolV1},
+	CheckOrigin: func(r *http.Request) bool {
+		return true // Allow all origins for now
+	},
+}
+
+// parsePTYFrame splits an incoming frame into terminal input or a control message.
+// Exactly one of the results is non-nil for a well-formed frame.
+func parsePTYFrame(protocol string, msgType int, msg []byte) ([]byte, *ptyControl, error) {
+	if protocol == ptyProtocolV1 {
+		if msgType == websocket.BinaryMessage {
+			return msg, nil, nil
+		}
+		var ctl ptyControl
+		if err := json.Unmarshal(msg, &ctl); err != nil || ctl.Type == "" {
+			return nil, nil, fmt.Errorf("invalid control frame")
+		}
+		return nil, &ctl, nil
+	}
+
+	if msgType == websocket.TextMessage {
+		if ctl := parseLegacyPTYControl(string(msg)); ctl != nil {
+			return nil, ctl, nil
+		}
+	}
+	return msg, nil, nil
+}
+
+// parseLegacyPTYControl recognizes the string-prefixed control messages
+func parseLegacyPTYControl(msg string) *ptyControl {
+	switch {
+	case strings.HasPrefix(msg, "RESIZE:"):
+		ctl := &ptyControl{Type: ptyMsgResize}
+		fmt.Sscanf(msg, "RESIZE:%d:%d", &ctl.Cols, &ctl.Rows)
+		return ctl
+	case msg == "CLOSE_SESSION":
+		return &ptyControl{Type: ptyMsgCloseSession}
+	case msg == "IPC_READ":
+		return &ptyControl{Type: ptyMsgIPCRead}
+	case strings.HasPrefix(msg, "IPC_WRITE:"):
+		return &ptyControl{Type: ptyMsgIPCWrite, Data: msg[10:]}
+	case strings.HasPrefix(msg, "MCP_RESP:"):
+		// Format: MCP_RESP:reqId:jsonResult
+		parts := strings.SplitN(msg[9:], ":", 2)
+		if len(parts) != 2 {
+			return &ptyControl{Type: ptyMsgMCPResponse}
+		}
+		return &ptyControl{Type: ptyMsgMCPResponse, ID: parts[0], Result: json.RawMessage(parts[1])}
+	case strings.HasPrefix(msg, "EYE:"):
+		// Format: EYE:id:result or EYE:id!:error
+		ctl := &ptyControl{Type: ptyMsgEyeResponse}
+		rest := msg[4:]
+		colonIdx := strings.Index(rest, ":")
+		if colonIdx < 0 {
+			return ctl
+		}
+		ctl.ID = rest[:colonIdx]
+		if strings.HasSuffix(ctl.ID, "!") {
+			ctl.ID = strings.TrimSuffix(ctl.ID, "!")
+			ctl.Error = rest[colonIdx+1:]
+			if ctl.Error == "" {
+				ctl.Error = "error"
+			}
+		} else {
+			ctl.Data = rest[colonIdx+1:]
+		}
+		return ctl
+	}
+	return nil
+}
+
+// eyeWireResponse converts an eye control message to the "id:result" / "id!:error" wire form
+func (ctl *ptyControl) eyeWireResponse() string {
+	if ctl.Error != "" {
+		return ctl.ID + "!:" + ctl.Error
+	}
+	return ctl.ID + ":" + ctl.Data
+}
+
+// formatPTYControl encodes a server -> client control message for the given protocol
+func formatPTYControl(protocol string, ctl *ptyControl) []byte {
+	if protocol == ptyProtocolV1 {
+		data, _ := json.Marshal(ctl)
+		return data
+	}
+
+	switch ctl.Type {
+	case ptyMsgIPCResponse:
+		return []byte("IPC_RESPONSE:" + ctl.Data)
+	case ptyMsgMCPCommand:
+		return []byte("MCP_CMD:" + string(ctl.Command))
+	case ptyMsgEyeCommand:
+		return []byte(fmt.Sprintf("EYE_CMD:%s:%s", ctl.ID, ctl.Expr))
+	}
+	return []byte("Error: " + ctl.Error)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keepalive timing for /api/pty sockets
+const (
+	ptyPingInterval = 30 * time.Second
+	ptyPongWait     = 75 * time.Second
+)
+
+// tmuxSession is one fs-<user> session as reported by tmux
+type tmuxSession struct {
+	Username     string `json:"username"`
+	Name         string `json:"name"`
+	Attached     int    `json:"attached"`
+	Created      int64  `json:"created"`
+	LastActivity int64  `json:"lastActivity"`
+}
+
+// reapedSession records a session killed by the reaper
+type reapedSession struct {
+	tmuxSession
+	Reaped int64  `json:"reaped"`
+	Reason string `json:"reason"`
+}
+
+var (
+	reapedSessions   []reapedSession // most recent last
+	reapedSessionsMu sync.Mutex
+)
+
+const maxReapedHistory = 100
+
+// listTmuxSessions returns the FunctionServer tmux sessions of every system user
+func listTmuxSessions() []tmuxSession {
+	entries, err := os.ReadDir(usersDir)
+	if err != nil {
+		return nil
+	}
+
+	var sessions []tmuxSession
+	for _, entry := range entries {
+		username := strings.TrimSuffix(entry.Name(), ".json")
+		user, err := loadUser(username)
+		if err != nil || !user.IsSystemUser {
+			continue
+		}
+		sysUser, err := lookupPTYUser(username)
+		if err != nil {
+			continue
+		}
+
+		// tmux servers are per-uid, so ask as the user
+		out, err := sysUser.command("tmux", "list-sessions", "-F",
+			"#{session_name}\t#{session_attached}\t#{session_created}\t#{session_activity}").Output()
+		if err != nil {
+			continue // no tmux server running for this user
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			fields := strings.Split(line, "\t")
+			if len(fields) != 4 || !strings.HasPrefix(fields[0], "fs-") {
+				continue
+			}
+			attached, _ := strconv.Atoi(fields[1])
+			created, _ := strconv.ParseInt(fields[2], 10, 64)
+			activity, _ := strconv.ParseInt(fields[3], 10, 64)
+			sessions = append(sessions, tmuxSession{
+				Username:     username,
+				Name:         fields[0],
+				Attached:     attached,
+				Created:      created,
+				LastActivity: activity,
+			})
+		}
+	}
+	return sessions
+}
+
+// reapPTYSessions kills detached sessions older than PTY_SESSION_MAX_AGE or
+// idle for longer than PTY_SESSION_IDLE_TIMEOUT
+func reapPTYSessions() {
+	if config.PTYSessionMaxAge <= 0 && config.PTYSessionIdle <= 0 {
+		return
+	}
+	now := time.Now()
+
+	for _, ts := range listTmuxSessions() {
+		if ts.Attached > 0 {
+			continue
+		}
+		var reason string
+		switch {
+		case config.PTYSessionMaxAge > 0 && now.Sub(time.Unix(ts.Created, 0)) > config.PTYSessionMaxAge:
+			reason = "older than " + config.PTYSessionMaxAge.String()
+		case config.PTYSessionIdle > 0 && now.Sub(time.Unix(ts.LastActivity, 0)) > config.PTYSessionIdle:
+			reason = "idle longer than " + config.PTYSessionIdle.String()
+		default:
+			continue
+		}
+		sysUser, err := lookupPTYUser(ts.Username)
+		if err != nil {
+			continue
+		}
+		if err := sysUser.command("tmux", "kill-session", "-t", ts.Name).Run(); err != nil {
+			continue
+		}
+
+		fmt.Printf("[PTY] Reaped detached session %s (%s)\n", ts.Name, reason)
+		reapedSessionsMu.Lock()
+		reapedSessions = append(reapedSessions, reapedSession{
+			tmuxSession: ts,
+			Reaped:      now.Unix(),
+			Reason:      "detached and " + reason,
+		})
+		if len(reapedSessions) > maxReapedHistory {
+			reapedSessions = reapedSessions[len(reapedSessions)-maxReapedHistory:]
+		}
+		reapedSessionsMu.Unlock()
+	}
+}
+
+// runPTYReaper checks for expired sessions every few minutes
+func runPTYReaper() {
+	for {
+		reapPTYSessions()
+		time.Sleep(5 * time.Minute)
+	}
+}
+
+// handleAdminPTYSessions reports live tmux sessions and recent reaps to admins
+func handleAdminPTYSessions(w http.ResponseWriter, r *http.Request) {
+	user := requireAuthUser(r)
+	if user == nil {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+	if !user.IsSystemUser || !isUserInAdminGroup(user.Username) {
+		jsonResponse(w, map[string]string{"error": "Admin access required"}, 403)
+		return
+	}
+
+	sessions := listTmuxSessions()
+	if sessions == nil {
+		sessions = []tmuxSession{}
+	}
+	reapedSessionsMu.Lock()
+	reaped := append([]reapedSession{}, reapedSessions...)
+	reapedSessionsMu.Unlock()
+
+	jsonResponse(w, map[string]interface{}{
+		"sessions":    sessions,
+		"reaped":      reaped,
+		"idleTimeout": config.PTYIdleTimeout.String(),
+		"maxAge":      config.PTYSessionMaxAge.String(),
+		"sessionIdle": config.PTYSessionIdle.String(),
+	}, 200)
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Shared PTY sessions - lets the owner of a live terminal invite others to
+// watch (read-only) or type (read-write). Output from the owner's PTY read
+// loop is fanned out to every attached viewer.
+
+// ptySession is a live PTY owned by one handlePTY connection
+type ptySession struct {
+	ID       string
+	Owner    string
+	Started  time.Time
+	ptmx     *os.File
+	recorder *ptyRecorder // nil unless recording
+	redraw   func()       // asks the owner's tmux to repaint its clients
+	mu       sync.Mutex
+	viewers  map[*ptyViewer]bool
+	shares   map[string]*ptyShare // share ID -> grant
+}
+
+// ptyViewer is a socket attached to someone else's session
+type ptyViewer struct {
+	ID       string
+	Username string // "" for anonymous viewers
+	ShareID  string
+	ReadOnly bool
+	Joined   time.Time
+	conn     *websocket.Conn
+	out      *wsWriter
+}
+
+// ptyShare is a grant allowing others to attach to a session
+type ptyShare struct {
+	ID        string `json:"id"`
+	Token     string `json:"token"`
+	User      string `json:"user,omitempty"` // restrict to one user; "" = any authenticated user
+	ReadOnly  bool   `json:"readOnly"`
+	Anonymous bool   `json:"anonymous"` // one-time token, no login required
+	Used      bool   `json:"used,omitempty"`
+	Created   int64  `json:"created"`
+	claimed   bool   // an anonymous join is upgrading; Used is set once it succeeds
+}
+
+var (
+	ptySessions   = make(map[string]*ptySession) // session ID -> session
+	ptySessionsMu sync.RWMutex
+)
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// registerPTYSession makes a live PTY available for sharing. Input from
+// read-write viewers is recorded by recorder like the owner's; redraw is
+// called when a viewer joins so it receives the current screen.
+func registerPTYSession(owner string, ptmx *os.File, recorder *ptyRecorder, redraw func()) *ptySession {
+	s := &ptySession{
+		ID:       randomHex(8),
+		Owner:    owner,
+		Started:  time.Now(),
+		ptmx:     ptmx,
+		recorder: recorder,
+		redraw:   redraw,
+		viewers:  make(map[*ptyViewer]bool),
+		shares:   make(map[string]*ptyShare),
+	}
+	ptySessionsMu.Lock()
+	ptySessions[s.ID] = s
+	ptySessionsMu.Unlock()
+	return s
+}
+
+// unregisterPTYSession removes the session, drops its shares and disconnects
+// its viewers
+func unregisterPTYSession(s *ptySession) {
+	ptySessionsMu.Lock()
+	delete(ptySessions, s.ID)
+	ptySessionsMu.Unlock()
+
+	s.mu.Lock()
+	viewers := s.viewers
+	s.viewers = make(map[*ptyViewer]bool)
+	s.shares = make(map[string]*ptyShare)
+	s.mu.Unlock()
+
+	for v := range viewers {
+		v.close("Session ended")
+	}
+}
+
+func getPTYSession(id string) *ptySession {
+	ptySessionsMu.RLock()
+	defer ptySessionsMu.RUnlock()
+	return ptySessions[id]
+}
+
+// userPTYSessions lists the live sessions owned by username
+func userPTYSessions(username string) []*ptySession {
+	ptySessionsMu.RLock()
+	defer ptySessionsMu.RUnlock()
+	var result []*ptySession
+	for _, s := range ptySessions {
+		if s.Owner == username {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// findShare resolves a share token to its session and grant
+func findShare(token string) (*ptySession, *ptyShare) {
+	if token == "" {
+		return nil, nil
+	}
+	ptySessionsMu.RLock()
+	defer ptySessionsMu.RUnlock()
+	for _, s := range ptySessions {
+		s.mu.Lock()
+		for _, sh := range s.shares {
+			if sh.Token == token {
+				s.mu.Unlock()
+				return s, sh
+			}
+		}
+		s.mu.Unlock()
+	}
+	return nil, nil
+}
+
+// Broadcast queues PTY output for every viewer. It runs in the owner's read
+// loop, so it never waits: a viewer whose queue is full is disconnected.
+func (s *ptySession) Broadcast(data []byte) {
+	s.mu.Lock()
+	viewers := make([]*ptyViewer, 0, len(s.viewers))
+	for v := range s.viewers {
+		viewers = append(viewers, v)
+	}
+	s.mu.Unlock()
+
+	for _, v := range viewers {
+		// Each viewer's writer keeps its frame, so it gets its own copy
+		out := append([]byte(nil), data...)
+		switch err := v.out.TrySend(websocket.BinaryMessage, out); err {
+		case nil:
+		case errQueueFull:
+			s.removeViewer(v)
+			go v.close("Viewer too slow")
+		default:
+			s.removeViewer(v)
+			v.conn.Close()
+		}
+	}
+}
+
+// Input records keystrokes and writes them to the PTY. The owner and
+// read-write viewers both type through here.
+func (s *ptySession) Input(data []byte) {
+	s.recorder.Input(data)
+	s.ptmx.Write(data)
+}
+
+// addViewer attaches v unless its share was revoked (or the session ended)
+// while it was connecting
+func (s *ptySession) addViewer(v *ptyViewer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.shares[v.ShareID]; !ok {
+		return false
+	}
+	s.viewers[v] = true
+	return true
+}
+
+func (s *ptySession) removeViewer(v *ptyViewer) {
+	s.mu.Lock()
+	delete(s.viewers, v)
+	s.mu.Unlock()
+}
+
+// revokeShare deletes a grant and disconnects everyone who joined with it
+func (s *ptySession) revokeShare(shareID string) bool {
+	s.mu.Lock()
+	_, ok := s.shares[shareID]
+	delete(s.shares, shareID)
+	var kicked []*ptyViewer
+	for v := range s.viewers {
+		if v.ShareID == shareID {
+			kicked = append(kicked, v)
+			delete(s.viewers, v)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, v := range kicked {
+		v.close("Access revoked")
+	}
+	return ok
+}
+
+// kickViewer disconnects a single viewer
+func (s *ptySession) kickViewer(viewerID string) bool {
+	s.mu.Lock()
+	var found *ptyViewer
+	for v := range s.viewers {
+		if v.ID == viewerID {
+			found = v
+			delete(s.viewers, v)
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if found == nil {
+		return false
+	}
+	found.close("Access revoked")
+	return true
+}
+
+// info describes the session, its viewers and grants for the owner
+func (s *ptySession) info() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	viewers := []map[string]interface{}{}
+	for v := range s.viewers {
+		name := v.Username
+		if name == "" {
+			name = "anonymous"
+		}
+		viewers = append(viewers, map[string]interface{}{
+			"id":       v.ID,
+			"username": name,
+			"share":    v.ShareID,
+			"readOnly": v.ReadOnly,
+			"joined":   v.Joined.Unix(),
+		})
+	}
+	shares := []*ptyShare{}
+	for _, sh := range s.shares {
+		shares = append(shares, sh)
+	}
+	return map[string]interface{}{
+		"id":      s.ID,
+		"started": s.Started.Unix(),
+		"viewers": viewers,
+		"shares":  shares,
+	}
+}
+
+func (v *ptyViewer) close(reason string) {
+	v.out.Close()
+	v.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason),
+		time.Now().Add(time.Second))
+	v.conn.Close()
+}
+
+// handlePTYSessions lists the caller's live sessions with viewers and shares
+func handlePTYSessions(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	sessions := []map[string]interface{}{}
+	for _, s := range userPTYSessions(username) {
+		sessions = append(sessions, s.info())
+	}
+	jsonResponse(w, map[string]interface{}{"sessions": sessions}, 200)
+}
+
+// handlePTYShare creates a share grant for one of the caller's sessions
+func handlePTYShare(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	var req struct {
+		Session   string `json:"session"`
+		User      string `json:"user"`
+		ReadOnly  *bool  `json:"readOnly"`
+		Anonymous bool   `json:"anonymous"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, map[string]string{"error": "Invalid request"}, 400)
+		return
+	}
+
+	s := getPTYSession(req.Session)
+	if s == nil || s.Owner != username {
+		jsonResponse(w, map[string]string{"error": "Session not found"}, 404)
+		return
+	}
+	if req.User != "" && !usernameRegex.MatchString(req.User) {
+		jsonResponse(w, map[string]string{"error": "Invalid username"}, 400)
+		return
+	}
+	if req.Anonymous && req.User != "" {
+		jsonResponse(w, map[string]string{"error": "Anonymous shares cannot name a user"}, 400)
+		return
+	}
+
+	share := &ptyShare{
+		ID:        randomHex(4),
+		Token:     randomHex(16),
+		User:      req.User,
+		ReadOnly:  req.ReadOnly == nil || *req.ReadOnly, // read-only unless asked otherwise
+		Anonymous: req.Anonymous,
+		Created:   time.Now().Unix(),
+	}
+	s.mu.Lock()
+	s.shares[share.ID] = share
+	s.mu.Unlock()
+
+	jsonResponse(w, map[string]interface{}{
+		"success": true,
+		"share":   share,
+		"url":     config.APIBase + "/pty/join?share=" + share.Token,
+	}, 200)
+}
+
+// handlePTYRevoke removes a share grant or disconnects a single viewer
+func handlePTYRevoke(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	var req struct {
+		Session string `json:"session"`
+		Share   string `json:"share"`
+		Viewer  string `json:"viewer"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, map[string]string{"error": "Invalid request"}, 400)
+		return
+	}
+
+	s := getPTYSession(req.Session)
+	if s == nil || s.Owner != username {
+		jsonResponse(w, map[string]string{"error": "Session not found"}, 404)
+		return
+	}
+
+	ok := false
+	if req.Share != "" {
+		ok = s.revokeShare(req.Share)
+	} else if req.Viewer != "" {
+		ok = s.kickViewer(req.Viewer)
+	}
+	if !ok {
+		jsonResponse(w, map[string]string{"error": "Share or viewer not found"}, 404)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{"success": true}, 200)
+}
+
+// handlePTYJoin attaches a viewer socket to a shared session.
+// Query: share (grant token), token (session token; optional for anonymous shares)
+func handlePTYJoin(w http.ResponseWriter, r *http.Request) {
+	s, share := findShare(r.URL.Query().Get("share"))
+	if s == nil {
+		http.Error(w, "Share not found", 404)
+		return
+	}
+
+	viewerName := verifyToken(r.URL.Query().Get("token"))
+
+	s.mu.Lock()
+	switch {
+	case share.Anonymous && (share.Used || share.claimed):
+		s.mu.Unlock()
+		http.Error(w, "Share link already used", 410)
+		return
+	case share.Anonymous:
+		share.claimed = true
+	case viewerName == "":
+		s.mu.Unlock()
+		http.Error(w, "Token required", 401)
+		return
+	case share.User != "" && share.User != viewerName:
+		s.mu.Unlock()
+		http.Error(w, "Share is for another user", 403)
+		return
+	}
+	s.mu.Unlock()
+
+	conn, err := ptyUpgrader.Upgrade(w, r, nil)
+	if share.Anonymous {
+		// A failed upgrade leaves the one-time link usable
+		s.mu.Lock()
+		share.claimed = false
+		share.Used = err == nil
+		s.mu.Unlock()
+	}
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	viewer := &ptyViewer{
+		ID:       randomHex(4),
+		Username: viewerName,
+		ShareID:  share.ID,
+		ReadOnly: share.ReadOnly,
+		Joined:   time.Now(),
+		conn:     conn,
+		out:      newWSWriter(conn),
+	}
+	defer viewer.out.Close()
+	if !s.addViewer(viewer) {
+		viewer.close("Access revoked")
+		return
+	}
+	defer s.removeViewer(viewer)
+
+	// Ask tmux to redraw so the viewer sees the current screen
+	if s.redraw != nil {
+		go s.redraw()
+	}
+
+	for {
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if viewer.ReadOnly {
+			continue
+		}
+		// Viewers may type, but control messages (resize, close_session, ...)
+		// belong to the owner's connection only
+		input, ctl, err := parsePTYFrame(conn.Subprotocol(), msgType, msg)
+		if err != nil || ctl != nil {
+			continue
+		}
+		s.Input(input)
+	}
+}
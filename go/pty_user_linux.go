@@ -0,0 +1,232 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	osuser "os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// Linux capability bits checked before switching to another account
+const (
+	capSetgid = 6
+	capSetuid = 7
+)
+
+// ptyUser is the system account a PTY session runs as
+type ptyUser struct {
+	Username string
+	UID      uint32
+	GID      uint32
+	Groups   []uint32
+	HomeDir  string
+	Shell    string
+}
+
+// lookupPTYUser resolves uid, gid, supplementary groups and login shell for a system user
+func lookupPTYUser(username string) (*ptyUser, error) {
+	u, err := osuser.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("system user %s not found: %v", username, err)
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q for %s", u.Uid, username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q for %s", u.Gid, username)
+	}
+
+	pu := &ptyUser{
+		Username: username,
+		UID:      uint32(uid),
+		GID:      uint32(gid),
+		HomeDir:  u.HomeDir,
+		Shell:    getUserShell(username),
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("could not list groups for %s: %v", username, err)
+	}
+	for _, g := range groupIDs {
+		id, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			continue
+		}
+		pu.Groups = append(pu.Groups, uint32(id))
+	}
+
+	if pu.needsSwitch() && !(hasCapability(capSetuid) && hasCapability(capSetgid)) {
+		return nil, fmt.Errorf("server runs as uid %d without CAP_SETUID/CAP_SETGID; cannot start a PTY as %s (uid %d). Run the server as root or grant it those capabilities", os.Geteuid(), username, pu.UID)
+	}
+
+	return pu, nil
+}
+
+// needsSwitch reports whether processes must change credentials to run as this user
+func (pu *ptyUser) needsSwitch() bool {
+	return int(pu.UID) != os.Geteuid()
+}
+
+// command builds an exec.Cmd that runs as the user with a login environment
+func (pu *ptyUser) command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = pu.HomeDir
+	cmd.Env = pu.environ()
+	if pu.needsSwitch() {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid:    pu.UID,
+				Gid:    pu.GID,
+				Groups: pu.Groups,
+			},
+		}
+	}
+	return cmd
+}
+
+// environ returns a fresh login environment; the server's own environment
+// (SESSION_SECRET and friends) is not inherited
+func (pu *ptyUser) environ() []string {
+	env := []string{
+		"HOME=" + pu.HomeDir,
+		"USER=" + pu.Username,
+		"LOGNAME=" + pu.Username,
+		"SHELL=" + pu.Shell,
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"TERM=xterm-256color",
+	}
+	for _, key := range []string{"LANG", "LC_ALL", "TZ"} {
+		if v := os.Getenv(key); v != "" {
+			env = append(env, key+"="+v)
+		}
+	}
+	return env
+}
+
+// start launches cmd on a new PTY, explaining permission failures
+func (pu *ptyUser) start(cmd *exec.Cmd) (*os.File, error) {
+	ptmx, err := pty.Start(cmd)
+	if err != nil && errors.Is(err, syscall.EPERM) {
+		return nil, fmt.Errorf("permission denied switching to %s (uid %d): server needs CAP_SETUID/CAP_SETGID: %v", pu.Username, pu.UID, err)
+	}
+	return ptmx, err
+}
+
+// own hands server-created files in the user's home over to the user
+func (pu *ptyUser) own(paths ...string) {
+	if !pu.needsSwitch() {
+		return
+	}
+	for _, p := range paths {
+		os.Lchown(p, int(pu.UID), int(pu.GID))
+	}
+}
+
+// openInHome opens rel, a path below the user's home, for the server to use
+// on the user's behalf. Nothing below home is followed if it is a symlink,
+// so the user can't point the server at files elsewhere. Missing directories
+// are created (0700) and, with os.O_CREATE, a missing file too; new files and
+// directories are handed to the user. An existing file must be a regular file
+// owned by the user.
+func (pu *ptyUser) openInHome(rel string, flag int, perm os.FileMode) (*os.File, error) {
+	path := filepath.Join(pu.HomeDir, rel)
+	parts := strings.Split(filepath.Clean(rel), "/")
+	for _, name := range parts {
+		if name == "." || name == ".." || name == "" {
+			return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EINVAL}
+		}
+	}
+
+	dirfd, err := syscall.Open(pu.HomeDir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: pu.HomeDir, Err: err}
+	}
+	defer func() { syscall.Close(dirfd) }()
+	for _, name := range parts[:len(parts)-1] {
+		err := syscall.Mkdirat(dirfd, name, 0700)
+		created := err == nil
+		if err != nil && err != syscall.EEXIST {
+			return nil, &os.PathError{Op: "mkdir", Path: path, Err: err}
+		}
+		fd, err := syscall.Openat(dirfd, name, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		syscall.Close(dirfd)
+		dirfd = fd
+		if created {
+			pu.fchown(fd)
+		}
+	}
+
+	// O_NONBLOCK so a FIFO in place of the file can't stall the server
+	name := parts[len(parts)-1]
+	mode := flag&^(os.O_CREATE|os.O_EXCL) | syscall.O_NOFOLLOW | syscall.O_NONBLOCK | syscall.O_CLOEXEC
+	if flag&os.O_CREATE != 0 {
+		fd, err := syscall.Openat(dirfd, name, mode|syscall.O_CREAT|syscall.O_EXCL, uint32(perm.Perm()))
+		if err == nil {
+			pu.fchown(fd)
+			return os.NewFile(uintptr(fd), path), nil
+		}
+		if err != syscall.EEXIST || flag&os.O_EXCL != 0 {
+			return nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
+	}
+	fd, err := syscall.Openat(dirfd, name, mode, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFREG || st.Uid != pu.UID {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("%s: not a regular file owned by %s", path, pu.Username)
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// fchown hands a file the server created to the user
+func (pu *ptyUser) fchown(fd int) {
+	if pu.needsSwitch() {
+		syscall.Fchown(fd, int(pu.UID), int(pu.GID))
+	}
+}
+
+// hasCapability checks the effective capability set of the server process
+func hasCapability(bit uint) bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(line[7:]), 16, 64)
+		if err != nil {
+			return false
+		}
+		return caps&(1<<bit) != 0
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestPTYRunsAsUser starts a PTY the way handlePTY does and checks that the
+// process runs with the target account's uid and a fresh login environment.
+// Set PTY_TEST_USER to pick the account (default "nobody").
+func TestPTYRunsAsUser(t *testing.T) {
+	if !hasCapability(capSetuid) || !hasCapability(capSetgid) {
+		t.Skip("needs CAP_SETUID/CAP_SETGID")
+	}
+	username := os.Getenv("PTY_TEST_USER")
+	if username == "" {
+		username = "nobody"
+	}
+
+	sysUser, err := lookupPTYUser(username)
+	if err != nil {
+		t.Skipf("no usable test user: %v", err)
+	}
+	if !sysUser.needsSwitch() {
+		t.Skipf("%s has the server's own uid", username)
+	}
+
+	// Accounts like nobody have no home; give the session one it can enter
+	if fi, err := os.Stat(sysUser.HomeDir); err != nil || !fi.IsDir() {
+		home, err := os.MkdirTemp("", "ptyhome")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(home)
+		os.Chmod(home, 0755)
+		sysUser.HomeDir = home
+	}
+
+	// Server-only variables must not leak into the session
+	t.Setenv("SESSION_SECRET", "server-secret")
+	t.Setenv("HOME", "/server/home")
+	t.Setenv("USER", "server")
+
+	cmd := sysUser.command("/bin/sh", "-c", `echo "uid=$(id -u)"; echo "home=$HOME"; echo "user=$USER"; echo "secret=$SESSION_SECRET"`)
+	ptmx, err := sysUser.start(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ptmx.Close()
+
+	var out bytes.Buffer
+	io.Copy(&out, ptmx) // ends with EIO once the shell exits
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("shell failed: %v\n%s", err, out.String())
+	}
+
+	got := map[string]string{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			got[k] = v
+		}
+	}
+
+	if want := strconv.FormatUint(uint64(sysUser.UID), 10); got["uid"] != want {
+		t.Errorf("id -u = %q, want %s", got["uid"], want)
+	}
+	if got["home"] != sysUser.HomeDir {
+		t.Errorf("HOME = %q, want %q", got["home"], sysUser.HomeDir)
+	}
+	if got["user"] != username {
+		t.Errorf("USER = %q, want %q", got["user"], username)
+	}
+	if got["secret"] != "" {
+		t.Errorf("SESSION_SECRET leaked into the PTY: %q", got["secret"])
+	}
+}
+
+// openInHome must not let links under home point the server elsewhere
+func TestOpenInHome(t *testing.T) {
+	home, outside := t.TempDir(), t.TempDir()
+	pu := &ptyUser{Username: "test", UID: uint32(os.Geteuid()), GID: uint32(os.Getegid()), HomeDir: home}
+
+	f, err := pu.openInHome(".algo/captures/a.png", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	f, err = pu.openInHome(".algo/captures/a.png", os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("reopening our own file: %v", err)
+	}
+	f.Close()
+
+	target := filepath.Join(outside, "target")
+	os.WriteFile(target, []byte("keep"), 0644)
+	os.Symlink(target, filepath.Join(home, ".algo", "in"))
+	os.Symlink(outside, filepath.Join(home, "linked"))
+	for _, rel := range []string{".algo/in", "linked/target", "linked/new", "../target"} {
+		if f, err := pu.openInHome(rel, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); err == nil {
+			f.Close()
+			t.Errorf("%s: opened through a link", rel)
+		}
+	}
+	if data, _ := os.ReadFile(target); string(data) != "keep" {
+		t.Errorf("target changed to %q", data)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "new")); err == nil {
+		t.Errorf("file created outside home")
+	}
+}
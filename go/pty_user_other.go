@@ -0,0 +1,35 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ptyUser is the system account a PTY session runs as
+type ptyUser struct {
+	Username string
+	HomeDir  string
+	Shell    string
+}
+
+// lookupPTYUser is a stub for non-Linux systems
+func lookupPTYUser(username string) (*ptyUser, error) {
+	return nil, fmt.Errorf("PTY sessions as system users not available on this platform")
+}
+
+func (pu *ptyUser) command(name string, args ...string) *exec.Cmd {
+	return exec.Command(name, args...)
+}
+
+func (pu *ptyUser) start(cmd *exec.Cmd) (*os.File, error) {
+	return nil, fmt.Errorf("PTY sessions as system users not available on this platform")
+}
+
+func (pu *ptyUser) own(paths ...string) {}
+
+func (pu *ptyUser) openInHome(rel string, flag int, perm os.FileMode) (*os.File, error) {
+	return nil, fmt.Errorf("PTY sessions as system users not available on this platform")
+}
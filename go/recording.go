@@ -0,0 +1,468 @@
+package main
+
+// PTY session recording in asciicast v2 format
+// https://docs.asciinema.org/manual/asciicast/v2/
+//
+// Files live at DATA_DIR/recordings/<username>/<id>.cast and contain a JSON
+// header line followed by one [elapsed, code, data] event per line:
+//   "o" - output read from the PTY
+//   "i" - input written to the PTY (only when PTY_RECORD_INPUT is enabled)
+//   "r" - terminal resize, data is "COLSxROWS"
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	recordingsDir    string
+	recordingIDRegex = regexp.MustCompile(`^[0-9A-Za-z_-]{1,64}$`)
+)
+
+// castHeader is the first line of an asciicast v2 file
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// ptyRecorder appends PTY traffic to an asciicast file.
+// All methods are safe to call on a nil recorder (recording disabled).
+type ptyRecorder struct {
+	f       *os.File
+	w       *bufio.Writer
+	start   time.Time
+	input   string
+	mu      sync.Mutex
+	pending map[string][]byte // partial UTF-8 sequences per event code
+	header  *castHeader       // pending until the terminal size is known
+	early   bytes.Buffer      // events recorded while the header is pending
+}
+
+// The header waits for the client's first resize so the cast starts at the
+// real terminal size; after this long (or this much output) the initial
+// size is used instead
+const (
+	castSizeWait    = 2 * time.Second
+	castEarlyBuffer = 64 << 10
+)
+
+func userRecordingsDir(username string) string {
+	return filepath.Join(recordingsDir, username)
+}
+
+// startPTYRecording opens a new recording for username, or returns nil if
+// recording is disabled. cols and rows are the initial PTY size, used in the
+// header if the client does not send a resize first.
+func startPTYRecording(username, shell string, cols, rows uint16) (*ptyRecorder, error) {
+	if !config.RecordPTY {
+		return nil, nil
+	}
+
+	dir := userRecordingsDir(username)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	randBytes := make([]byte, 3)
+	rand.Read(randBytes)
+	id := now.Format("20060102-150405") + "-" + hex.EncodeToString(randBytes)
+
+	f, err := os.OpenFile(filepath.Join(dir, id+".cast"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if cols == 0 || rows == 0 {
+		cols, rows = 80, 24
+	}
+
+	rec := &ptyRecorder{
+		f:       f,
+		w:       bufio.NewWriter(f),
+		start:   now,
+		input:   config.RecordInput,
+		pending: make(map[string][]byte),
+		header: &castHeader{
+			Version:   2,
+			Width:     int(cols),
+			Height:    int(rows),
+			Timestamp: now.Unix(),
+			Title:     "fs-" + username,
+			Env:       map[string]string{"SHELL": shell, "TERM": "xterm-256color"},
+		},
+	}
+	return rec, nil
+}
+
+// Output records bytes read from the PTY
+func (rec *ptyRecorder) Output(data []byte) {
+	rec.event("o", data)
+}
+
+// Input records bytes written to the PTY according to PTY_RECORD_INPUT
+func (rec *ptyRecorder) Input(data []byte) {
+	if rec == nil {
+		return
+	}
+	switch rec.input {
+	case "full":
+		rec.event("i", data)
+	case "redacted":
+		rec.event("i", redactInput(data))
+	}
+}
+
+// Resize records a terminal size change. The first resize sets the header
+// size instead of adding an event.
+func (rec *ptyRecorder) Resize(cols, rows uint16) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	if rec.header != nil {
+		rec.header.Width, rec.header.Height = int(cols), int(rows)
+		rec.writeHeader()
+		rec.w.Flush()
+		rec.mu.Unlock()
+		return
+	}
+	rec.mu.Unlock()
+	rec.event("r", []byte(fmt.Sprintf("%dx%d", cols, rows)))
+}
+
+// Close flushes and closes the recording file
+func (rec *ptyRecorder) Close() {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.writeHeader()
+	rec.w.Flush()
+	rec.f.Close()
+}
+
+// writeHeader emits the pending header line followed by any events held
+// back while waiting for it; callers hold rec.mu
+func (rec *ptyRecorder) writeHeader() {
+	if rec.header == nil {
+		return
+	}
+	header, _ := json.Marshal(rec.header)
+	rec.w.Write(header)
+	rec.w.WriteByte('\n')
+	rec.w.Write(rec.early.Bytes())
+	rec.early.Reset()
+	rec.header = nil
+}
+
+func (rec *ptyRecorder) event(code string, data []byte) {
+	if rec == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	// PTY reads can split multi-byte characters; hold back the incomplete
+	// tail so every event is valid UTF-8
+	buf := append(rec.pending[code], data...)
+	cut := utf8Boundary(buf)
+	rec.pending[code] = append([]byte(nil), buf[cut:]...)
+	if cut == 0 {
+		return
+	}
+
+	elapsed := time.Since(rec.start)
+	line, _ := json.Marshal([]interface{}{elapsed.Seconds(), code, string(buf[:cut])})
+	if rec.header != nil && elapsed < castSizeWait && rec.early.Len() < castEarlyBuffer {
+		rec.early.Write(line)
+		rec.early.WriteByte('\n')
+		return
+	}
+	rec.writeHeader()
+	rec.w.Write(line)
+	rec.w.WriteByte('\n')
+	rec.w.Flush()
+}
+
+// utf8Boundary returns the length of the longest prefix of b that does not
+// end in the middle of a UTF-8 sequence
+func utf8Boundary(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(b)
+}
+
+// redactInput masks printable keystrokes but keeps control characters
+// (Enter, Ctrl+C, arrows) so the recording still shows what happened
+func redactInput(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for _, r := range string(data) {
+		if r < 0x20 || r == 0x7f {
+			out = append(out, byte(r))
+		} else {
+			out = append(out, '*')
+		}
+	}
+	return out
+}
+
+// recordingPath validates a recording ID and returns its file path
+func recordingPath(username, id string) (string, bool) {
+	if !recordingIDRegex.MatchString(id) {
+		return "", false
+	}
+	return filepath.Join(userRecordingsDir(username), id+".cast"), true
+}
+
+// pruneRecordings removes recordings older than the retention period
+func pruneRecordings() {
+	if config.RecordRetention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-config.RecordRetention)
+	users, err := os.ReadDir(recordingsDir)
+	if err != nil {
+		return
+	}
+	for _, u := range users {
+		if !u.IsDir() {
+			continue
+		}
+		dir := filepath.Join(recordingsDir, u.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !strings.HasSuffix(entry.Name(), ".cast") {
+				continue
+			}
+			if info.ModTime().Before(cutoff) {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+}
+
+// runRecordingRetention prunes expired recordings once an hour
+func runRecordingRetention() {
+	for {
+		pruneRecordings()
+		time.Sleep(time.Hour)
+	}
+}
+
+func handleRecordingList(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	entries, _ := os.ReadDir(userRecordingsDir(username))
+	recordings := []map[string]interface{}{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".cast") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, map[string]interface{}{
+			"id":       strings.TrimSuffix(entry.Name(), ".cast"),
+			"size":     info.Size(),
+			"modified": info.ModTime().Unix(),
+		})
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i]["id"].(string) > recordings[j]["id"].(string)
+	})
+
+	jsonResponse(w, map[string]interface{}{
+		"recordings": recordings,
+		"enabled":    config.RecordPTY,
+		"input":      config.RecordInput,
+		"retention":  config.RecordRetention.String(),
+	}, 200)
+}
+
+func handleRecordingGet(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	path, ok := recordingPath(username, id)
+	if !ok {
+		jsonResponse(w, map[string]string{"error": "Invalid recording id"}, 400)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		jsonResponse(w, map[string]string{"error": "Recording not found"}, 404)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.cast"`)
+	http.ServeContent(w, r, id+".cast", time.Time{}, f)
+}
+
+func handleRecordingDelete(w http.ResponseWriter, r *http.Request) {
+	username := requireAuth(r)
+	if username == "" {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonResponse(w, map[string]string{"error": "Invalid request"}, 400)
+		return
+	}
+
+	path, ok := recordingPath(username, req.ID)
+	if !ok {
+		jsonResponse(w, map[string]string{"error": "Invalid recording id"}, 400)
+		return
+	}
+	if err := os.Remove(path); err != nil {
+		jsonResponse(w, map[string]string{"error": "Recording not found"}, 404)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{"success": true}, 200)
+}
+
+// handleRecordingReplay streams a recording over WebSocket with its original timing.
+// Query: token, id, speed (default 1, 0 = as fast as possible), idle (max seconds of pause)
+// Frames: text header JSON, then binary output data and "RESIZE:cols:rows" text frames,
+// ending with a "REPLAY_END" text frame
+func handleRecordingReplay(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	username := verifyToken(query.Get("token"))
+	if username == "" {
+		http.Error(w, "Invalid token", 401)
+		return
+	}
+
+	path, ok := recordingPath(username, query.Get("id"))
+	if !ok {
+		http.Error(w, "Invalid recording id", 400)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, "Recording not found", 404)
+		return
+	}
+	defer f.Close()
+
+	speed := 1.0
+	if s := query.Get("speed"); s != "" {
+		if v, err := strconv.ParseFloat(s, 64); err == nil && v >= 0 {
+			speed = v
+		}
+	}
+	idleLimit := 0.0
+	if s := query.Get("idle"); s != "" {
+		if v, err := strconv.ParseFloat(s, 64); err == nil && v > 0 {
+			idleLimit = v
+		}
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Stop when the viewer goes away
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	if !scanner.Scan() {
+		return
+	}
+	conn.WriteMessage(websocket.TextMessage, scanner.Bytes())
+
+	last := 0.0
+	for scanner.Scan() {
+		var ev []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || len(ev) != 3 {
+			continue
+		}
+		at, _ := ev[0].(float64)
+		code, _ := ev[1].(string)
+		data, _ := ev[2].(string)
+
+		pause := at - last
+		last = at
+		if idleLimit > 0 && pause > idleLimit {
+			pause = idleLimit
+		}
+		if speed > 0 && pause > 0 {
+			select {
+			case <-time.After(time.Duration(pause / speed * float64(time.Second))):
+			case <-done:
+				return
+			}
+		}
+
+		switch code {
+		case "o":
+			err = conn.WriteMessage(websocket.BinaryMessage, []byte(data))
+		case "r":
+			err = conn.WriteMessage(websocket.TextMessage, []byte("RESIZE:"+strings.Replace(data, "x", ":", 1)))
+		}
+		if err != nil {
+			return
+		}
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("REPLAY_END"))
+}
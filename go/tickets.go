@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tickets
+//
+// Each ticket is a JSON file in DATA_DIR/tickets, like user accounts in
+// DATA_DIR/users. DATA_DIR/tickets/seq holds the last ID handed out, so IDs
+// keep counting across restarts and deleted IDs aren't reused.
+//
+// Status moves open -> in_progress -> done, and any unfinished ticket can be
+// closed. A done or closed ticket can be reopened, and work in progress can
+// go back to open.
+//
+//   GET    /api/tickets                   list
+//   POST   /api/tickets                   {title, description} create
+//   GET    /api/tickets/{id}              one ticket
+//   PATCH  /api/tickets/{id}              {title?, description?, status?} (signed in)
+//   DELETE /api/tickets/{id}              (admins)
+//   POST   /api/tickets/{id}/replies      {text} (signed in)
+
+const (
+	ticketOpen       = "open"
+	ticketInProgress = "in_progress"
+	ticketDone       = "done"
+	ticketClosed     = "closed"
+)
+
+// ticketTransitions lists the statuses each status can move to
+var ticketTransitions = map[string][]string{
+	ticketOpen:       {ticketInProgress, ticketClosed},
+	ticketInProgress: {ticketOpen, ticketDone, ticketClosed},
+	ticketDone:       {ticketOpen},
+	ticketClosed:     {ticketOpen},
+}
+
+var ticketIDRegex = regexp.MustCompile(`^T[1-9][0-9]{0,8}$`)
+
+// Ticket represents a task/issue
+type Ticket struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Description string  `json:"description,omitempty"`
+	Status      string  `json:"status"`
+	Created     string  `json:"created"`
+	Updated     string  `json:"updated,omitempty"`
+	Replies     []Reply `json:"replies,omitempty"`
+}
+
+type Reply struct {
+	Date   string `json:"date"`
+	Author string `json:"author,omitempty"`
+	Text   string `json:"text"`
+}
+
+// ticketMutex serialises changes to the ticket files
+var ticketMutex sync.Mutex
+
+func ticketTime() string {
+	return time.Now().Format("2006-01-02 15:04")
+}
+
+func ticketNumber(id string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(id, "T"))
+	return n
+}
+
+func getTicketFile(id string) string {
+	return filepath.Join(ticketsDir, id+".json")
+}
+
+func loadTicket(id string) (*Ticket, error) {
+	if !ticketIDRegex.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(getTicketFile(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var t Ticket
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func saveTicket(t *Ticket) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getTicketFile(t.ID), data, 0644)
+}
+
+// listTickets returns every ticket, oldest first
+func listTickets() ([]Ticket, error) {
+	entries, err := os.ReadDir(ticketsDir)
+	if err != nil {
+		return nil, err
+	}
+	list := []Ticket{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !ticketIDRegex.MatchString(id) {
+			continue
+		}
+		t, err := loadTicket(id)
+		if err != nil {
+			fmt.Printf("[Tickets] Skipping %s: %v\n", e.Name(), err)
+			continue
+		}
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return ticketNumber(list[i].ID) < ticketNumber(list[j].ID) })
+	return list, nil
+}
+
+// nextTicketID hands out the next ID: one past the larger of seq and the
+// highest ticket on disk, so a damaged or restored seq file can't hand out
+// an ID that is in use. Caller holds ticketMutex.
+func nextTicketID() (string, error) {
+	seqFile := filepath.Join(ticketsDir, "seq")
+	last := 0
+	if data, err := os.ReadFile(seqFile); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil && n > 0 {
+			last = n
+		}
+	}
+	list, err := listTickets()
+	if err != nil {
+		return "", err
+	}
+	if len(list) > 0 {
+		last = max(last, ticketNumber(list[len(list)-1].ID))
+	}
+	last++
+	if err := os.WriteFile(seqFile, []byte(strconv.Itoa(last)+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("T%d", last), nil
+}
+
+// createTicket opens a ticket. The file is created exclusively, so an
+// existing ticket is never overwritten.
+func createTicket(title, description string) (Ticket, error) {
+	ticketMutex.Lock()
+	defer ticketMutex.Unlock()
+	for {
+		id, err := nextTicketID()
+		if err != nil {
+			return Ticket{}, err
+		}
+		t := Ticket{
+			ID:          id,
+			Title:       title,
+			Description: description,
+			Status:      ticketOpen,
+			Created:     ticketTime(),
+		}
+		data, err := json.MarshalIndent(t, "", "  ")
+		if err != nil {
+			return Ticket{}, err
+		}
+		f, err := os.OpenFile(getTicketFile(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue // unreadable ticket file listTickets skipped; take the next ID
+		}
+		if err != nil {
+			return Ticket{}, err
+		}
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return t, err
+	}
+}
+
+// updateTicket applies change to a ticket and saves it
+func updateTicket(id string, change func(t *Ticket) error) (*Ticket, error) {
+	ticketMutex.Lock()
+	defer ticketMutex.Unlock()
+	t, err := loadTicket(id)
+	if os.IsNotExist(err) {
+		return nil, &apiError{404, "Ticket not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := change(t); err != nil {
+		return nil, err
+	}
+	t.Updated = ticketTime()
+	return t, saveTicket(t)
+}
+
+// setTicketStatus moves a ticket to status if the transition is allowed
+func setTicketStatus(t *Ticket, status string) error {
+	if status == t.Status {
+		return nil
+	}
+	if _, ok := ticketTransitions[status]; !ok {
+		return &apiError{400, fmt.Sprintf("unknown status %q (open, in_progress, done, closed)", status)}
+	}
+	for _, next := range ticketTransitions[t.Status] {
+		if next == status {
+			t.Status = status
+			return nil
+		}
+	}
+	return &apiError{409, fmt.Sprintf("cannot move ticket from %s to %s", t.Status, status)}
+}
+
+// editTicket changes a ticket's title, description and status; nil fields
+// are left as they are
+func editTicket(id string, title, description, status *string) (*Ticket, error) {
+	return updateTicket(id, func(t *Ticket) error {
+		if title != nil {
+			if strings.TrimSpace(*title) == "" {
+				return &apiError{400, "title required"}
+			}
+			t.Title = strings.TrimSpace(*title)
+		}
+		if description != nil {
+			t.Description = *description
+		}
+		if status != nil {
+			return setTicketStatus(t, *status)
+		}
+		return nil
+	})
+}
+
+func addTicketReply(t *Ticket, author, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return &apiError{400, "text required"}
+	}
+	t.Replies = append(t.Replies, Reply{Date: ticketTime(), Author: author, Text: text})
+	return nil
+}
+
+func deleteTicket(id string) error {
+	ticketMutex.Lock()
+	defer ticketMutex.Unlock()
+	if !ticketIDRegex.MatchString(id) {
+		return &apiError{404, "Ticket not found"}
+	}
+	err := os.Remove(getTicketFile(id))
+	if os.IsNotExist(err) {
+		return &apiError{404, "Ticket not found"}
+	}
+	return err
+}
+
+func setTicketCORS(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+}
+
+// handleTickets lists and creates tickets
+func handleTickets(w http.ResponseWriter, r *http.Request) {
+	setTicketCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method == "POST" {
+		var req struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Title) == "" {
+			jsonResponse(w, map[string]string{"error": "title required"}, 400)
+			return
+		}
+		t, err := createTicket(strings.TrimSpace(req.Title), req.Description)
+		if err != nil {
+			apiErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, map[string]interface{}{"success": true, "ticket": t}, 200)
+		return
+	}
+
+	list, err := listTickets()
+	if err != nil {
+		apiErrorResponse(w, err)
+		return
+	}
+	jsonResponse(w, map[string]interface{}{"tickets": list}, 200)
+}
+
+// handleTicket serves /api/tickets/{id} and /api/tickets/{id}/replies
+func handleTicket(w http.ResponseWriter, r *http.Request) {
+	setTicketCORS(w)
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/tickets/"), "/")
+
+	if sub == "replies" {
+		if r.Method != "POST" {
+			jsonResponse(w, map[string]string{"error": "Method not allowed"}, 405)
+			return
+		}
+		username := requireAuth(r)
+		if username == "" {
+			jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+			return
+		}
+		var req struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		t, err := updateTicket(id, func(t *Ticket) error { return addTicketReply(t, username, req.Text) })
+		if err != nil {
+			apiErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, map[string]interface{}{"success": true, "ticket": t}, 200)
+		return
+	}
+	if sub != "" {
+		jsonResponse(w, map[string]string{"error": "Not found"}, 404)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		t, err := loadTicket(id)
+		if os.IsNotExist(err) {
+			jsonResponse(w, map[string]string{"error": "Ticket not found"}, 404)
+			return
+		}
+		if err != nil {
+			apiErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, map[string]interface{}{"ticket": t}, 200)
+
+	case "PATCH":
+		if requireAuth(r) == "" {
+			jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+			return
+		}
+		var req struct {
+			Title       *string `json:"title"`
+			Description *string `json:"description"`
+			Status      *string `json:"status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonResponse(w, map[string]string{"error": "Invalid request"}, 400)
+			return
+		}
+		t, err := editTicket(id, req.Title, req.Description, req.Status)
+		if err != nil {
+			apiErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, map[string]interface{}{"success": true, "ticket": t}, 200)
+
+	case "DELETE":
+		user := requireAuthUser(r)
+		if user == nil {
+			jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+			return
+		}
+		if !user.IsSystemUser || !isUserInAdminGroup(user.Username) {
+			jsonResponse(w, map[string]string{"error": "Admin access required"}, 403)
+			return
+		}
+		if err := deleteTicket(id); err != nil {
+			apiErrorResponse(w, err)
+			return
+		}
+		jsonResponse(w, map[string]interface{}{"success": true}, 200)
+
+	default:
+		jsonResponse(w, map[string]string{"error": "Method not allowed"}, 405)
+	}
+}
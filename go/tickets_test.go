@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A damaged or stale seq file must not hand out an ID that is in use
+func TestCreateTicketKeepsExisting(t *testing.T) {
+	for name, seq := range map[string]string{
+		"garbage": "not a number\n",
+		"behind":  "1\n",
+		"missing": "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			ticketsDir = t.TempDir()
+			if seq != "" {
+				os.WriteFile(filepath.Join(ticketsDir, "seq"), []byte(seq), 0644)
+			}
+			for _, id := range []string{"T1", "T3"} {
+				if err := saveTicket(&Ticket{ID: id, Title: "existing " + id, Status: ticketOpen}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			created, err := createTicket("new", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created.ID != "T4" {
+				t.Errorf("new ticket got ID %s, want T4", created.ID)
+			}
+			for _, id := range []string{"T1", "T3"} {
+				old, err := loadTicket(id)
+				if err != nil || old.Title != "existing "+id {
+					t.Errorf("%s after create: %+v, %v", id, old, err)
+				}
+			}
+		})
+	}
+}
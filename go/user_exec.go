@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Commands for MCP tools
+//
+// algo_exec and command tools from ~/.algo/mcp-tools.json run through
+// runUserCommand: with the user's system account and login environment
+// (see pty_user_linux.go), without a shell. The command line is split into
+// words, so quoting works but pipes, redirects, substitutions and the like
+// are refused, and only the first word is checked against the allow-list.
+
+// execOutput is a finished command. Cwd is only set after "cd".
+type execOutput struct {
+	Output string
+	Cwd    string
+	Error  string // e.g. "exit status 1"; Output is still valid
+}
+
+// execWorkDir resolves a requested working directory, falling back to home
+// when it is missing or outside home
+func execWorkDir(homeDir, cwd string) string {
+	if cwd == "" || cwd == "~" {
+		return homeDir
+	}
+	resolved, err := resolveHomePath(homeDir, cwd)
+	if err != nil {
+		return homeDir
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return homeDir
+	}
+	return resolved
+}
+
+// execCd validates "cd target" from workDir and returns the new directory
+// in "~" form
+func execCd(homeDir, workDir, target string) (string, error) {
+	newDir := homeDir
+	switch {
+	case target == "~" || target == "":
+	case target == "..":
+		newDir = filepath.Dir(workDir)
+	case strings.HasPrefix(target, "~/"):
+		newDir = filepath.Join(homeDir, target[2:])
+	case filepath.IsAbs(target):
+		newDir = target
+	default:
+		newDir = filepath.Join(workDir, target)
+	}
+	resolved, err := filepath.Abs(newDir)
+	if err != nil || !strings.HasPrefix(resolved, homeDir) {
+		return "", &apiError{403, "Access denied"}
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", &apiError{400, "Not a directory"}
+	}
+	if display := homeDisplayPath(homeDir, resolved); display != "" {
+		return display, nil
+	}
+	return "~", nil
+}
+
+// splitCommand splits a command line into words the way sh would for a
+// simple command: '…' and "…" quote, backslash escapes, and a leading ~ is
+// the home directory. Anything that needs a shell is an error.
+func splitCommand(line, homeDir string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	var quote rune
+
+	flush := func() {
+		if !inWord {
+			return
+		}
+		w := word.String()
+		if !quoted && (w == "~" || strings.HasPrefix(w, "~/")) {
+			w = homeDir + w[1:]
+		}
+		words = append(words, w)
+		word.Reset()
+		inWord, quoted = false, false
+	}
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`+"`", runes[i+1]):
+				i++
+				word.WriteRune(runes[i])
+			case c == '$' || c == '`':
+				return nil, fmt.Errorf("shell syntax not supported: %c", c)
+			default:
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord, quoted = c, true, true
+		case c == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			word.WriteRune(runes[i])
+			inWord, quoted = true, true
+		case c == ' ' || c == '\t':
+			flush()
+		case strings.ContainsRune(";&|<>()$`*?[]{}!#\n\r", c):
+			return nil, fmt.Errorf("shell syntax not supported: %c (commands run without a shell)", c)
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	flush()
+	return words, nil
+}
+
+// runUserCommand runs an allow-listed command as username in cwd (relative
+// to their home). A command that ran but failed is reported in
+// execOutput.Error, not as an error.
+func runUserCommand(ctx context.Context, username, command, cwd string) (*execOutput, error) {
+	// Like PTY sessions, only accounts that logged in as a system user may
+	// run as one; a web account named "root" must not
+	user, err := loadUser(username)
+	if err != nil || !user.IsSystemUser {
+		return nil, &apiError{403, "Commands require system user login"}
+	}
+	sysUser, err := lookupPTYUser(username)
+	if err != nil {
+		return nil, &apiError{403, "Commands run as your system account: " + err.Error()}
+	}
+	homeDir := sysUser.HomeDir
+	workDir := execWorkDir(homeDir, cwd)
+
+	argv, err := splitCommand(strings.TrimSpace(command), homeDir)
+	if err != nil {
+		return nil, &apiError{400, err.Error()}
+	}
+	if len(argv) == 0 {
+		return nil, &apiError{400, "No command provided"}
+	}
+
+	switch {
+	case argv[0] == "cd":
+		target := ""
+		if len(argv) > 1 {
+			target = argv[1]
+		}
+		display, err := execCd(homeDir, workDir, target)
+		if err != nil {
+			return nil, err
+		}
+		return &execOutput{Cwd: display}, nil
+	case argv[0] == "help":
+		return &execOutput{Output: "Available commands: " + strings.Join(allowedCmds, ", ")}, nil
+	case !isCommandAllowed(argv[0]):
+		return nil, &apiError{403, fmt.Sprintf("Command not allowed: %s", argv[0])}
+	}
+
+	cmd := sysUser.command(argv[0], argv[1:]...)
+	cmd.Dir = workDir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		return &execOutput{Error: err.Error()}, nil
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		err = ctx.Err()
+	}
+
+	res := &execOutput{Output: strings.TrimRight(out.String(), "\n")}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	ok := []struct {
+		line string
+		want []string
+	}{
+		{"ls -la", []string{"ls", "-la"}},
+		{`grep -rn 'a; b' notes`, []string{"grep", "-rn", "a; b", "notes"}},
+		{`echo "say \"hi\""`, []string{"echo", `say "hi"`}},
+		{`echo 'it'\''s'`, []string{"echo", "it's"}},
+		{`cat ~/notes.txt '~/literal'`, []string{"cat", "/home/u/notes.txt", "~/literal"}},
+		{`echo a\|b`, []string{"echo", "a|b"}},
+		{`echo ''`, []string{"echo", ""}},
+	}
+	for _, tc := range ok {
+		got, err := splitCommand(tc.line, "/home/u")
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %q, %v; want %q", tc.line, got, err, tc.want)
+		}
+	}
+
+	for _, line := range []string{
+		"ls; cat /etc/shadow",
+		"ls && id",
+		"cat notes | sh",
+		"echo x > ~/.bashrc",
+		"echo $(id)",
+		"echo `id`",
+		`echo "$HOME"`,
+		"ls *.txt",
+		"echo 'unterminated",
+		"ls\nid",
+	} {
+		if got, err := splitCommand(line, "/home/u"); err == nil {
+			t.Errorf("%q: got %q, want an error", line, got)
+		}
+	}
+}
+
+// A web account sharing a system account's name must not run as it
+func TestRunUserCommandNeedsSystemLogin(t *testing.T) {
+	old := usersDir
+	usersDir = t.TempDir()
+	t.Cleanup(func() { usersDir = old })
+	if err := saveUser(&User{Username: "root"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"root", "nobody-registered"} {
+		_, err := runUserCommand(context.Background(), username, "id", "")
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.status != 403 {
+			t.Errorf("%s: got %v, want a 403", username, err)
+		}
+	}
+}
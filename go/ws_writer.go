@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Outgoing WebSocket frames
+//
+// gorilla/websocket allows one writer at a time, but browser, eye and PTY
+// viewer sockets are written from many goroutines: PTY output, MCP and eye
+// commands, captures, event fan-out. Each such socket gets a wsWriter, a
+// goroutine that owns the socket's writes; everyone else queues frames for
+// it. Ping and close frames still use WriteControl, which is safe alongside
+// it.
+//
+// Senders block while the queue is full, which keeps PTY output from
+// outrunning a slow browser; shared-PTY viewers use TrySend instead, so a
+// slow viewer can't hold up the owner. A write that fails or stalls for
+// wsWriteTimeout closes the socket, so the reader sees the disconnect too.
+
+const (
+	wsQueueSize    = 256
+	wsWriteTimeout = 10 * time.Second
+)
+
+var (
+	errWriterClosed = errors.New("connection closed")
+	errQueueFull    = errors.New("send queue full")
+)
+
+type wsFrame struct {
+	msgType int
+	data    []byte
+}
+
+type wsWriter struct {
+	conn  *websocket.Conn
+	queue chan wsFrame
+	done  chan struct{}
+	once  sync.Once
+	err   error // why the writer stopped; set before done is closed
+
+	sent     atomic.Uint64
+	maxDepth atomic.Int64
+}
+
+// wsWriterStats is a writer's queue metrics
+type wsWriterStats struct {
+	Queued    int    `json:"queued"`
+	MaxQueued int64  `json:"maxQueued"`
+	Sent      uint64 `json:"sent"`
+}
+
+func newWSWriter(conn *websocket.Conn) *wsWriter {
+	w := &wsWriter{
+		conn:  conn,
+		queue: make(chan wsFrame, wsQueueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *wsWriter) run() {
+	for {
+		select {
+		case f := <-w.queue:
+			w.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := w.conn.WriteMessage(f.msgType, f.data); err != nil {
+				w.stop(err)
+				w.conn.Close()
+				return
+			}
+			w.sent.Add(1)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Send queues a frame, waiting for room until ctx is done. data must not
+// be modified afterwards.
+func (w *wsWriter) Send(ctx context.Context, msgType int, data []byte) error {
+	select {
+	case <-w.done:
+		return w.err
+	default:
+	}
+	select {
+	case w.queue <- wsFrame{msgType, data}:
+		depth := int64(len(w.queue))
+		for max := w.maxDepth.Load(); depth > max && !w.maxDepth.CompareAndSwap(max, depth); max = w.maxDepth.Load() {
+		}
+		return nil
+	case <-w.done:
+		return w.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySend queues a frame without waiting; it fails with errQueueFull when
+// the receiver has fallen wsQueueSize frames behind
+func (w *wsWriter) TrySend(msgType int, data []byte) error {
+	select {
+	case <-w.done:
+		return w.err
+	default:
+	}
+	select {
+	case w.queue <- wsFrame{msgType, data}:
+		return nil
+	case <-w.done:
+		return w.err
+	default:
+		return errQueueFull
+	}
+}
+
+// Text queues a text frame
+func (w *wsWriter) Text(data []byte) error {
+	return w.Send(context.Background(), websocket.TextMessage, data)
+}
+
+// Close stops the writer; frames still queued are dropped
+func (w *wsWriter) Close() {
+	w.stop(errWriterClosed)
+}
+
+func (w *wsWriter) stop(err error) {
+	w.once.Do(func() {
+		w.err = err
+		close(w.done)
+	})
+}
+
+func (w *wsWriter) Stats() wsWriterStats {
+	return wsWriterStats{Queued: len(w.queue), MaxQueued: w.maxDepth.Load(), Sent: w.sent.Load()}
+}
+
+// handleAdminBridgeMetrics reports send queues and pending requests for
+// every browser and eye connection
+func handleAdminBridgeMetrics(w http.ResponseWriter, r *http.Request) {
+	user := requireAuthUser(r)
+	if user == nil {
+		jsonResponse(w, map[string]string{"error": "Authorization required"}, 401)
+		return
+	}
+	if !user.IsSystemUser || !isUserInAdminGroup(user.Username) {
+		jsonResponse(w, map[string]string{"error": "Admin access required"}, 403)
+		return
+	}
+
+	type connMetrics struct {
+		User    string `json:"user"`
+		ID      string `json:"id,omitempty"`
+		Kind    string `json:"kind"`
+		Pending int    `json:"pending"`
+		wsWriterStats
+	}
+	conns := []connMetrics{}
+	queued, pending := 0, 0
+
+	browserConnMu.RLock()
+	for username, list := range browserConnections {
+		for _, bc := range list {
+			bc.mu.Lock()
+			n := len(bc.Responses)
+			bc.mu.Unlock()
+			m := connMetrics{User: username, ID: bc.ID, Kind: bc.Kind, Pending: n, wsWriterStats: bc.out.Stats()}
+			conns = append(conns, m)
+			queued += m.Queued
+			pending += n
+		}
+	}
+	browserConnMu.RUnlock()
+
+	eyeConnMu.RLock()
+	for username, list := range eyeConnections {
+		for _, ec := range list {
+			eyeRequestsMu.Lock()
+			n := ec.inFlight
+			eyeRequestsMu.Unlock()
+			m := connMetrics{User: username, Kind: "eye", Pending: n, wsWriterStats: ec.out.Stats()}
+			conns = append(conns, m)
+			queued += m.Queued
+			pending += n
+		}
+	}
+	eyeConnMu.RUnlock()
+
+	jsonResponse(w, map[string]interface{}{
+		"connections": conns,
+		"queued":      queued,
+		"pending":     pending,
+		"queueSize":   wsQueueSize,
+	}, 200)
+}
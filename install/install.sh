@@ -199,6 +199,8 @@ echo -e "${YELLOW}Building...${NC}"
 cd "$INSTALL_DIR/go"
 go mod download
 go build -o functionserver main.go
+go build -o algo ./cmd/algo
+$SUDO ln -sf "$INSTALL_DIR/go/algo" /usr/local/bin/algo 2>/dev/null || true
 
 # Create data directories
 mkdir -p "$INSTALL_DIR/data/users"